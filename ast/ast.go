@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
 	"strings"
 
 	"github.com/clg0803/circus/token"
@@ -10,6 +11,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // first char of the node
+	End() token.Position // just past the last char of the node
 }
 
 // Statement 不产生值 Expression 产生值
@@ -25,6 +28,13 @@ type Expression interface {
 	expressionNode()
 }
 
+// Pattern is matched against a value and binds its identifiers,
+// used by match arms, destructuring let and function parameters
+type Pattern interface {
+	Node
+	patternNode()
+}
+
 type Program struct {
 	Statements []Statement
 }
@@ -37,6 +47,20 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if n := len(p.Statements); n > 0 {
+		return p.Statements[n-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -49,19 +73,34 @@ func (p *Program) String() string {
 
 // implememt Statement interface
 type LetStatement struct {
-	Token token.Token // token.LET
-	Name  *Identifier
-	Value Expression
+	Token   token.Token // token.LET
+	Name    *Identifier
+	Pattern Pattern // set instead of Name by `let [a, b] = ...`
+	Value   Expression
 }
 
 func (ls *LetStatement) statementNode() {}
 
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	if ls.Pattern != nil {
+		return ls.Pattern.End()
+	}
+	return ls.Name.End()
+}
 
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -78,11 +117,24 @@ func (ls *LetStatement) String() string {
 type Identifier struct {
 	Token token.Token // token.IDENT
 	Value string
+	Slot  *Slot // set by the resolver, nil means look Value up by name
+}
+
+// Slot locates the variable a resolved identifier refers to: the scope
+// Depth levels out from where it is used, at Index in that scope.
+// Until that variable is bound a use gets Outer, the variable of the
+// same name in a scope further out, like a lookup by name would
+type Slot struct {
+	Depth, Index int
+	Outer        *Slot
 }
 
 func (i *Identifier) expressionNode()      {}
+func (i *Identifier) patternNode()         {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 
 // implement Statement interface
 type ReturnStatement struct {
@@ -92,6 +144,13 @@ type ReturnStatement struct {
 
 func (r *ReturnStatement) statementNode()       {}
 func (r *ReturnStatement) TokenLiteral() string { return r.Token.Literal }
+func (r *ReturnStatement) Pos() token.Position  { return r.Token.Pos }
+func (r *ReturnStatement) End() token.Position {
+	if r.ReturnValue != nil {
+		return r.ReturnValue.End()
+	}
+	return r.Token.End
+}
 func (r *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -104,6 +163,106 @@ func (r *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement raises Value as an error, `throw expr;`
+type ThrowStatement struct {
+	Token token.Token // 'throw'
+	Value Expression
+}
+
+func (t *ThrowStatement) statementNode()       {}
+func (t *ThrowStatement) TokenLiteral() string { return t.Token.Literal }
+func (t *ThrowStatement) Pos() token.Position  { return t.Token.Pos }
+func (t *ThrowStatement) End() token.Position {
+	if t.Value != nil {
+		return t.Value.End()
+	}
+	return t.Token.End
+}
+func (t *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(t.TokenLiteral() + " ")
+	if t.Value != nil {
+		out.WriteString(t.Value.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// WhileStatement is `while (cond) { body }`
+type WhileStatement struct {
+	Token     token.Token // 'while'
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// ForStatement is `for (v in iterable) { body }` or `for (k, v in iterable)`.
+// With one name it takes the elements, or the keys of a hash; with two
+// Key takes the index or key and Value the element or value
+type ForStatement struct {
+	Token    token.Token // 'for'
+	Key      *Identifier // nil in the one name form
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String() + ", ")
+	}
+	out.WriteString(fs.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // 'break'
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+type ContinueStatement struct {
+	Token token.Token // 'continue'
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+
 // implement Statement interface
 // 表达式语句不是真正的语句 而是仅由表达式构成的语句
 // 可以包含在 ast.Program 的 Statements 切片中
@@ -114,6 +273,13 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -129,6 +295,31 @@ type IntegerLiteral struct {
 func (i *IntegerLiteral) expressionNode()      {}
 func (i *IntegerLiteral) TokenLiteral() string { return i.Token.Literal }
 func (i *IntegerLiteral) String() string       { return i.Token.Literal }
+func (i *IntegerLiteral) Pos() token.Position  { return i.Token.Pos }
+func (i *IntegerLiteral) End() token.Position  { return i.Token.End }
+
+// BigIntegerLiteral is an integer literal too large for int64
+type BigIntegerLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (b *BigIntegerLiteral) expressionNode()      {}
+func (b *BigIntegerLiteral) TokenLiteral() string { return b.Token.Literal }
+func (b *BigIntegerLiteral) String() string       { return b.Token.Literal }
+func (b *BigIntegerLiteral) Pos() token.Position  { return b.Token.Pos }
+func (b *BigIntegerLiteral) End() token.Position  { return b.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode()      {}
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+func (f *FloatLiteral) String() string       { return f.Token.Literal }
+func (f *FloatLiteral) Pos() token.Position  { return f.Token.Pos }
+func (f *FloatLiteral) End() token.Position  { return f.Token.End }
 
 // PREFIX EXPR: `!x`
 type PrefixExpression struct {
@@ -139,6 +330,13 @@ type PrefixExpression struct {
 
 func (p *PrefixExpression) expressionNode()      {}
 func (p *PrefixExpression) TokenLiteral() string { return p.Token.Literal }
+func (p *PrefixExpression) Pos() token.Position  { return p.Token.Pos }
+func (p *PrefixExpression) End() token.Position {
+	if p.Right != nil {
+		return p.Right.End()
+	}
+	return p.Token.End
+}
 func (p *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -159,6 +357,13 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -171,6 +376,28 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression is `x = v` or a compound `x += v`, Operator
+// holds the literal of the assignment token
+type AssignExpression struct {
+	Token    token.Token // '=', '+=', ...
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+func (ae *AssignExpression) String() string {
+	return ae.Target.String() + " " + ae.Operator + " " + ae.Value.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -179,6 +406,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type IfExpression struct {
 	Token       token.Token //  'if'
@@ -199,9 +428,46 @@ func (sl *StringLiteral) TokenLiteral() string {
 func (sl *StringLiteral) String() string {
 	return sl.Token.Literal
 }
+func (sl *StringLiteral) Pos() token.Position { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position { return sl.Token.End }
+
+// InterpolatedString is "text ${expr} text", Parts alternates between
+// *StringLiteral segments (possibly empty) and embedded expressions
+type InterpolatedString struct {
+	Token token.Token // STRING_HEAD
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) End() token.Position {
+	if n := len(is.Parts); n > 0 {
+		return is.Parts[n-1].End()
+	}
+	return is.Token.End
+}
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+	for i, p := range is.Parts {
+		if i%2 == 0 {
+			out.WriteString(p.String())
+		} else {
+			out.WriteString("${" + p.String() + "}")
+		}
+	}
+	return out.String()
+}
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -218,13 +484,58 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// TryExpression is `try { } catch (e) { } finally { }`,
+// at least one of Catch and Finally is set
+type TryExpression struct {
+	Token      token.Token // 'try'
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) End() token.Position {
+	if te.Finally != nil {
+		return te.Finally.End()
+	}
+	if te.Catch != nil {
+		return te.Catch.End()
+	}
+	return te.Block.End()
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch (")
+		out.WriteString(te.CatchParam.String())
+		out.WriteString(") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // '{'
 	Statements []Statement
+	RBrace     token.Token // '}'
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position  { return bs.RBrace.End }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -235,12 +546,16 @@ func (bs *BlockStatement) String() string {
 
 type FunctionLiteral struct {
 	Token      token.Token // let
-	Parameters []*Identifier
+	Parameters []Pattern   // may end in parameters with a default
+	Rest       *Identifier // `...rest`, collects extra arguments
 	Body       *BlockStatement
+	Name       string // binding name from `let name = fn...`, for stack traces
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position  { return fl.Body.End() }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -248,6 +563,9 @@ func (fl *FunctionLiteral) String() string {
 	for _, p := range fl.Parameters {
 		params = append(params, p.String())
 	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
+	}
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
@@ -257,14 +575,30 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// SpreadExpression is `...value` in a call or array literal, the
+// elements of value take its place
+type SpreadExpression struct {
+	Token token.Token // '...'
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) Pos() token.Position  { return se.Token.Pos }
+func (se *SpreadExpression) End() token.Position  { return se.Value.End() }
+func (se *SpreadExpression) String() string       { return "..." + se.Value.String() }
+
 type CallExpression struct {
-	Token     token.Token
+	Token     token.Token // '('
 	Function  Expression
 	Arguments []Expression
+	RParen    token.Token // ')'
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return ce.RParen.End }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -282,12 +616,15 @@ func (ce *CallExpression) String() string {
 }
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // '['
 	Elements []Expression
+	RBracket token.Token // ']'
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return al.RBracket.End }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	ele := []string{}
@@ -301,13 +638,16 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token // '['
-	Left  Expression
-	Index Expression
+	Token    token.Token // '['
+	Left     Expression
+	Index    Expression
+	RBracket token.Token // ']'
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return ie.RBracket.End }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -321,18 +661,21 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token // '{'
-	Pairs map[Expression]Expression
+	Token  token.Token // '{'
+	Pairs  map[Expression]Expression
+	RBrace token.Token // '}'
 }
 
 func (h *HashLiteral) expressionNode()      {}
 func (h *HashLiteral) TokenLiteral() string { return h.Token.Literal }
+func (h *HashLiteral) Pos() token.Position  { return h.Token.Pos }
+func (h *HashLiteral) End() token.Position  { return h.RBrace.End }
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for k, v := range h.Pairs {
-		pairs = append(pairs, k.String()+":"+v.String())
+	for _, k := range SortedKeys(h) {
+		pairs = append(pairs, k.String()+":"+h.Pairs[k].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -340,3 +683,149 @@ func (h *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// MatchExpression is `match (subject) { pattern => body, ... }`,
+// the first arm whose pattern matches and whose guard holds is taken
+type MatchExpression struct {
+	Token   token.Token // 'match'
+	Subject Expression
+	Arms    []*MatchArm
+	RBrace  token.Token // '}'
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) End() token.Position  { return me.RBrace.End }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+	out.WriteString("match (")
+	out.WriteString(me.Subject.String())
+	out.WriteString(") { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// MatchArm is `p1, p2 if guard => body`, any of Patterns may match
+type MatchArm struct {
+	Patterns []Pattern
+	Guard    Expression // nil without `if`
+	Body     *BlockStatement
+}
+
+func (ma *MatchArm) TokenLiteral() string { return ma.Patterns[0].TokenLiteral() }
+func (ma *MatchArm) Pos() token.Position  { return ma.Patterns[0].Pos() }
+func (ma *MatchArm) End() token.Position  { return ma.Body.End() }
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	patterns := []string{}
+	for _, p := range ma.Patterns {
+		patterns = append(patterns, p.String())
+	}
+	out.WriteString(strings.Join(patterns, ", "))
+	if ma.Guard != nil {
+		out.WriteString(" if " + ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// WildcardPattern is `_`, it matches anything and binds nothing
+type WildcardPattern struct {
+	Token token.Token
+}
+
+func (wp *WildcardPattern) patternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) Pos() token.Position  { return wp.Token.Pos }
+func (wp *WildcardPattern) End() token.Position  { return wp.Token.End }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// LiteralPattern matches values equal to a number, string or boolean
+// literal, Value may be a negated number
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) Pos() token.Position  { return lp.Value.Pos() }
+func (lp *LiteralPattern) End() token.Position  { return lp.Value.End() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
+// ArrayPattern matches an array element-wise, it needs the same
+// length unless it ends in `...rest`, which takes the remaining elements
+type ArrayPattern struct {
+	Token    token.Token // '['
+	Elements []Pattern
+	Rest     *Identifier // nil without ...rest
+	RBracket token.Token // ']'
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) End() token.Position  { return ap.RBracket.End }
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, e := range ap.Elements {
+		elements = append(elements, e.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// DefaultPattern is `target = default`, an array element or hash key
+// that is missing takes the value of Default instead
+type DefaultPattern struct {
+	Token   token.Token // '='
+	Target  Pattern
+	Default Expression
+}
+
+func (dp *DefaultPattern) patternNode()         {}
+func (dp *DefaultPattern) TokenLiteral() string { return dp.Token.Literal }
+func (dp *DefaultPattern) Pos() token.Position  { return dp.Target.Pos() }
+func (dp *DefaultPattern) End() token.Position  { return dp.Default.End() }
+func (dp *DefaultPattern) String() string {
+	return dp.Target.String() + " = " + dp.Default.String()
+}
+
+// HashPattern matches a hash holding every key of Pairs,
+// other keys of the hash are ignored
+type HashPattern struct {
+	Token  token.Token // '{'
+	Pairs  []*HashPatternPair
+	RBrace token.Token // '}'
+}
+
+// HashPatternPair is `"key": pattern`, a bare name is shorthand
+// for `"name": name` and Key is then a StringLiteral made from it
+type HashPatternPair struct {
+	Key   Expression // a literal
+	Value Pattern
+}
+
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position  { return hp.RBrace.End }
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for _, p := range hp.Pairs {
+		pairs = append(pairs, p.Key.String()+": "+p.Value.String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,387 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A Visitor's Visit is called by Walk for each node, a nil w stops the
+// walk below node, otherwise Walk visits the children of node with w
+// and then calls w.Visit(nil)
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree at node depth first, the children of a node
+// in the order they appear in the source. Nil children are skipped.
+// The patterns of a match arm are children of its MatchArm, the key and
+// value of a HashPatternPair are children of the HashPattern
+func Walk(node Node, v Visitor) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(n.Statements, v)
+	case *LetStatement:
+		if n.Pattern != nil {
+			Walk(n.Pattern, v)
+		} else if n.Name != nil {
+			Walk(n.Name, v)
+		}
+		walkExpression(n.Value, v)
+	case *ReturnStatement:
+		walkExpression(n.ReturnValue, v)
+	case *ThrowStatement:
+		walkExpression(n.Value, v)
+	case *WhileStatement:
+		walkExpression(n.Condition, v)
+		walkBlock(n.Body, v)
+	case *ForStatement:
+		walkIdent(n.Key, v)
+		walkIdent(n.Value, v)
+		walkExpression(n.Iterable, v)
+		walkBlock(n.Body, v)
+	case *ExpressionStatement:
+		walkExpression(n.Expression, v)
+	case *BlockStatement:
+		walkStatements(n.Statements, v)
+
+	case *InterpolatedString:
+		walkExpressions(n.Parts, v)
+	case *PrefixExpression:
+		walkExpression(n.Right, v)
+	case *InfixExpression:
+		walkExpression(n.Left, v)
+		walkExpression(n.Right, v)
+	case *AssignExpression:
+		walkExpression(n.Target, v)
+		walkExpression(n.Value, v)
+	case *IfExpression:
+		walkExpression(n.Condition, v)
+		walkBlock(n.Consequence, v)
+		walkBlock(n.Alternative, v)
+	case *TryExpression:
+		walkBlock(n.Block, v)
+		walkIdent(n.CatchParam, v)
+		walkBlock(n.Catch, v)
+		walkBlock(n.Finally, v)
+	case *FunctionLiteral:
+		walkPatterns(n.Parameters, v)
+		walkIdent(n.Rest, v)
+		walkBlock(n.Body, v)
+	case *SpreadExpression:
+		walkExpression(n.Value, v)
+	case *CallExpression:
+		walkExpression(n.Function, v)
+		walkExpressions(n.Arguments, v)
+	case *ArrayLiteral:
+		walkExpressions(n.Elements, v)
+	case *IndexExpression:
+		walkExpression(n.Left, v)
+		walkExpression(n.Index, v)
+	case *HashLiteral:
+		for _, k := range SortedKeys(n) {
+			walkExpression(k, v)
+			walkExpression(n.Pairs[k], v)
+		}
+	case *MatchExpression:
+		walkExpression(n.Subject, v)
+		for _, arm := range n.Arms {
+			if arm != nil {
+				Walk(arm, v)
+			}
+		}
+	case *MatchArm:
+		walkPatterns(n.Patterns, v)
+		walkExpression(n.Guard, v)
+		walkBlock(n.Body, v)
+
+	case *LiteralPattern:
+		walkExpression(n.Value, v)
+	case *ArrayPattern:
+		walkPatterns(n.Elements, v)
+		walkIdent(n.Rest, v)
+	case *DefaultPattern:
+		walkPattern(n.Target, v)
+		walkExpression(n.Default, v)
+	case *HashPattern:
+		for _, p := range n.Pairs {
+			walkExpression(p.Key, v)
+			walkPattern(p.Value, v)
+		}
+	}
+
+	v.Visit(nil)
+}
+
+// The helpers skip nil children, a nil *BlockStatement or *Identifier
+// would not compare equal to nil once it is a Node
+
+func walkStatements(list []Statement, v Visitor) {
+	for _, s := range list {
+		if s != nil {
+			Walk(s, v)
+		}
+	}
+}
+
+func walkExpressions(list []Expression, v Visitor) {
+	for _, e := range list {
+		walkExpression(e, v)
+	}
+}
+
+func walkPatterns(list []Pattern, v Visitor) {
+	for _, p := range list {
+		walkPattern(p, v)
+	}
+}
+
+func walkExpression(e Expression, v Visitor) {
+	if e != nil {
+		Walk(e, v)
+	}
+}
+
+func walkPattern(p Pattern, v Visitor) {
+	if p != nil {
+		Walk(p, v)
+	}
+}
+
+func walkBlock(b *BlockStatement, v Visitor) {
+	if b != nil {
+		Walk(b, v)
+	}
+}
+
+func walkIdent(i *Identifier, v Visitor) {
+	if i != nil {
+		Walk(i, v)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect walks the tree at node like Walk, calling f for each node
+// and with nil after its children. When f returns false the children
+// of the node are left out
+func Inspect(node Node, f func(Node) bool) {
+	Walk(node, inspector(f))
+}
+
+// SortedKeys gives the keys of a hash literal in source order
+func SortedKeys(h *HashLiteral) []Expression {
+	keys := make([]Expression, 0, len(h.Pairs))
+	for k := range h.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Pos().Offset < keys[j].Pos().Offset
+	})
+	return keys
+}
+
+// ModifierFunc gives the node to put in the place of node
+type ModifierFunc func(node Node) Node
+
+// Modify changes the tree at node in place, bottom up: the children of
+// a node are modified first, in source order, and set back into its
+// fields, then modifier gives what replaces the node itself. Modify
+// panics if that is of the wrong kind for its place, like a statement
+// where an expression goes
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = modifyStatements(n.Statements, modifier, "Program.Statements")
+	case *LetStatement:
+		if n.Pattern != nil {
+			n.Pattern = modifyPattern(n.Pattern, modifier, "LetStatement.Pattern")
+		} else if n.Name != nil {
+			n.Name = modifyIdent(n.Name, modifier, "LetStatement.Name")
+		}
+		n.Value = modifyExpression(n.Value, modifier, "LetStatement.Value")
+	case *ReturnStatement:
+		n.ReturnValue = modifyExpression(n.ReturnValue, modifier, "ReturnStatement.ReturnValue")
+	case *ThrowStatement:
+		n.Value = modifyExpression(n.Value, modifier, "ThrowStatement.Value")
+	case *WhileStatement:
+		n.Condition = modifyExpression(n.Condition, modifier, "WhileStatement.Condition")
+		n.Body = modifyBlock(n.Body, modifier, "WhileStatement.Body")
+	case *ForStatement:
+		n.Key = modifyIdent(n.Key, modifier, "ForStatement.Key")
+		n.Value = modifyIdent(n.Value, modifier, "ForStatement.Value")
+		n.Iterable = modifyExpression(n.Iterable, modifier, "ForStatement.Iterable")
+		n.Body = modifyBlock(n.Body, modifier, "ForStatement.Body")
+	case *ExpressionStatement:
+		n.Expression = modifyExpression(n.Expression, modifier, "ExpressionStatement.Expression")
+	case *BlockStatement:
+		n.Statements = modifyStatements(n.Statements, modifier, "BlockStatement.Statements")
+
+	case *InterpolatedString:
+		n.Parts = modifyExpressions(n.Parts, modifier, "InterpolatedString.Parts")
+	case *PrefixExpression:
+		n.Right = modifyExpression(n.Right, modifier, "PrefixExpression.Right")
+	case *InfixExpression:
+		n.Left = modifyExpression(n.Left, modifier, "InfixExpression.Left")
+		n.Right = modifyExpression(n.Right, modifier, "InfixExpression.Right")
+	case *AssignExpression:
+		n.Target = modifyExpression(n.Target, modifier, "AssignExpression.Target")
+		n.Value = modifyExpression(n.Value, modifier, "AssignExpression.Value")
+	case *IfExpression:
+		n.Condition = modifyExpression(n.Condition, modifier, "IfExpression.Condition")
+		n.Consequence = modifyBlock(n.Consequence, modifier, "IfExpression.Consequence")
+		n.Alternative = modifyBlock(n.Alternative, modifier, "IfExpression.Alternative")
+	case *TryExpression:
+		n.Block = modifyBlock(n.Block, modifier, "TryExpression.Block")
+		n.CatchParam = modifyIdent(n.CatchParam, modifier, "TryExpression.CatchParam")
+		n.Catch = modifyBlock(n.Catch, modifier, "TryExpression.Catch")
+		n.Finally = modifyBlock(n.Finally, modifier, "TryExpression.Finally")
+	case *FunctionLiteral:
+		n.Parameters = modifyPatterns(n.Parameters, modifier, "FunctionLiteral.Parameters")
+		n.Rest = modifyIdent(n.Rest, modifier, "FunctionLiteral.Rest")
+		n.Body = modifyBlock(n.Body, modifier, "FunctionLiteral.Body")
+	case *SpreadExpression:
+		n.Value = modifyExpression(n.Value, modifier, "SpreadExpression.Value")
+	case *CallExpression:
+		n.Function = modifyExpression(n.Function, modifier, "CallExpression.Function")
+		n.Arguments = modifyExpressions(n.Arguments, modifier, "CallExpression.Arguments")
+	case *ArrayLiteral:
+		n.Elements = modifyExpressions(n.Elements, modifier, "ArrayLiteral.Elements")
+	case *IndexExpression:
+		n.Left = modifyExpression(n.Left, modifier, "IndexExpression.Left")
+		n.Index = modifyExpression(n.Index, modifier, "IndexExpression.Index")
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		for _, k := range SortedKeys(n) {
+			v := n.Pairs[k]
+			pairs[modifyExpression(k, modifier, "HashLiteral.Pairs")] =
+				modifyExpression(v, modifier, "HashLiteral.Pairs")
+		}
+		n.Pairs = pairs
+	case *MatchExpression:
+		n.Subject = modifyExpression(n.Subject, modifier, "MatchExpression.Subject")
+		for i, arm := range n.Arms {
+			if arm != nil {
+				m := Modify(arm, modifier)
+				var ok bool
+				if n.Arms[i], ok = m.(*MatchArm); !ok && m != nil {
+					wrongKind(m, "MatchExpression.Arms", "*MatchArm")
+				}
+			}
+		}
+	case *MatchArm:
+		n.Patterns = modifyPatterns(n.Patterns, modifier, "MatchArm.Patterns")
+		n.Guard = modifyExpression(n.Guard, modifier, "MatchArm.Guard")
+		n.Body = modifyBlock(n.Body, modifier, "MatchArm.Body")
+
+	case *LiteralPattern:
+		n.Value = modifyExpression(n.Value, modifier, "LiteralPattern.Value")
+	case *ArrayPattern:
+		n.Elements = modifyPatterns(n.Elements, modifier, "ArrayPattern.Elements")
+		n.Rest = modifyIdent(n.Rest, modifier, "ArrayPattern.Rest")
+	case *DefaultPattern:
+		n.Target = modifyPattern(n.Target, modifier, "DefaultPattern.Target")
+		n.Default = modifyExpression(n.Default, modifier, "DefaultPattern.Default")
+	case *HashPattern:
+		for _, p := range n.Pairs {
+			p.Key = modifyExpression(p.Key, modifier, "HashPatternPair.Key")
+			p.Value = modifyPattern(p.Value, modifier, "HashPatternPair.Value")
+		}
+	}
+
+	return modifier(node)
+}
+
+// The helpers put back what Modify gives for a child, field names the
+// place of the child for the panic on a replacement of the wrong kind.
+// A nil replacement leaves nil
+
+func modifyStatements(list []Statement, modifier ModifierFunc, field string) []Statement {
+	for i, s := range list {
+		if s == nil {
+			continue
+		}
+		m := Modify(s, modifier)
+		var ok bool
+		if list[i], ok = m.(Statement); !ok && m != nil {
+			wrongKind(m, field, "Statement")
+		}
+	}
+	return list
+}
+
+func modifyExpressions(list []Expression, modifier ModifierFunc, field string) []Expression {
+	for i, e := range list {
+		list[i] = modifyExpression(e, modifier, field)
+	}
+	return list
+}
+
+func modifyPatterns(list []Pattern, modifier ModifierFunc, field string) []Pattern {
+	for i, p := range list {
+		list[i] = modifyPattern(p, modifier, field)
+	}
+	return list
+}
+
+func modifyExpression(e Expression, modifier ModifierFunc, field string) Expression {
+	if e == nil {
+		return nil
+	}
+	m := Modify(e, modifier)
+	e, ok := m.(Expression)
+	if !ok && m != nil {
+		wrongKind(m, field, "Expression")
+	}
+	return e
+}
+
+func modifyPattern(p Pattern, modifier ModifierFunc, field string) Pattern {
+	if p == nil {
+		return nil
+	}
+	m := Modify(p, modifier)
+	p, ok := m.(Pattern)
+	if !ok && m != nil {
+		wrongKind(m, field, "Pattern")
+	}
+	return p
+}
+
+func modifyBlock(b *BlockStatement, modifier ModifierFunc, field string) *BlockStatement {
+	if b == nil {
+		return nil
+	}
+	m := Modify(b, modifier)
+	b, ok := m.(*BlockStatement)
+	if !ok && m != nil {
+		wrongKind(m, field, "*BlockStatement")
+	}
+	return b
+}
+
+func modifyIdent(i *Identifier, modifier ModifierFunc, field string) *Identifier {
+	if i == nil {
+		return nil
+	}
+	m := Modify(i, modifier)
+	i, ok := m.(*Identifier)
+	if !ok && m != nil {
+		wrongKind(m, field, "*Identifier")
+	}
+	return i
+}
+
+func wrongKind(n Node, field, want string) {
+	panic(fmt.Sprintf("ast.Modify: cannot put %T in %s, it takes a %s", n, field, want))
+}
@@ -0,0 +1,274 @@
+package ast_test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/parser"
+)
+
+// everyNode has a node of every type
+const everyNode = `let x = 1;
+let [a, [b, _], ...r] = [2, [3, 4], 5];
+let {"k": k = 1.5, v} = {"k": 6, "v": 99999999999999999999};
+let f = fn(p, q = true, ...rest) { return p; };
+while (x < 2) { x += 1; break; }
+for (i, e in [a]) { continue; }
+if (!x) { throw "e"; } else { f(...r) }
+try { x[0] } catch (err) { "s ${err} t" } finally { x }
+match (x) { 1, -2 => x, [h] if h => h, _ => 0 }`
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parse errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func kind(n ast.Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+}
+
+// describe gives the kind of n, with the name or value of a leaf
+func describe(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Identifier:
+		return "Identifier " + n.Value
+	case *ast.IntegerLiteral, *ast.BigIntegerLiteral, *ast.FloatLiteral,
+		*ast.Boolean, *ast.StringLiteral:
+		return kind(n) + " " + n.String()
+	}
+	return kind(n)
+}
+
+func TestInspectOrder(t *testing.T) {
+	var got []string
+	ast.Inspect(parse(t, everyNode), func(n ast.Node) bool {
+		if n != nil {
+			got = append(got, describe(n))
+		}
+		return true
+	})
+
+	expected := []string{
+		"Program",
+		"LetStatement", "Identifier x", "IntegerLiteral 1",
+		"LetStatement",
+		"ArrayPattern", "Identifier a", "ArrayPattern", "Identifier b", "WildcardPattern",
+		"Identifier r",
+		"ArrayLiteral", "IntegerLiteral 2", "ArrayLiteral", "IntegerLiteral 3",
+		"IntegerLiteral 4", "IntegerLiteral 5",
+		"LetStatement",
+		"HashPattern", "StringLiteral k", "DefaultPattern", "Identifier k", "FloatLiteral 1.5",
+		"StringLiteral v", "Identifier v",
+		"HashLiteral", "StringLiteral k", "IntegerLiteral 6",
+		"StringLiteral v", "BigIntegerLiteral 99999999999999999999",
+		"LetStatement", "Identifier f",
+		"FunctionLiteral", "Identifier p", "DefaultPattern", "Identifier q", "Boolean true",
+		"Identifier rest",
+		"BlockStatement", "ReturnStatement", "Identifier p",
+		"WhileStatement", "InfixExpression", "Identifier x", "IntegerLiteral 2",
+		"BlockStatement", "ExpressionStatement", "AssignExpression", "Identifier x",
+		"IntegerLiteral 1", "BreakStatement",
+		"ForStatement", "Identifier i", "Identifier e", "ArrayLiteral", "Identifier a",
+		"BlockStatement", "ContinueStatement",
+		"ExpressionStatement", "IfExpression", "PrefixExpression", "Identifier x",
+		"BlockStatement", "ThrowStatement", "StringLiteral e",
+		"BlockStatement", "ExpressionStatement", "CallExpression", "Identifier f",
+		"SpreadExpression", "Identifier r",
+		"ExpressionStatement", "TryExpression",
+		"BlockStatement", "ExpressionStatement", "IndexExpression", "Identifier x", "IntegerLiteral 0",
+		"Identifier err",
+		"BlockStatement", "ExpressionStatement", "InterpolatedString", "StringLiteral s ",
+		"Identifier err", "StringLiteral  t",
+		"BlockStatement", "ExpressionStatement", "Identifier x",
+		"ExpressionStatement", "MatchExpression", "Identifier x",
+		"MatchArm", "LiteralPattern", "IntegerLiteral 1",
+		"LiteralPattern", "PrefixExpression", "IntegerLiteral 2",
+		"BlockStatement", "ExpressionStatement", "Identifier x",
+		"MatchArm", "ArrayPattern", "Identifier h", "Identifier h",
+		"BlockStatement", "ExpressionStatement", "Identifier h",
+		"MatchArm", "WildcardPattern", "BlockStatement", "ExpressionStatement", "IntegerLiteral 0",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong order.\nexpected=\n%s\ngot=\n%s",
+			strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestWalkEveryNodeType(t *testing.T) {
+	types := []string{
+		"Program", "LetStatement", "ReturnStatement", "ThrowStatement",
+		"WhileStatement", "ForStatement", "BreakStatement", "ContinueStatement",
+		"ExpressionStatement", "BlockStatement",
+		"Identifier", "IntegerLiteral", "BigIntegerLiteral", "FloatLiteral",
+		"StringLiteral", "Boolean", "InterpolatedString", "PrefixExpression",
+		"InfixExpression", "AssignExpression", "IfExpression", "TryExpression",
+		"FunctionLiteral", "SpreadExpression", "CallExpression", "ArrayLiteral",
+		"IndexExpression", "HashLiteral", "MatchExpression", "MatchArm",
+		"WildcardPattern", "LiteralPattern", "ArrayPattern", "DefaultPattern",
+		"HashPattern",
+	}
+
+	seen := map[string]bool{}
+	ast.Inspect(parse(t, everyNode), func(n ast.Node) bool {
+		if n != nil {
+			seen[kind(n)] = true
+		}
+		return true
+	})
+	for _, typ := range types {
+		if !seen[typ] {
+			t.Errorf("no %s visited", typ)
+		}
+		delete(seen, typ)
+	}
+	for typ := range seen {
+		t.Errorf("%s visited, but not in the list of node types", typ)
+	}
+}
+
+// depthVisitor records the depth of each node, counting the
+// Visit(nil) that closes every node it does not prune
+type depthVisitor struct {
+	depth int
+	lines *[]string
+	skip  string // kind of node whose children are left out
+}
+
+func (v *depthVisitor) Visit(n ast.Node) ast.Visitor {
+	if n == nil {
+		*v.lines = append(*v.lines, strings.Repeat(" ", v.depth-1)+"end")
+		return nil
+	}
+	*v.lines = append(*v.lines, strings.Repeat(" ", v.depth)+describe(n))
+	if kind(n) == v.skip {
+		return nil
+	}
+	return &depthVisitor{depth: v.depth + 1, lines: v.lines, skip: v.skip}
+}
+
+func TestWalk(t *testing.T) {
+	var lines []string
+	ast.Walk(parse(t, `let f = fn(a) { a }; f(-1)`),
+		&depthVisitor{lines: &lines, skip: "FunctionLiteral"})
+
+	expected := `Program
+ LetStatement
+  Identifier f
+  end
+  FunctionLiteral
+ end
+ ExpressionStatement
+  CallExpression
+   Identifier f
+   end
+   PrefixExpression
+    IntegerLiteral 1
+    end
+   end
+  end
+ end
+end`
+	if got := strings.Join(lines, "\n"); got != expected {
+		t.Errorf("wrong walk.\nexpected=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+var renamed = regexp.MustCompile(`\b[1a]\b`)
+
+func TestModify(t *testing.T) {
+	// one becomes two and a becomes b wherever they are
+	modifier := func(n ast.Node) ast.Node {
+		switch n := n.(type) {
+		case *ast.IntegerLiteral:
+			if n.Value == 1 {
+				n.Value, n.Token.Literal = 2, "2"
+			}
+		case *ast.Identifier:
+			if n.Value == "a" {
+				n.Value, n.Token.Literal = "b", "b"
+			}
+		}
+		return n
+	}
+
+	tests := []string{
+		`1`,
+		`a`,
+		`let a = 1;`,
+		`let [a, [_, a = 1], ...a] = 1;`,
+		`let {"k": a, "j": a = 1} = 1;`,
+		`return 1;`,
+		`throw 1;`,
+		`while (1) { 1; break; }`,
+		`for (a, a in 1) { a; continue; }`,
+		`-1`,
+		`1 + 1`,
+		`a = 1`,
+		`a[1] += 1`,
+		`if (1) { 1 } else { 1 }`,
+		`try { 1 } catch (a) { a } finally { 1 }`,
+		`fn(a, a = 1, ...a) { 1 }`,
+		`a(1, ...a)`,
+		`[1, a]`,
+		`{1: 1, "s": a}`,
+		`"${1} and ${a}"`,
+		`match (1) { 1, -1 => 1, [a] if a => a, {"k": a} => 1 }`,
+	}
+
+	for _, input := range tests {
+		modified := ast.Modify(parse(t, input), modifier)
+		expected := parse(t, renamed.ReplaceAllStringFunc(input, func(s string) string {
+			return map[string]string{"1": "2", "a": "b"}[s]
+		}))
+		if modified.String() != expected.String() {
+			t.Errorf("%q: expected %s, got %s", input, expected, modified)
+		}
+	}
+}
+
+func TestModifyReplaces(t *testing.T) {
+	// fold x + 0 into x, bottom up so nested ones fold as well
+	program := parse(t, `let y = fn(x) { [x + 0 + 0, {x + 0: x}] };`)
+	modified := ast.Modify(program, func(n ast.Node) ast.Node {
+		if in, ok := n.(*ast.InfixExpression); ok && in.Operator == "+" {
+			if lit, ok := in.Right.(*ast.IntegerLiteral); ok && lit.Value == 0 {
+				return in.Left
+			}
+		}
+		return n
+	})
+
+	if got := modified.String(); got != "let y = fn(x) [x, {x:x}];" {
+		t.Errorf("wrong program, got %q", got)
+	}
+
+	// the tree itself is what changed
+	if program.String() != modified.String() {
+		t.Errorf("program not modified in place, got %q", program.String())
+	}
+}
+
+func TestModifyWrongKind(t *testing.T) {
+	defer func() {
+		expected := "ast.Modify: cannot put *ast.BreakStatement in LetStatement.Value, it takes a Expression"
+		if r := recover(); r != expected {
+			t.Errorf("expected panic %q, got %v", expected, r)
+		}
+	}()
+
+	ast.Modify(parse(t, `let y = 1;`), func(n ast.Node) ast.Node {
+		if _, ok := n.(*ast.IntegerLiteral); ok {
+			return &ast.BreakStatement{}
+		}
+		return n
+	})
+}
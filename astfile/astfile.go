@@ -0,0 +1,128 @@
+// Package astfile stores a parsed program in a compact binary form, so
+// a script runs without being parsed again, and loads it back.
+//
+// A file is laid out as
+//
+//	magic     "\x89MKY"
+//	version   uint16, big endian
+//	strings   count, then the length and bytes of each
+//	constants count, then a kind byte and the value of each literal
+//	positions count, then offset, line and column of each
+//	filename  string index, shared by all valid positions
+//	nodes     length, then the program in preorder
+//	checksum  uint32, CRC-32 (IEEE) of all the bytes before it
+//
+// Counts, lengths, indexes and integers are varints. A node is its tag
+// followed by its tokens and fields; a token is the string indexes of
+// its type and literal and the position indexes of its start and end.
+// Comments of tokens are not kept.
+package astfile
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	Magic   = "\x89MKY"
+	Version = 1
+)
+
+// FormatError is a file that is not a valid precompiled program,
+// Offset is where in the file the problem was found
+type FormatError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("astfile: offset %d: %s", e.Offset, e.Msg)
+}
+
+// IsPrecompiled reports whether data starts like a precompiled program
+func IsPrecompiled(data []byte) bool {
+	return len(data) >= len(Magic) && string(data[:len(Magic)]) == Magic
+}
+
+type constKind byte
+
+const (
+	constInt constKind = iota + 1
+	constBigInt
+	constFloat
+	constString
+	constBool
+)
+
+var constKindNames = map[constKind]string{
+	constInt:    "INT",
+	constBigInt: "BIGINT",
+	constFloat:  "FLOAT",
+	constString: "STRING",
+	constBool:   "BOOL",
+}
+
+// constant is the value of a literal, the field for kind is set
+type constant struct {
+	kind constKind
+	i    int64
+	big  *big.Int
+	f    float64
+	s    string
+	b    bool
+}
+
+func (c constant) String() string {
+	switch c.kind {
+	case constInt:
+		return fmt.Sprint(c.i)
+	case constBigInt:
+		return c.big.String()
+	case constFloat:
+		return fmt.Sprint(c.f)
+	case constString:
+		return fmt.Sprintf("%q", c.s)
+	case constBool:
+		return fmt.Sprint(c.b)
+	}
+	return "?"
+}
+
+// tags of the nodes, 0 stands for a nil node
+const (
+	tagNil byte = iota
+	tagLet
+	tagReturn
+	tagThrow
+	tagWhile
+	tagFor
+	tagBreak
+	tagContinue
+	tagExpressionStatement
+	tagBlock
+	tagIdentifier
+	tagInteger
+	tagBigInteger
+	tagFloat
+	tagString
+	tagBoolean
+	tagInterpolated
+	tagPrefix
+	tagInfix
+	tagAssign
+	tagIf
+	tagTry
+	tagFunction
+	tagSpread
+	tagCall
+	tagArray
+	tagIndex
+	tagHash
+	tagMatch
+	tagWildcard
+	tagLiteralPattern
+	tagArrayPattern
+	tagDefaultPattern
+	tagHashPattern
+	numTags
+)
@@ -0,0 +1,232 @@
+package astfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/parser"
+)
+
+// every kind of node but hash literals, whose pairs are keyed by
+// pointers and so never deeply equal
+const everyNode = `let big = 99999999999999999999;
+const ratio = 0.75;
+let [first, [second = 2], ...others] = [1, [], 3, 4];
+let {name, "age": years = 40, 1: _} = match (first) { 1 => name("x"), _ => 0 };
+let greet = fn(who, {title = "dr"}, ...rest) {
+  if (!rest) { return "${title} ${who}!"; } else if (true) { throw who } else { false }
+};
+let total = 0;
+while (total < 10) { total += 1; if (total == 5) { break } else { continue } }
+for (i, x in range(3)) { total = total + i * x }
+for (x in [1, ...[2]]) { total -= x }
+let safe = try { others[0] } catch (e) { e["message"] } finally { -1 };
+match ([first, ratio]) { [1, -2], [_, r] if r > 0.5 => r, [a] => a, _ => null }
+greet(big, 2 ** 3 % 5);
+return total;
+`
+
+func TestRoundTrip(t *testing.T) {
+	program := parse(t, everyNode)
+	data, err := Encode(program)
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+
+	loaded, err := Load(data)
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if !reflect.DeepEqual(program, loaded) {
+		t.Errorf("loaded program differs.\nwant=%s\ngot=%s", program, loaded)
+	}
+}
+
+func TestHashLiteral(t *testing.T) {
+	input := `let h = {"b": 2, "a": 1 + 1, 3: [true]}; [h["a"], h[3][0], h["b"]]`
+	data, err := Encode(parse(t, input))
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+
+	again, _ := Encode(parse(t, input))
+	if !bytes.Equal(data, again) {
+		t.Errorf("a program encoded to different bytes")
+	}
+
+	loaded, err := Load(data)
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	result := evaluator.Eval(loaded, object.NewEnvirnment())
+	if result.Inspect() != "[2, true, 2]" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+// errors are reported at the position of the source they were parsed from
+func TestLoadedPositions(t *testing.T) {
+	input := "let f = fn(x) { x / 0 };\nf(1);"
+	data, err := Encode(parse(t, input))
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	loaded, err := Load(data)
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+
+	want := evaluator.Eval(parse(t, input), object.NewEnvirnment()).(*object.Error)
+	got, ok := evaluator.Eval(loaded, object.NewEnvirnment()).(*object.Error)
+	if !ok {
+		t.Fatalf("no error from the loaded program")
+	}
+	if got.Traceback() != want.Traceback() {
+		t.Errorf("wrong traceback. expected=\n%s\ngot=\n%s", want.Traceback(), got.Traceback())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	valid, err := Encode(parse(t, "1;"))
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	// the tag of the integer, before its token, its constant and the checksum
+	intTag := len(valid) - 4 - 1 - 4 - 1
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let x = 1;"), "astfile: offset 0: not a precompiled program"},
+		{[]byte(Magic + "\x00"), "astfile: offset 5: truncated header"},
+		{edit(valid, 5, 2), "astfile: offset 4: unsupported version 2, want 1"},
+		{append(append([]byte{}, valid...), 0), "checksum mismatch, the file is corrupt"},
+		{valid[:len(valid)-1], "checksum mismatch, the file is corrupt"},
+		{edit(valid, len(valid)-5, 0x55), "checksum mismatch, the file is corrupt"},
+		{resum(edit(valid, intTag, 200)), "unknown node tag 200"},
+		{resum(edit(valid, intTag, tagBreak)), "break outside of a loop"},
+		{resum(edit(valid, intTag+1, 99)), "string index 99 out of range"},
+		{resum(edit(valid, len(valid)-5, 7)), "constant index 7 out of range (1 entries)"},
+		{resum(edit(valid, intTag, tagFloat)), "constant of kind INT, want FLOAT"},
+		{resum(edit(valid, intTag, tagBreak)[:intTag+5]), "runs past the end of data"},
+		{encode(t, &ast.WhileStatement{}), "missing expression"},
+		{encode(t, &ast.ContinueStatement{}), "continue outside of a loop"},
+		{encode(t, &ast.WhileStatement{Condition: &ast.Boolean{}, Body: &ast.BlockStatement{
+			Statements: []ast.Statement{&ast.ExpressionStatement{Expression: &ast.FunctionLiteral{
+				Body: &ast.BlockStatement{Statements: []ast.Statement{&ast.BreakStatement{}}}}}}}}),
+			"break outside of a loop"},
+		{encode(t, &ast.LetStatement{Value: &ast.Boolean{}}), "let needs either a name or a pattern"},
+		{encode(t, &ast.ExpressionStatement{Expression: &ast.TryExpression{Block: &ast.BlockStatement{}}}),
+			"try without catch or finally"},
+		{encode(t, &ast.ExpressionStatement{Expression: &ast.InterpolatedString{
+			Parts: []ast.Expression{&ast.Identifier{}}}}), "expected a string segment, got Identifier"},
+	}
+
+	for i, tt := range tests {
+		program, err := Load(tt.data)
+		if err == nil {
+			t.Errorf("tests[%d]: no error, got=%s", i, program)
+			continue
+		}
+		if _, ok := err.(*FormatError); !ok {
+			t.Errorf("tests[%d]: error is not *FormatError. got=%T", i, err)
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("tests[%d]: wrong error. expected=%q, got=%q", i, tt.expected, err)
+		}
+	}
+}
+
+// Load never panics, whichever byte is broken
+func TestLoadEveryByte(t *testing.T) {
+	valid, err := Encode(parse(t, everyNode))
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	for i := range valid[:len(valid)-4] {
+		for _, b := range []byte{0, 1, 0x7f, 0xff} {
+			Load(resum(edit(valid, i, b)))
+		}
+		Load(resum(valid[:i+4]))
+	}
+}
+
+func TestDump(t *testing.T) {
+	data, err := Encode(parse(t, `let x = 5;`))
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+
+	expected := `precompiled program, version 1, 81 bytes, from g.mk
+strings (7):
+     0  "LET"
+     1  "let"
+     2  "IDENT"
+     3  "x"
+     4  "INT"
+     5  "5"
+     6  "g.mk"
+constants (1):
+     0  INT    5
+positions: 6
+nodes (19 bytes at 0058):
+0059  1:1      LetStatement let
+0064  1:5        Identifier x
+0071  1:9        IntegerLiteral 5
+`
+	var out bytes.Buffer
+	if err := Dump(&out, data); err != nil {
+		t.Fatalf("Dump: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong dump. expected=\n%s\ngot=\n%s", expected, out.String())
+	}
+
+	if err := Dump(&out, data[:20]); err == nil {
+		t.Errorf("no error dumping a truncated file")
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.NewFile("g.mk", input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+func encode(t *testing.T, s ast.Statement) []byte {
+	t.Helper()
+	data, err := Encode(&ast.Program{Statements: []ast.Statement{s}})
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	return data
+}
+
+func edit(data []byte, at int, b byte) []byte {
+	data = append([]byte{}, data...)
+	data[at] = b
+	return data
+}
+
+// resum gives data a valid checksum again
+func resum(data []byte) []byte {
+	data = append([]byte{}, data...)
+	if len(data) < 4 {
+		return data
+	}
+	binary.BigEndian.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(data[:len(data)-4]))
+	return data
+}
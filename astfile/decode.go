@@ -0,0 +1,582 @@
+package astfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/token"
+)
+
+type decoder struct {
+	data []byte
+	off  int
+	end  int // end of what may be read
+
+	version   int
+	strings   []string
+	consts    []constant
+	positions []token.Position
+	filename  string
+
+	nodesAt int     // offset of the node section
+	entries []entry // the nodes in preorder when dumping
+	depth   int
+
+	// loops counts the loop bodies around the current node, a function
+	// literal starts again from zero as it does for the parser
+	loops int
+}
+
+// entry is a node met by the decoder, Node is
+// an ast.Node or an *ast.MatchArm
+type entry struct {
+	offset, depth int
+	node          ast.Node
+}
+
+// Load decodes a precompiled program. Every part of the file is
+// checked, a corrupt or truncated file gives a *FormatError
+func Load(data []byte) (*ast.Program, error) {
+	d := &decoder{data: data}
+	return d.decode()
+}
+
+func (d *decoder) decode() (program *ast.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ferr, ok := r.(*FormatError)
+			if !ok {
+				panic(r)
+			}
+			program, err = nil, ferr
+		}
+	}()
+
+	d.header()
+	d.pools()
+	return d.program(), nil
+}
+
+func (d *decoder) fail(offset int, format string, a ...interface{}) {
+	panic(&FormatError{Offset: offset, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (d *decoder) header() {
+	if !IsPrecompiled(d.data) {
+		d.fail(0, "not a precompiled program")
+	}
+	if len(d.data) < len(Magic)+2+4 {
+		d.fail(len(d.data), "truncated header")
+	}
+	d.off = len(Magic)
+	d.version = int(binary.BigEndian.Uint16(d.data[d.off:]))
+	if d.version != Version {
+		d.fail(d.off, "unsupported version %d, want %d", d.version, Version)
+	}
+	d.off += 2
+
+	d.end = len(d.data) - 4
+	sum := binary.BigEndian.Uint32(d.data[d.end:])
+	if crc32.ChecksumIEEE(d.data[:d.end]) != sum {
+		d.fail(d.end, "checksum mismatch, the file is corrupt")
+	}
+}
+
+func (d *decoder) pools() {
+	n := d.count()
+	d.strings = make([]string, n)
+	for i := range d.strings {
+		l := d.count()
+		d.strings[i] = string(d.data[d.off : d.off+l])
+		d.off += l
+	}
+
+	n = d.count()
+	d.consts = make([]constant, n)
+	for i := range d.consts {
+		d.consts[i] = d.constant()
+	}
+
+	n = d.count()
+	d.positions = make([]token.Position, n)
+	for i := range d.positions {
+		d.positions[i] = token.Position{Offset: d.int(), Line: d.int(), Column: d.int()}
+	}
+
+	d.filename = d.str()
+	for i := range d.positions {
+		if d.positions[i].IsValid() {
+			d.positions[i].Filename = d.filename
+		}
+	}
+
+	l := d.count()
+	if d.off+l != d.end {
+		d.fail(d.off, "node section of %d bytes, the file has %d", l, d.end-d.off)
+	}
+	d.nodesAt = d.off
+}
+
+func (d *decoder) constant() constant {
+	at := d.off
+	c := constant{kind: constKind(d.byte())}
+	switch c.kind {
+	case constInt:
+		v, n := binary.Varint(d.data[d.off:d.end])
+		if n <= 0 {
+			d.fail(d.off, "malformed integer")
+		}
+		d.off += n
+		c.i = v
+	case constBigInt:
+		sign := int(d.byte()) - 1
+		l := d.count()
+		c.big = new(big.Int).SetBytes(d.data[d.off : d.off+l])
+		d.off += l
+		if sign < -1 || sign > 1 || c.big.Sign() != sign*sign {
+			d.fail(at, "malformed big integer")
+		}
+		if sign < 0 {
+			c.big.Neg(c.big)
+		}
+	case constFloat:
+		if d.end-d.off < 8 {
+			d.fail(d.off, "truncated float")
+		}
+		c.f = math.Float64frombits(binary.BigEndian.Uint64(d.data[d.off:]))
+		d.off += 8
+	case constString:
+		c.s = d.str()
+	case constBool:
+		switch d.byte() {
+		case 0:
+		case 1:
+			c.b = true
+		default:
+			d.fail(d.off-1, "malformed boolean")
+		}
+	default:
+		d.fail(at, "unknown constant kind %d", c.kind)
+	}
+	return c
+}
+
+func (d *decoder) byte() byte {
+	if d.off >= d.end {
+		d.fail(d.off, "unexpected end of data")
+	}
+	d.off++
+	return d.data[d.off-1]
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data[d.off:d.end])
+	if n <= 0 {
+		d.fail(d.off, "malformed varint")
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) int() int {
+	at := d.off
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail(at, "value %d out of range", v)
+	}
+	return int(v)
+}
+
+// count reads a length or number of items, each taking at least
+// a byte, so it can be no more than the bytes left
+func (d *decoder) count() int {
+	at := d.off
+	v := d.uvarint()
+	if v > uint64(d.end-d.off) {
+		d.fail(at, "count %d runs past the end of data", v)
+	}
+	return int(v)
+}
+
+func (d *decoder) index(n int, what string) int {
+	at := d.off
+	v := d.uvarint()
+	if v >= uint64(n) {
+		d.fail(at, "%s index %d out of range (%d entries)", what, v, n)
+	}
+	return int(v)
+}
+
+func (d *decoder) str() string { return d.strings[d.index(len(d.strings), "string")] }
+
+func (d *decoder) pos() token.Position {
+	return d.positions[d.index(len(d.positions), "position")]
+}
+
+func (d *decoder) token() token.Token {
+	return token.Token{
+		Type:    token.TokenType(d.str()),
+		Literal: d.str(),
+		Pos:     d.pos(),
+		End:     d.pos(),
+	}
+}
+
+func (d *decoder) constOf(kind constKind) constant {
+	at := d.off
+	c := d.consts[d.index(len(d.consts), "constant")]
+	if c.kind != kind {
+		d.fail(at, "constant of kind %s, want %s", constKindNames[c.kind], constKindNames[kind])
+	}
+	return c
+}
+
+func (d *decoder) program() *ast.Program {
+	n := d.count()
+	program := &ast.Program{Statements: make([]ast.Statement, n)}
+	for i := range program.Statements {
+		program.Statements[i] = d.statement(true)
+	}
+	if d.off != d.end {
+		d.fail(d.off, "%d bytes left after the program", d.end-d.off)
+	}
+	return program
+}
+
+func (d *decoder) statement(required bool) ast.Statement {
+	at := d.off
+	n := d.node()
+	if n == nil {
+		d.missing(at, required, "statement")
+		return nil
+	}
+	s, ok := n.(ast.Statement)
+	if !ok {
+		d.fail(at, "expected a statement, got %s", kindOf(n))
+	}
+	return s
+}
+
+func (d *decoder) expression(required bool) ast.Expression {
+	at := d.off
+	n := d.node()
+	if n == nil {
+		d.missing(at, required, "expression")
+		return nil
+	}
+	x, ok := n.(ast.Expression)
+	if !ok {
+		d.fail(at, "expected an expression, got %s", kindOf(n))
+	}
+	return x
+}
+
+func (d *decoder) pattern(required bool) ast.Pattern {
+	at := d.off
+	n := d.node()
+	if n == nil {
+		d.missing(at, required, "pattern")
+		return nil
+	}
+	p, ok := n.(ast.Pattern)
+	if !ok {
+		d.fail(at, "expected a pattern, got %s", kindOf(n))
+	}
+	return p
+}
+
+func (d *decoder) block(required bool) *ast.BlockStatement {
+	at := d.off
+	n := d.node()
+	if n == nil {
+		d.missing(at, required, "block")
+		return nil
+	}
+	b, ok := n.(*ast.BlockStatement)
+	if !ok {
+		d.fail(at, "expected a block, got %s", kindOf(n))
+	}
+	return b
+}
+
+func (d *decoder) ident(required bool) *ast.Identifier {
+	at := d.off
+	n := d.node()
+	if n == nil {
+		d.missing(at, required, "identifier")
+		return nil
+	}
+	i, ok := n.(*ast.Identifier)
+	if !ok {
+		d.fail(at, "expected an identifier, got %s", kindOf(n))
+	}
+	return i
+}
+
+func (d *decoder) missing(at int, required bool, what string) {
+	if required {
+		d.fail(at, "missing %s", what)
+	}
+}
+
+// node reads a node in preorder, or nil for tagNil
+func (d *decoder) node() ast.Node {
+	at := d.off
+	tag := d.byte()
+	if tag == tagNil {
+		return nil
+	}
+	if tag >= numTags {
+		d.fail(at, "unknown node tag %d", tag)
+	}
+
+	i := len(d.entries)
+	if d.entries != nil {
+		d.entries = append(d.entries, entry{offset: at, depth: d.depth})
+	}
+	d.depth++
+	n := d.fields(at, tag)
+	d.depth--
+	if d.entries != nil {
+		d.entries[i].node = n
+	}
+	return n
+}
+
+func (d *decoder) fields(at int, tag byte) ast.Node {
+	switch tag {
+	case tagLet:
+		n := &ast.LetStatement{Token: d.token()}
+		n.Name = d.ident(false)
+		n.Pattern = d.pattern(false)
+		n.Value = d.expression(true)
+		if (n.Name == nil) == (n.Pattern == nil) {
+			d.fail(at, "let needs either a name or a pattern")
+		}
+		return n
+	case tagReturn:
+		return &ast.ReturnStatement{Token: d.token(), ReturnValue: d.expression(false)}
+	case tagThrow:
+		return &ast.ThrowStatement{Token: d.token(), Value: d.expression(true)}
+	case tagWhile:
+		n := &ast.WhileStatement{Token: d.token(), Condition: d.expression(true)}
+		n.Body = d.loopBody()
+		return n
+	case tagFor:
+		n := &ast.ForStatement{Token: d.token()}
+		n.Key = d.ident(false)
+		n.Value = d.ident(true)
+		n.Iterable = d.expression(true)
+		n.Body = d.loopBody()
+		return n
+	case tagBreak:
+		n := &ast.BreakStatement{Token: d.token()}
+		if d.loops == 0 {
+			d.fail(at, "break outside of a loop")
+		}
+		return n
+	case tagContinue:
+		n := &ast.ContinueStatement{Token: d.token()}
+		if d.loops == 0 {
+			d.fail(at, "continue outside of a loop")
+		}
+		return n
+	case tagExpressionStatement:
+		return &ast.ExpressionStatement{Token: d.token(), Expression: d.expression(false)}
+	case tagBlock:
+		n := &ast.BlockStatement{Token: d.token()}
+		n.Statements = make([]ast.Statement, d.count())
+		for i := range n.Statements {
+			n.Statements[i] = d.statement(true)
+		}
+		n.RBrace = d.token()
+		return n
+
+	case tagIdentifier:
+		return &ast.Identifier{Token: d.token(), Value: d.str()}
+	case tagInteger:
+		return &ast.IntegerLiteral{Token: d.token(), Value: d.constOf(constInt).i}
+	case tagBigInteger:
+		return &ast.BigIntegerLiteral{Token: d.token(), Value: d.constOf(constBigInt).big}
+	case tagFloat:
+		return &ast.FloatLiteral{Token: d.token(), Value: d.constOf(constFloat).f}
+	case tagString:
+		return &ast.StringLiteral{Token: d.token(), Value: d.constOf(constString).s}
+	case tagBoolean:
+		return &ast.Boolean{Token: d.token(), Value: d.constOf(constBool).b}
+	case tagInterpolated:
+		n := &ast.InterpolatedString{Token: d.token()}
+		n.Parts = make([]ast.Expression, d.count())
+		if len(n.Parts)%2 == 0 {
+			d.fail(at, "interpolated string with %d parts", len(n.Parts))
+		}
+		for i := range n.Parts {
+			partAt := d.off
+			n.Parts[i] = d.expression(true)
+			if _, ok := n.Parts[i].(*ast.StringLiteral); i%2 == 0 && !ok {
+				d.fail(partAt, "expected a string segment, got %s", kindOf(n.Parts[i]))
+			}
+		}
+		return n
+	case tagPrefix:
+		return &ast.PrefixExpression{Token: d.token(), Operator: d.str(), Right: d.expression(true)}
+	case tagInfix:
+		n := &ast.InfixExpression{Token: d.token()}
+		n.Left = d.expression(true)
+		n.Operator = d.str()
+		n.Right = d.expression(true)
+		return n
+	case tagAssign:
+		n := &ast.AssignExpression{Token: d.token()}
+		n.Target = d.expression(true)
+		n.Operator = d.str()
+		n.Value = d.expression(true)
+		return n
+	case tagIf:
+		n := &ast.IfExpression{Token: d.token()}
+		n.Condition = d.expression(true)
+		n.Consequence = d.block(true)
+		n.Alternative = d.block(false)
+		return n
+	case tagTry:
+		n := &ast.TryExpression{Token: d.token()}
+		n.Block = d.block(true)
+		n.CatchParam = d.ident(false)
+		n.Catch = d.block(false)
+		n.Finally = d.block(false)
+		if (n.CatchParam == nil) != (n.Catch == nil) {
+			d.fail(at, "catch clause without its parameter or body")
+		}
+		if n.Catch == nil && n.Finally == nil {
+			d.fail(at, "try without catch or finally")
+		}
+		return n
+	case tagFunction:
+		loops := d.loops
+		d.loops = 0
+		n := &ast.FunctionLiteral{Token: d.token()}
+		n.Parameters = make([]ast.Pattern, d.count())
+		for i := range n.Parameters {
+			n.Parameters[i] = d.pattern(true)
+		}
+		n.Rest = d.ident(false)
+		n.Body = d.block(true)
+		n.Name = d.str()
+		d.loops = loops
+		return n
+	case tagSpread:
+		return &ast.SpreadExpression{Token: d.token(), Value: d.expression(true)}
+	case tagCall:
+		n := &ast.CallExpression{Token: d.token()}
+		n.Function = d.expression(true)
+		n.Arguments = make([]ast.Expression, d.count())
+		for i := range n.Arguments {
+			n.Arguments[i] = d.expression(true)
+		}
+		n.RParen = d.token()
+		return n
+	case tagArray:
+		n := &ast.ArrayLiteral{Token: d.token()}
+		n.Elements = make([]ast.Expression, d.count())
+		for i := range n.Elements {
+			n.Elements[i] = d.expression(true)
+		}
+		n.RBracket = d.token()
+		return n
+	case tagIndex:
+		n := &ast.IndexExpression{Token: d.token()}
+		n.Left = d.expression(true)
+		n.Index = d.expression(true)
+		n.RBracket = d.token()
+		return n
+	case tagHash:
+		n := &ast.HashLiteral{Token: d.token()}
+		count := d.count()
+		n.Pairs = make(map[ast.Expression]ast.Expression, count)
+		for i := 0; i < count; i++ {
+			k := d.expression(true)
+			n.Pairs[k] = d.expression(true)
+		}
+		n.RBrace = d.token()
+		return n
+	case tagMatch:
+		n := &ast.MatchExpression{Token: d.token()}
+		n.Subject = d.expression(true)
+		n.Arms = make([]*ast.MatchArm, d.count())
+		for i := range n.Arms {
+			n.Arms[i] = d.arm()
+		}
+		n.RBrace = d.token()
+		return n
+
+	case tagWildcard:
+		return &ast.WildcardPattern{Token: d.token()}
+	case tagLiteralPattern:
+		return &ast.LiteralPattern{Value: d.expression(true)}
+	case tagArrayPattern:
+		n := &ast.ArrayPattern{Token: d.token()}
+		n.Elements = make([]ast.Pattern, d.count())
+		for i := range n.Elements {
+			n.Elements[i] = d.pattern(true)
+		}
+		n.Rest = d.ident(false)
+		n.RBracket = d.token()
+		return n
+	case tagDefaultPattern:
+		n := &ast.DefaultPattern{Token: d.token()}
+		n.Target = d.pattern(true)
+		n.Default = d.expression(true)
+		return n
+	case tagHashPattern:
+		n := &ast.HashPattern{Token: d.token()}
+		n.Pairs = make([]*ast.HashPatternPair, d.count())
+		for i := range n.Pairs {
+			n.Pairs[i] = &ast.HashPatternPair{Key: d.expression(true), Value: d.pattern(true)}
+		}
+		n.RBrace = d.token()
+		return n
+	}
+	panic("unreachable")
+}
+
+func (d *decoder) loopBody() *ast.BlockStatement {
+	d.loops++
+	b := d.block(true)
+	d.loops--
+	return b
+}
+
+func (d *decoder) arm() *ast.MatchArm {
+	at := d.off
+	i := len(d.entries)
+	if d.entries != nil {
+		d.entries = append(d.entries, entry{offset: at, depth: d.depth})
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+
+	arm := &ast.MatchArm{Patterns: make([]ast.Pattern, d.count())}
+	if len(arm.Patterns) == 0 {
+		d.fail(at, "match arm without patterns")
+	}
+	for i := range arm.Patterns {
+		arm.Patterns[i] = d.pattern(true)
+	}
+	arm.Guard = d.expression(false)
+	arm.Body = d.block(true)
+
+	if d.entries != nil {
+		d.entries[i].node = arm
+	}
+	return arm
+}
+
+// kindOf names the type of a node, like *ast.Identifier gives Identifier
+func kindOf(n ast.Node) string {
+	s := fmt.Sprintf("%T", n)
+	return s[len("*ast."):]
+}
@@ -0,0 +1,81 @@
+package astfile
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/clg0803/circus/ast"
+)
+
+// Dump checks a precompiled program like Load and prints it, the
+// pools and then one line per node, indented by depth, with its
+// offset in the file and the line and column it came from:
+//
+//	0059  1:1      LetStatement let
+//	0064  1:5        Identifier x
+//	0071  1:9        IntegerLiteral 5
+func Dump(w io.Writer, data []byte) error {
+	d := &decoder{data: data, entries: []entry{}}
+	if _, err := d.decode(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "precompiled program, version %d, %d bytes", d.version, len(data))
+	if d.filename != "" {
+		fmt.Fprintf(w, ", from %s", d.filename)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "strings (%d):\n", len(d.strings))
+	for i, s := range d.strings {
+		fmt.Fprintf(w, "  %4d  %q\n", i, s)
+	}
+	fmt.Fprintf(w, "constants (%d):\n", len(d.consts))
+	for i, c := range d.consts {
+		fmt.Fprintf(w, "  %4d  %-6s %s\n", i, constKindNames[c.kind], c)
+	}
+	fmt.Fprintf(w, "positions: %d\n", len(d.positions))
+
+	fmt.Fprintf(w, "nodes (%d bytes at %04d):\n", d.end-d.nodesAt, d.nodesAt)
+	for _, e := range d.entries {
+		pos := "-"
+		if p := e.node.Pos(); p.IsValid() {
+			pos = fmt.Sprintf("%d:%d", p.Line, p.Column)
+		}
+		fmt.Fprintf(w, "%04d  %-7s  %s%s\n", e.offset, pos,
+			strings.Repeat("  ", e.depth), describe(e.node))
+	}
+	return nil
+}
+
+// describe gives the kind of n and what its line of the dump shows
+func describe(n ast.Node) string {
+	var detail string
+	switch n := n.(type) {
+	case *ast.MatchArm:
+		return "MatchArm"
+	case *ast.LetStatement:
+		detail = n.Token.Literal
+	case *ast.Identifier:
+		detail = n.Value
+	case *ast.IntegerLiteral, *ast.BigIntegerLiteral, *ast.FloatLiteral,
+		*ast.Boolean:
+		detail = n.TokenLiteral()
+	case *ast.StringLiteral:
+		detail = fmt.Sprintf("%q", n.Value)
+	case *ast.PrefixExpression:
+		detail = n.Operator
+	case *ast.InfixExpression:
+		detail = n.Operator
+	case *ast.AssignExpression:
+		detail = n.Operator
+	case *ast.FunctionLiteral:
+		detail = n.Name
+	}
+
+	if detail == "" {
+		return kindOf(n)
+	}
+	return kindOf(n) + " " + detail
+}
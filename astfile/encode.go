@@ -0,0 +1,389 @@
+package astfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/token"
+)
+
+type encoder struct {
+	strings   []string
+	stringIdx map[string]int
+
+	consts   []constant
+	constIdx map[string]int
+
+	positions []token.Position // without the filename
+	posIdx    map[token.Position]int
+	filename  string
+	named     bool // filename was taken from a position
+
+	nodes []byte
+	err   error
+}
+
+// Encode gives the precompiled form of program
+func Encode(program *ast.Program) ([]byte, error) {
+	e := &encoder{
+		stringIdx: map[string]int{},
+		constIdx:  map[string]int{},
+		posIdx:    map[token.Position]int{},
+	}
+	e.uvarint(len(program.Statements))
+	for _, s := range program.Statements {
+		e.node(s)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	filename := e.str(e.filename)
+
+	out := []byte(Magic)
+	out = append(out, Version>>8, Version&0xff)
+
+	out = appendUvarint(out, uint64(len(e.strings)))
+	for _, s := range e.strings {
+		out = appendUvarint(out, uint64(len(s)))
+		out = append(out, s...)
+	}
+
+	out = appendUvarint(out, uint64(len(e.consts)))
+	for _, c := range e.consts {
+		out = append(out, byte(c.kind))
+		switch c.kind {
+		case constInt:
+			out = appendVarint(out, c.i)
+		case constBigInt:
+			b := c.big.Bytes()
+			out = append(out, byte(c.big.Sign()+1))
+			out = appendUvarint(out, uint64(len(b)))
+			out = append(out, b...)
+		case constFloat:
+			out = appendUint64(out, math.Float64bits(c.f))
+		case constString:
+			out = appendUvarint(out, uint64(e.str(c.s)))
+		case constBool:
+			if c.b {
+				out = append(out, 1)
+			} else {
+				out = append(out, 0)
+			}
+		}
+	}
+
+	out = appendUvarint(out, uint64(len(e.positions)))
+	for _, p := range e.positions {
+		out = appendUvarint(out, uint64(p.Offset))
+		out = appendUvarint(out, uint64(p.Line))
+		out = appendUvarint(out, uint64(p.Column))
+	}
+
+	out = appendUvarint(out, uint64(filename))
+	out = appendUvarint(out, uint64(len(e.nodes)))
+	out = append(out, e.nodes...)
+
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(out))
+	return append(out, sum...), nil
+}
+
+func appendUvarint(b []byte, n uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], n)]...)
+}
+
+func appendVarint(b []byte, n int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], n)]...)
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	return append(b, buf[:]...)
+}
+
+func (e *encoder) uvarint(n int) { e.nodes = appendUvarint(e.nodes, uint64(n)) }
+
+// str interns s in the string pool
+func (e *encoder) str(s string) int {
+	if i, ok := e.stringIdx[s]; ok {
+		return i
+	}
+	e.strings = append(e.strings, s)
+	e.stringIdx[s] = len(e.strings) - 1
+	return len(e.strings) - 1
+}
+
+func (e *encoder) constant(c constant) {
+	key := fmt.Sprintf("%d:%s", c.kind, c)
+	i, ok := e.constIdx[key]
+	if !ok {
+		e.consts = append(e.consts, c)
+		i = len(e.consts) - 1
+		e.constIdx[key] = i
+		if c.kind == constString {
+			e.str(c.s)
+		}
+	}
+	e.uvarint(i)
+}
+
+func (e *encoder) pos(p token.Position) {
+	if p.IsValid() {
+		switch {
+		case !e.named:
+			e.filename, e.named = p.Filename, true
+		case p.Filename != e.filename && e.err == nil:
+			e.err = fmt.Errorf("astfile: positions in both %q and %q", e.filename, p.Filename)
+		}
+	}
+	p.Filename = ""
+
+	i, ok := e.posIdx[p]
+	if !ok {
+		e.positions = append(e.positions, p)
+		i = len(e.positions) - 1
+		e.posIdx[p] = i
+	}
+	e.uvarint(i)
+}
+
+func (e *encoder) token(t token.Token) {
+	e.uvarint(e.str(string(t.Type)))
+	e.uvarint(e.str(t.Literal))
+	e.pos(t.Pos)
+	e.pos(t.End)
+}
+
+func (e *encoder) tag(t byte) { e.nodes = append(e.nodes, t) }
+
+func (e *encoder) block(b *ast.BlockStatement) {
+	if b == nil {
+		e.tag(tagNil)
+		return
+	}
+	e.node(b)
+}
+
+func (e *encoder) ident(i *ast.Identifier) {
+	if i == nil {
+		e.tag(tagNil)
+		return
+	}
+	e.node(i)
+}
+
+// node writes n in preorder, n may be a nil interface
+func (e *encoder) node(n ast.Node) {
+	switch n := n.(type) {
+	case nil:
+		e.tag(tagNil)
+
+	case *ast.LetStatement:
+		e.tag(tagLet)
+		e.token(n.Token)
+		e.ident(n.Name)
+		e.node(n.Pattern)
+		e.node(n.Value)
+	case *ast.ReturnStatement:
+		e.tag(tagReturn)
+		e.token(n.Token)
+		e.node(n.ReturnValue)
+	case *ast.ThrowStatement:
+		e.tag(tagThrow)
+		e.token(n.Token)
+		e.node(n.Value)
+	case *ast.WhileStatement:
+		e.tag(tagWhile)
+		e.token(n.Token)
+		e.node(n.Condition)
+		e.block(n.Body)
+	case *ast.ForStatement:
+		e.tag(tagFor)
+		e.token(n.Token)
+		e.ident(n.Key)
+		e.ident(n.Value)
+		e.node(n.Iterable)
+		e.block(n.Body)
+	case *ast.BreakStatement:
+		e.tag(tagBreak)
+		e.token(n.Token)
+	case *ast.ContinueStatement:
+		e.tag(tagContinue)
+		e.token(n.Token)
+	case *ast.ExpressionStatement:
+		e.tag(tagExpressionStatement)
+		e.token(n.Token)
+		e.node(n.Expression)
+	case *ast.BlockStatement:
+		e.tag(tagBlock)
+		e.token(n.Token)
+		e.uvarint(len(n.Statements))
+		for _, s := range n.Statements {
+			e.node(s)
+		}
+		e.token(n.RBrace)
+
+	case *ast.Identifier:
+		e.tag(tagIdentifier)
+		e.token(n.Token)
+		e.uvarint(e.str(n.Value))
+	case *ast.IntegerLiteral:
+		e.tag(tagInteger)
+		e.token(n.Token)
+		e.constant(constant{kind: constInt, i: n.Value})
+	case *ast.BigIntegerLiteral:
+		e.tag(tagBigInteger)
+		e.token(n.Token)
+		e.constant(constant{kind: constBigInt, big: n.Value})
+	case *ast.FloatLiteral:
+		e.tag(tagFloat)
+		e.token(n.Token)
+		e.constant(constant{kind: constFloat, f: n.Value})
+	case *ast.StringLiteral:
+		e.tag(tagString)
+		e.token(n.Token)
+		e.constant(constant{kind: constString, s: n.Value})
+	case *ast.Boolean:
+		e.tag(tagBoolean)
+		e.token(n.Token)
+		e.constant(constant{kind: constBool, b: n.Value})
+	case *ast.InterpolatedString:
+		e.tag(tagInterpolated)
+		e.token(n.Token)
+		e.uvarint(len(n.Parts))
+		for _, p := range n.Parts {
+			e.node(p)
+		}
+	case *ast.PrefixExpression:
+		e.tag(tagPrefix)
+		e.token(n.Token)
+		e.uvarint(e.str(n.Operator))
+		e.node(n.Right)
+	case *ast.InfixExpression:
+		e.tag(tagInfix)
+		e.token(n.Token)
+		e.node(n.Left)
+		e.uvarint(e.str(n.Operator))
+		e.node(n.Right)
+	case *ast.AssignExpression:
+		e.tag(tagAssign)
+		e.token(n.Token)
+		e.node(n.Target)
+		e.uvarint(e.str(n.Operator))
+		e.node(n.Value)
+	case *ast.IfExpression:
+		e.tag(tagIf)
+		e.token(n.Token)
+		e.node(n.Condition)
+		e.block(n.Consequence)
+		e.block(n.Alternative)
+	case *ast.TryExpression:
+		e.tag(tagTry)
+		e.token(n.Token)
+		e.block(n.Block)
+		e.ident(n.CatchParam)
+		e.block(n.Catch)
+		e.block(n.Finally)
+	case *ast.FunctionLiteral:
+		e.tag(tagFunction)
+		e.token(n.Token)
+		e.uvarint(len(n.Parameters))
+		for _, p := range n.Parameters {
+			e.node(p)
+		}
+		e.ident(n.Rest)
+		e.block(n.Body)
+		e.uvarint(e.str(n.Name))
+	case *ast.SpreadExpression:
+		e.tag(tagSpread)
+		e.token(n.Token)
+		e.node(n.Value)
+	case *ast.CallExpression:
+		e.tag(tagCall)
+		e.token(n.Token)
+		e.node(n.Function)
+		e.uvarint(len(n.Arguments))
+		for _, a := range n.Arguments {
+			e.node(a)
+		}
+		e.token(n.RParen)
+	case *ast.ArrayLiteral:
+		e.tag(tagArray)
+		e.token(n.Token)
+		e.uvarint(len(n.Elements))
+		for _, el := range n.Elements {
+			e.node(el)
+		}
+		e.token(n.RBracket)
+	case *ast.IndexExpression:
+		e.tag(tagIndex)
+		e.token(n.Token)
+		e.node(n.Left)
+		e.node(n.Index)
+		e.token(n.RBracket)
+	case *ast.HashLiteral:
+		e.tag(tagHash)
+		e.token(n.Token)
+		e.uvarint(len(n.Pairs))
+		for _, k := range ast.SortedKeys(n) {
+			e.node(k)
+			e.node(n.Pairs[k])
+		}
+		e.token(n.RBrace)
+	case *ast.MatchExpression:
+		e.tag(tagMatch)
+		e.token(n.Token)
+		e.node(n.Subject)
+		e.uvarint(len(n.Arms))
+		for _, arm := range n.Arms {
+			e.uvarint(len(arm.Patterns))
+			for _, p := range arm.Patterns {
+				e.node(p)
+			}
+			e.node(arm.Guard)
+			e.block(arm.Body)
+		}
+		e.token(n.RBrace)
+
+	case *ast.WildcardPattern:
+		e.tag(tagWildcard)
+		e.token(n.Token)
+	case *ast.LiteralPattern:
+		e.tag(tagLiteralPattern)
+		e.node(n.Value)
+	case *ast.ArrayPattern:
+		e.tag(tagArrayPattern)
+		e.token(n.Token)
+		e.uvarint(len(n.Elements))
+		for _, el := range n.Elements {
+			e.node(el)
+		}
+		e.ident(n.Rest)
+		e.token(n.RBracket)
+	case *ast.DefaultPattern:
+		e.tag(tagDefaultPattern)
+		e.token(n.Token)
+		e.node(n.Target)
+		e.node(n.Default)
+	case *ast.HashPattern:
+		e.tag(tagHashPattern)
+		e.token(n.Token)
+		e.uvarint(len(n.Pairs))
+		for _, pair := range n.Pairs {
+			e.node(pair.Key)
+			e.node(pair.Value)
+		}
+		e.token(n.RBrace)
+
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("astfile: cannot encode %T", n)
+		}
+	}
+}
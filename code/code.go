@@ -0,0 +1,306 @@
+// Package code defines the bytecode the compiler emits and the vm runs
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/clg0803/circus/token"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+// None fills an address operand that is not used, a try without catch
+// or a pattern whose mismatch is an error rather than a jump
+const None = 0xFFFF
+
+const (
+	OpConstant Opcode = iota // push constant 0
+	OpTrue
+	OpFalse
+	OpNull
+	OpPop
+	OpDup
+
+	OpInfix  // pop right and left, push `left Operators[0] right`
+	OpPrefix // pop right, push `Operators[0]right`
+
+	OpJump          // jump to 0
+	OpJumpNotTruthy // pop, jump to 0 when it is falsy
+	OpAndJump       // jump to 0 keeping a falsy top, else pop it
+	OpOrJump        // jump to 0 keeping a truthy top, else pop it
+
+	OpGetGlobal    // push global 0
+	OpSetGlobal    // assign the top to global 0, leaving it
+	OpDefineGlobal // pop into global 0, a const binding when 1 is 1
+	OpCheckGlobal  // fail unless global 0 may be assigned to
+	OpGetLocal
+	OpSetLocal
+	OpDefineLocal
+	OpCheckLocal
+	OpGetFree
+	OpSetFree
+	OpCheckFree
+	OpScope        // fresh variables for locals 0 up to 0+1
+	OpShadowGlobal // local 0 shadows global 1 until it is bound
+	OpShadowLocal
+	OpShadowFree
+
+	OpArray       // pop 0 elements, push the array
+	OpHash        // pop 0 keys and values, push the hash
+	OpAppend      // pop and append to the array below
+	OpSpread      // pop and append the elements to the array below
+	OpInterpolate // pop 0 values, push the string of them
+	OpIndex       // pop index and left, push left[index]
+	OpIndexTarget // check left[index] may be assigned to, push it when 0 is 1
+	OpSetIndex    // pop value, index and left, store and push value
+
+	OpCall        // call the function below 0 arguments
+	OpTailCall    // OpCall in tail position, replaces the frame
+	OpApply       // call the function below an array of arguments
+	OpTailApply   // OpApply in tail position
+	OpReturnValue // return the top
+	OpClosure     // push a closure of constant 0
+	OpCaptureLocal
+	OpCaptureFree
+	OpArg         // push argument 0 and jump to 1, fall through when missing
+	OpRestArgs    // push an array of the arguments from 0 on
+	OpEndPrologue // the arguments are bound
+
+	OpThrow       // pop and throw
+	OpTry         // catch at 0, finally at 1, both end at 2
+	OpLeave       // the try block or catch clause is done with the top
+	OpEndFinally  // pop, carry on with what ran the finally clause
+	OpLoop        // a loop starts, break and continue come back to this stack
+	OpEndLoop     // the loop is done
+	OpBreak       // jump to 0 out of the try blocks above depth 1 and the stack of the loop
+	OpIter        // pop, push an iterator over it
+	OpIterNext    // push the next key and value, or jump to 0 when done
+	OpIterNextKey // like OpIterNext but push only the key, or value
+
+	OpPatternBegin // a pattern is matched against the top, mismatch jumps to 0
+	OpPatternEnd   // the pattern matched
+	OpMatchLiteral // pop literal and value, mismatch unless equal
+	OpMatchArray   // mismatch unless the top is an array fitting 0 elements, 1 with rest
+	OpArrayElem    // push element 0 and jump to 1, fall through when missing
+	OpMissingElem  // mismatch, 0 elements are required, 1 with rest
+	OpArrayRest    // push an array of the elements from 0 on
+	OpMatchHash    // mismatch unless the top is a hash
+	OpHashElem     // pop key, push its value and jump to 0, fall through when missing
+	OpMissingKey   // mismatch, key constant 0 is missing
+)
+
+// Operators are the operators of OpInfix and OpPrefix by operand
+var Operators = []string{
+	"+", "-", "*", "/", "%", "**",
+	"<", ">", "<=", ">=", "==", "!=",
+	"&", "|", "^", "<<", ">>", "!",
+}
+
+// Operator gives the operand of OpInfix or OpPrefix for op
+func Operator(op string) (int, bool) {
+	for i, o := range Operators {
+		if o == op {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpNull:     {"OpNull", []int{}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+
+	OpInfix:  {"OpInfix", []int{1}},
+	OpPrefix: {"OpPrefix", []int{1}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpAndJump:       {"OpAndJump", []int{2}},
+	OpOrJump:        {"OpOrJump", []int{2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpDefineGlobal: {"OpDefineGlobal", []int{2, 1}},
+	OpCheckGlobal:  {"OpCheckGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{2}},
+	OpSetLocal:     {"OpSetLocal", []int{2}},
+	OpDefineLocal:  {"OpDefineLocal", []int{2, 1}},
+	OpCheckLocal:   {"OpCheckLocal", []int{2}},
+	OpGetFree:      {"OpGetFree", []int{2}},
+	OpSetFree:      {"OpSetFree", []int{2}},
+	OpCheckFree:    {"OpCheckFree", []int{2}},
+	OpScope:        {"OpScope", []int{2, 2}},
+	OpShadowGlobal: {"OpShadowGlobal", []int{2, 2}},
+	OpShadowLocal:  {"OpShadowLocal", []int{2, 2}},
+	OpShadowFree:   {"OpShadowFree", []int{2, 2}},
+
+	OpArray:       {"OpArray", []int{2}},
+	OpHash:        {"OpHash", []int{2}},
+	OpAppend:      {"OpAppend", []int{}},
+	OpSpread:      {"OpSpread", []int{}},
+	OpInterpolate: {"OpInterpolate", []int{2}},
+	OpIndex:       {"OpIndex", []int{}},
+	OpIndexTarget: {"OpIndexTarget", []int{1}},
+	OpSetIndex:    {"OpSetIndex", []int{}},
+
+	OpCall:         {"OpCall", []int{1}},
+	OpTailCall:     {"OpTailCall", []int{1}},
+	OpApply:        {"OpApply", []int{}},
+	OpTailApply:    {"OpTailApply", []int{}},
+	OpReturnValue:  {"OpReturnValue", []int{}},
+	OpClosure:      {"OpClosure", []int{2}},
+	OpCaptureLocal: {"OpCaptureLocal", []int{2}},
+	OpCaptureFree:  {"OpCaptureFree", []int{2}},
+	OpArg:          {"OpArg", []int{1, 2}},
+	OpRestArgs:     {"OpRestArgs", []int{1}},
+	OpEndPrologue:  {"OpEndPrologue", []int{}},
+
+	OpThrow:       {"OpThrow", []int{}},
+	OpTry:         {"OpTry", []int{2, 2, 2}},
+	OpLeave:       {"OpLeave", []int{}},
+	OpEndFinally:  {"OpEndFinally", []int{}},
+	OpLoop:        {"OpLoop", []int{}},
+	OpEndLoop:     {"OpEndLoop", []int{}},
+	OpBreak:       {"OpBreak", []int{2, 2}},
+	OpIter:        {"OpIter", []int{}},
+	OpIterNext:    {"OpIterNext", []int{2}},
+	OpIterNextKey: {"OpIterNextKey", []int{2}},
+
+	OpPatternBegin: {"OpPatternBegin", []int{2}},
+	OpPatternEnd:   {"OpPatternEnd", []int{}},
+	OpMatchLiteral: {"OpMatchLiteral", []int{}},
+	OpMatchArray:   {"OpMatchArray", []int{2, 1}},
+	OpArrayElem:    {"OpArrayElem", []int{2, 2}},
+	OpMissingElem:  {"OpMissingElem", []int{2, 1}},
+	OpArrayRest:    {"OpArrayRest", []int{2}},
+	OpMatchHash:    {"OpMatchHash", []int{}},
+	OpHashElem:     {"OpHashElem", []int{2}},
+	OpMissingKey:   {"OpMissingKey", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes op and its operands, big endian
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	length := 1
+	for _, w := range def.OperandWidths {
+		length += w
+	}
+
+	ins := make([]byte, length)
+	ins[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		switch def.OperandWidths[i] {
+		case 2:
+			binary.BigEndian.PutUint16(ins[offset:], uint16(o))
+		case 1:
+			ins[offset] = byte(o)
+		}
+		offset += def.OperandWidths[i]
+	}
+
+	return ins
+}
+
+// ReadOperands decodes the operands of def from ins,
+// it also reports how many bytes they took
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, w := range def.OperandWidths {
+		switch w {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += w
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 { return binary.BigEndian.Uint16(ins) }
+
+func ReadUint8(ins Instructions) uint8 { return ins[0] }
+
+// String disassembles ins, one instruction per line
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			return out.String()
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), len(def.OperandWidths))
+	}
+
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for _, o := range operands {
+		fmt.Fprintf(&out, " %d", o)
+	}
+	return out.String()
+}
+
+// SourceMap gives the source position of instructions, an entry
+// covers the instructions from its Offset up to the next entry
+type SourceMap []SourceEntry
+
+type SourceEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// Lookup gives the position of the instruction at offset
+func (sm SourceMap) Lookup(offset int) token.Position {
+	i := sort.Search(len(sm), func(i int) bool { return sm[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return sm[i-1].Pos
+}
@@ -0,0 +1,126 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/clg0803/circus/token"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpInfix, []int{3}, []byte{byte(OpInfix), 3}},
+		{OpPop, []int{}, []byte{byte(OpPop)}},
+		{OpDefineLocal, []int{258, 1}, []byte{byte(OpDefineLocal), 1, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		ins := Make(tt.op, tt.operands...)
+
+		if len(ins) != len(tt.expected) {
+			t.Fatalf("instruction has wrong length. want=%d, got=%d",
+				len(tt.expected), len(ins))
+		}
+		for i, b := range tt.expected {
+			if ins[i] != b {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d", i, b, ins[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpConstant, 1),
+		Make(OpInfix, 0),
+		Make(OpTry, 12, None, 20),
+		Make(OpPop),
+	}
+
+	expected := `0000 OpConstant 1
+0003 OpInfix 0
+0005 OpTry 12 65535 20
+0012 OpPop
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpArg, []int{3, 400}, 3},
+		{OpScope, []int{1, 2}, 4},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestOperator(t *testing.T) {
+	for i, op := range Operators {
+		if got, ok := Operator(op); !ok || got != i {
+			t.Errorf("Operator(%q) = %d, %t. want=%d", op, got, ok, i)
+		}
+	}
+	if _, ok := Operator("=>"); ok {
+		t.Errorf("Operator(%q) found", "=>")
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	sm := SourceMap{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 5, Pos: token.Position{Line: 2, Column: 3}},
+		{Offset: 9, Pos: token.Position{Line: 3, Column: 1}},
+	}
+
+	tests := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{0, token.Position{Line: 1, Column: 1}},
+		{4, token.Position{Line: 1, Column: 1}},
+		{5, token.Position{Line: 2, Column: 3}},
+		{8, token.Position{Line: 2, Column: 3}},
+		{20, token.Position{Line: 3, Column: 1}},
+	}
+
+	for _, tt := range tests {
+		if got := sm.Lookup(tt.offset); got != tt.expected {
+			t.Errorf("Lookup(%d) = %+v. want=%+v", tt.offset, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,785 @@
+// Package compiler lowers a parsed program to bytecode for the vm.
+//
+// Names are resolved once, at compile time: the names a let binds
+// anywhere in a function or block belong to it from its start. Until
+// the let has run, a use of such a name gets the variable of the same
+// name further out, as it would in the evaluator
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/code"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/token"
+)
+
+// Bytecode is a compiled program, Main runs its top level
+type Bytecode struct {
+	Main      *object.CompiledFunction
+	Constants []object.Object
+	Globals   []string // names of the globals by index
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes []*CompilationScope
+
+	// pos is the position of the node being compiled,
+	// the instructions emitted for it are mapped to it
+	pos token.Position
+}
+
+// CompilationScope collects the instructions of one function
+type CompilationScope struct {
+	instructions code.Instructions
+	sourceMap    code.SourceMap
+	loops        []*loop // innermost last
+	tryDepth     int     // try expressions around the current node
+}
+
+type loop struct {
+	continueAt int
+	breaks     []int // jumps to patch with the end of the loop
+	tryDepth   int
+}
+
+func New() *Compiler {
+	return &Compiler{
+		symbolTable: NewSymbolTable(),
+		scopes:      []*CompilationScope{{}},
+	}
+}
+
+// NewWithState compiles on from an earlier program, like each line of
+// the repl does: its globals and constants are kept
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	c := New()
+	c.symbolTable, c.constants = s, constants
+	return c
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	s := c.scope()
+	return &Bytecode{
+		Main: &object.CompiledFunction{
+			Instructions: s.instructions,
+			SourceMap:    s.sourceMap,
+			NumLocals:    c.symbolTable.NumLocals(),
+			LocalNames:   c.symbolTable.localNames,
+		},
+		Constants: c.constants,
+		Globals:   c.symbolTable.globalNames,
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	defer c.at(node.Pos())()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		if err := c.compileStatements(node.Statements, false); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+		return c.checkLimits()
+
+	case *ast.BlockStatement:
+		return c.compileStatements(node.Statements, false)
+	case *ast.ExpressionStatement:
+		return c.Compile(node.Expression)
+
+	case *ast.LetStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		constant := node.Token.Type == token.CONST
+		if node.Pattern != nil {
+			if err := c.destructure(node.Pattern, constant); err != nil {
+				return err
+			}
+		} else {
+			c.define(c.symbolTable.Define(node.Name.Value), constant)
+		}
+		c.emit(code.OpNull)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.WhileStatement:
+		return c.compileWhile(node)
+	case *ast.ForStatement:
+		return c.compileFor(node)
+	case *ast.BreakStatement:
+		l, err := c.innermostLoop(node.Pos(), "break")
+		if err != nil {
+			return err
+		}
+		l.breaks = append(l.breaks, c.jumpOut(code.None, l))
+	case *ast.ContinueStatement:
+		l, err := c.innermostLoop(node.Pos(), "continue")
+		if err != nil {
+			return err
+		}
+		c.jumpOut(l.continueAt, l)
+
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.BigIntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.BigInteger{Value: node.Value}))
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.InterpolatedString:
+		for _, p := range node.Parts {
+			if err := c.Compile(p); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := code.Operator(node.Operator)
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emit(code.OpPrefix, op)
+	case *ast.InfixExpression:
+		return c.compileInfix(node)
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
+
+	case *ast.Identifier:
+		c.load(c.symbolTable.ResolveGlobal(node.Value))
+
+	case *ast.IfExpression:
+		return c.compileIf(node, false)
+	case *ast.TryExpression:
+		return c.compileTry(node)
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node)
+	case *ast.CallExpression:
+		return c.compileCall(node, false)
+
+	case *ast.ArrayLiteral:
+		if hasSpread(node.Elements) || len(node.Elements) > 0xFFFF {
+			return c.compileList(node.Elements)
+		}
+		for _, e := range node.Elements {
+			if err := c.Compile(e); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		keys := ast.SortedKeys(node)
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(keys))
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	default:
+		return fmt.Errorf("%s: cannot compile %T", node.Pos(), node)
+	}
+
+	return nil
+}
+
+// compileStatements leaves the value of the last statement,
+// null for none. In tail position so is the last statement
+func (c *Compiler) compileStatements(stmts []ast.Statement, tail bool) error {
+	if len(stmts) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	for i, s := range stmts {
+		if i > 0 {
+			c.emit(code.OpPop)
+		}
+		var err error
+		if tail && i == len(stmts)-1 {
+			err = c.compileTail(s)
+		} else {
+			err = c.Compile(s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileTail compiles node in tail position of a function body,
+// the positions are the ones the evaluator trampolines calls in
+func (c *Compiler) compileTail(node ast.Node) error {
+	defer c.at(node.Pos())()
+
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		return c.compileStatements(node.Statements, true)
+	case *ast.ExpressionStatement:
+		return c.compileTail(node.Expression)
+	case *ast.ReturnStatement:
+		if err := c.compileTail(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+		return nil
+	case *ast.IfExpression:
+		return c.compileIf(node, true)
+	case *ast.CallExpression:
+		return c.compileCall(node, true)
+	default:
+		return c.Compile(node)
+	}
+}
+
+func (c *Compiler) compileInfix(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	// short-circuit, the deciding operand is the result
+	if node.Operator == "&&" || node.Operator == "||" {
+		jump := code.OpAndJump
+		if node.Operator == "||" {
+			jump = code.OpOrJump
+		}
+		at := c.emit(jump, code.None)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.patch(at, 0, c.here())
+		return nil
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	op, ok := code.Operator(node.Operator)
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	c.emit(code.OpInfix, op)
+	return nil
+}
+
+// compileAssign checks the target before the value is evaluated,
+// as the evaluator does, a compound assignment reads it first
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	compound := node.Operator != "="
+	var sym Symbol
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		sym = c.symbolTable.ResolveGlobal(target.Value)
+		c.emit(checkOps[sym.Scope], sym.Index)
+		if compound {
+			c.load(sym)
+		}
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		flag := 0
+		if compound {
+			flag = 1
+		}
+		c.emit(code.OpIndexTarget, flag)
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target)
+	}
+
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	if compound {
+		op, _ := code.Operator(node.Operator[:len(node.Operator)-1])
+		c.emit(code.OpInfix, op)
+	}
+
+	if _, ok := node.Target.(*ast.Identifier); ok {
+		c.emit(setOps[sym.Scope], sym.Index)
+	} else {
+		c.emit(code.OpSetIndex)
+	}
+	return nil
+}
+
+func (c *Compiler) compileIf(node *ast.IfExpression, tail bool) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	toElse := c.emit(code.OpJumpNotTruthy, code.None)
+
+	if err := c.compileBranch(node.Consequence, tail); err != nil {
+		return err
+	}
+	toEnd := c.emit(code.OpJump, code.None)
+
+	c.patch(toElse, 0, c.here())
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBranch(node.Alternative, tail); err != nil {
+		return err
+	}
+	c.patch(toEnd, 0, c.here())
+
+	return nil
+}
+
+func (c *Compiler) compileBranch(b *ast.BlockStatement, tail bool) error {
+	if tail {
+		return c.compileTail(b)
+	}
+	return c.Compile(b)
+}
+
+func (c *Compiler) compileWhile(node *ast.WhileStatement) error {
+	c.emit(code.OpLoop)
+	l := &loop{continueAt: c.here(), tryDepth: c.scope().tryDepth}
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(code.OpJumpNotTruthy, code.None)
+
+	if err := c.compileLoopBody(l, node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, l.continueAt)
+
+	c.patch(exit, 0, c.here())
+	c.patchBreaks(l)
+	c.emit(code.OpEndLoop)
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileFor gives each round a scope of its own, the iterator
+// stays on the stack while the loop runs
+func (c *Compiler) compileFor(node *ast.ForStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	c.emit(code.OpLoop)
+
+	l := &loop{continueAt: c.here(), tryDepth: c.scope().tryDepth}
+	var next int
+	if node.Key != nil {
+		next = c.emit(code.OpIterNext, code.None) // pushes value, then key
+	} else {
+		next = c.emit(code.OpIterNextKey, code.None)
+	}
+
+	vars := []ast.Pattern{node.Value}
+	if node.Key != nil {
+		vars = append(vars, node.Key)
+	}
+	c.enterBlock(evaluator.Declarations(vars, node.Body))
+	if node.Key != nil {
+		c.define(c.symbolTable.Define(node.Key.Value), false)
+	}
+	c.define(c.symbolTable.Define(node.Value.Value), false)
+
+	if err := c.compileLoopBody(l, node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, l.continueAt)
+	c.symbolTable.PopBlock()
+
+	c.patch(next, 0, c.here())
+	c.patchBreaks(l)
+	c.emit(code.OpEndLoop)
+	c.emit(code.OpPop) // the iterator
+	c.emit(code.OpNull)
+	return nil
+}
+
+func (c *Compiler) compileLoopBody(l *loop, body *ast.BlockStatement) error {
+	s := c.scope()
+	s.loops = append(s.loops, l)
+	defer func() { s.loops = s.loops[:len(s.loops)-1] }()
+
+	if err := c.Compile(body); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	return nil
+}
+
+// innermostLoop gives the loop a break or continue leaves, the
+// parser rejects one outside a loop but a tree may come from elsewhere
+func (c *Compiler) innermostLoop(pos token.Position, keyword string) (*loop, error) {
+	loops := c.scope().loops
+	if len(loops) == 0 {
+		return nil, fmt.Errorf("%s: %s outside of a loop", pos, keyword)
+	}
+	return loops[len(loops)-1], nil
+}
+
+// jumpOut jumps to target of loop l, running the finally clauses
+// of the try expressions it leaves on the way. The break may sit in
+// an expression, what it leaves on the stack goes too
+func (c *Compiler) jumpOut(target int, l *loop) int {
+	return c.emit(code.OpBreak, target, l.tryDepth)
+}
+
+func (c *Compiler) patchBreaks(l *loop) {
+	for _, at := range l.breaks {
+		c.patch(at, 0, c.here())
+	}
+}
+
+// compileTry lays out the block, the catch clause and the finally
+// clause one after another, OpTry tells the vm where each starts
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	s := c.scope()
+	s.tryDepth++
+	defer func() { s.tryDepth-- }()
+
+	try := c.emit(code.OpTry, code.None, code.None, code.None)
+	if err := c.Compile(node.Block); err != nil {
+		return err
+	}
+	c.emit(code.OpLeave)
+
+	if node.Catch != nil {
+		c.patch(try, 0, c.here())
+		c.enterBlock(evaluator.Declarations([]ast.Pattern{node.CatchParam}, node.Catch))
+		c.define(c.symbolTable.Define(node.CatchParam.Value), false)
+		if err := c.Compile(node.Catch); err != nil {
+			return err
+		}
+		c.symbolTable.PopBlock()
+		c.emit(code.OpLeave)
+	}
+
+	if node.Finally != nil {
+		c.patch(try, 1, c.here())
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpEndFinally)
+	}
+
+	c.patch(try, 2, c.here())
+	return nil
+}
+
+// compileFunction compiles the function body after a prologue that
+// binds the arguments, OpClosure then captures the free variables
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	params := node.Parameters[:len(node.Parameters):len(node.Parameters)]
+	if node.Rest != nil {
+		params = append(params, node.Rest)
+	}
+	shadows := c.declare(evaluator.Declarations(params, node.Body))
+
+	if len(node.Parameters) > 0xFF {
+		return fmt.Errorf("%s: too many parameters", node.Pos())
+	}
+	c.link(shadows)
+	if err := c.compilePrologue(node); err != nil {
+		return err
+	}
+
+	if err := c.compileStatements(node.Body.Statements, true); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	table := c.symbolTable
+	s := c.leaveScope()
+	if len(s.instructions) > 0xFFFF || table.NumLocals() > 0xFFFF {
+		return fmt.Errorf("%s: function too large", node.Pos())
+	}
+
+	fn := &object.CompiledFunction{
+		Instructions: s.instructions,
+		SourceMap:    s.sourceMap,
+		NumLocals:    table.NumLocals(),
+		NumParams:    len(node.Parameters),
+		MinArgs:      requiredCount(node.Parameters),
+		Rest:         node.Rest != nil,
+		Name:         node.Name,
+		Source:       (&object.Function{Parameters: node.Parameters, Rest: node.Rest, Body: node.Body}).Inspect(),
+		LocalNames:   table.localNames,
+		FreeNames:    table.freeNames(),
+	}
+	c.emit(code.OpClosure, c.addConstant(fn))
+	for _, sym := range table.FreeSymbols {
+		if sym.Scope == LocalScope {
+			c.emit(code.OpCaptureLocal, sym.Index)
+		} else {
+			c.emit(code.OpCaptureFree, sym.Index)
+		}
+	}
+	return nil
+}
+
+// compilePrologue binds the arguments, a missing one to its default.
+// A mismatch has no position here, the vm blames the call for it
+func (c *Compiler) compilePrologue(node *ast.FunctionLiteral) error {
+	for i, p := range node.Parameters {
+		given := c.emit(code.OpArg, i, code.None)
+		target := p
+		if dp, ok := p.(*ast.DefaultPattern); ok {
+			if err := c.Compile(dp.Default); err != nil {
+				return err
+			}
+			target = dp.Target
+		}
+		c.patch(given, 1, c.here())
+
+		restore := c.at(token.Position{})
+		err := c.destructure(target, false)
+		restore()
+		if err != nil {
+			return err
+		}
+	}
+
+	if node.Rest != nil {
+		c.emit(code.OpRestArgs, len(node.Parameters))
+		c.define(c.symbolTable.Define(node.Rest.Value), false)
+	}
+	c.emit(code.OpEndPrologue)
+	return nil
+}
+
+// requiredCount counts the patterns up to the last one without a default
+func requiredCount(patterns []ast.Pattern) int {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if _, ok := patterns[i].(*ast.DefaultPattern); !ok {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (c *Compiler) compileCall(node *ast.CallExpression, tail bool) error {
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+
+	if hasSpread(node.Arguments) || len(node.Arguments) > 0xFF {
+		if err := c.compileList(node.Arguments); err != nil {
+			return err
+		}
+		if tail {
+			c.emit(code.OpTailApply)
+		} else {
+			c.emit(code.OpApply)
+		}
+		return nil
+	}
+
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+	}
+	if tail {
+		c.emit(code.OpTailCall, len(node.Arguments))
+	} else {
+		c.emit(code.OpCall, len(node.Arguments))
+	}
+	return nil
+}
+
+// compileList builds an array of elements one at a time,
+// spreading the ones written `...value`
+func (c *Compiler) compileList(elements []ast.Expression) error {
+	c.emit(code.OpArray, 0)
+	for _, e := range elements {
+		if se, ok := e.(*ast.SpreadExpression); ok {
+			restore := c.at(se.Pos())
+			if err := c.Compile(se.Value); err != nil {
+				return err
+			}
+			c.emit(code.OpSpread)
+			restore()
+			continue
+		}
+		if err := c.Compile(e); err != nil {
+			return err
+		}
+		c.emit(code.OpAppend)
+	}
+	return nil
+}
+
+func hasSpread(elements []ast.Expression) bool {
+	for _, e := range elements {
+		if _, ok := e.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	getOps    = map[SymbolScope]code.Opcode{GlobalScope: code.OpGetGlobal, LocalScope: code.OpGetLocal, FreeScope: code.OpGetFree}
+	setOps    = map[SymbolScope]code.Opcode{GlobalScope: code.OpSetGlobal, LocalScope: code.OpSetLocal, FreeScope: code.OpSetFree}
+	checkOps  = map[SymbolScope]code.Opcode{GlobalScope: code.OpCheckGlobal, LocalScope: code.OpCheckLocal, FreeScope: code.OpCheckFree}
+	shadowOps = map[SymbolScope]code.Opcode{GlobalScope: code.OpShadowGlobal, LocalScope: code.OpShadowLocal, FreeScope: code.OpShadowFree}
+)
+
+func (c *Compiler) load(sym Symbol) { c.emit(getOps[sym.Scope], sym.Index) }
+
+// define pops the top into the variable of sym, the let or
+// const binding it never is a free variable
+func (c *Compiler) define(sym Symbol, constant bool) {
+	flag := 0
+	if constant {
+		flag = 1
+	}
+	if sym.Scope == GlobalScope {
+		c.emit(code.OpDefineGlobal, sym.Index, flag)
+	} else {
+		c.emit(code.OpDefineLocal, sym.Index, flag)
+	}
+}
+
+// enterBlock opens a nested scope declaring names, each time the
+// code gets here the variables of the scope start out fresh
+func (c *Compiler) enterBlock(names []string) {
+	c.symbolTable.PushBlock()
+	start := c.symbolTable.NumLocals()
+	shadows := c.declare(names)
+	if n := c.symbolTable.NumLocals() - start; n > 0 {
+		c.emit(code.OpScope, start, n)
+	}
+	c.link(shadows)
+}
+
+// shadow is a local of the same name as the variable outer of an
+// enclosing scope, which uses of the local get until it is bound
+type shadow struct {
+	local, outer Symbol
+}
+
+// declare defines names in the innermost scope, it gives the shadows
+// among them for link
+func (c *Compiler) declare(names []string) []shadow {
+	var shadows []shadow
+	for _, name := range names {
+		outer := c.symbolTable.ResolveGlobal(name)
+		shadows = append(shadows, shadow{local: c.symbolTable.Define(name), outer: outer})
+	}
+	return shadows
+}
+
+// link points the fresh variables of shadows at what they shadow
+func (c *Compiler) link(shadows []shadow) {
+	for _, s := range shadows {
+		c.emit(shadowOps[s.outer.Scope], s.local.Index, s.outer.Index)
+	}
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, &CompilationScope{})
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() *CompilationScope {
+	s := c.scope()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.symbolTable = c.symbolTable.Outer
+	return s
+}
+
+func (c *Compiler) scope() *CompilationScope { return c.scopes[len(c.scopes)-1] }
+
+// here is the offset of the next instruction
+func (c *Compiler) here() int { return len(c.scope().instructions) }
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	s := c.scope()
+	at := len(s.instructions)
+	if n := len(s.sourceMap); n == 0 || s.sourceMap[n-1].Pos != c.pos {
+		s.sourceMap = append(s.sourceMap, code.SourceEntry{Offset: at, Pos: c.pos})
+	}
+	s.instructions = append(s.instructions, code.Make(op, operands...)...)
+	return at
+}
+
+// patch sets operand i of the instruction at offset at
+func (c *Compiler) patch(at, i, value int) {
+	ins := c.scope().instructions
+	def, _ := code.Lookup(ins[at])
+	operands, _ := code.ReadOperands(def, ins[at+1:])
+	operands[i] = value
+	copy(ins[at:], code.Make(code.Opcode(ins[at]), operands...))
+}
+
+// at makes pos the position of what is emitted
+// until the returned func restores the previous one
+func (c *Compiler) at(pos token.Position) func() {
+	prev := c.pos
+	c.pos = pos
+	return func() { c.pos = prev }
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// checkLimits fails a program whose operands would not fit
+func (c *Compiler) checkLimits() error {
+	switch {
+	case len(c.scope().instructions) > 0xFFFF:
+		return fmt.Errorf("program too large")
+	case len(c.constants) > 0xFFFF:
+		return fmt.Errorf("too many constants")
+	case len(c.symbolTable.globalNames) > 0xFFFF, c.symbolTable.NumLocals() > 0xFFFF:
+		return fmt.Errorf("too many variables")
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package compiler
+
+import (
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/code"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/object"
+)
+
+// compileMatch tries the patterns in order, each attempt in a fresh
+// scope, the subject stays on the stack until an arm is taken
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	var toEnd []int
+	for _, arm := range node.Arms {
+		c.symbolTable.PushBlock()
+		start := c.symbolTable.NumLocals()
+		shadows := c.declare(evaluator.Declarations(arm.Patterns, arm.Guard, arm.Body))
+		n := c.symbolTable.NumLocals() - start
+
+		var toBody []int
+		for _, p := range arm.Patterns {
+			if n > 0 {
+				c.emit(code.OpScope, start, n)
+			}
+			c.link(shadows)
+			c.emit(code.OpDup)
+			begin := c.emit(code.OpPatternBegin, code.None)
+			if err := c.compilePattern(p, false); err != nil {
+				return err
+			}
+			c.emit(code.OpPatternEnd)
+
+			guard := -1
+			if arm.Guard != nil {
+				if err := c.Compile(arm.Guard); err != nil {
+					return err
+				}
+				guard = c.emit(code.OpJumpNotTruthy, code.None)
+			}
+			toBody = append(toBody, c.emit(code.OpJump, code.None))
+
+			c.patch(begin, 0, c.here())
+			if guard >= 0 {
+				c.patch(guard, 0, c.here())
+			}
+		}
+		nextArm := c.emit(code.OpJump, code.None)
+
+		for _, at := range toBody {
+			c.patch(at, 0, c.here())
+		}
+		c.emit(code.OpPop) // the subject
+		if err := c.Compile(arm.Body); err != nil {
+			return err
+		}
+		toEnd = append(toEnd, c.emit(code.OpJump, code.None))
+
+		c.symbolTable.PopBlock()
+		c.patch(nextArm, 0, c.here())
+	}
+
+	c.emit(code.OpPop)
+	c.emit(code.OpNull)
+	for _, at := range toEnd {
+		c.patch(at, 0, c.here())
+	}
+	return nil
+}
+
+// destructure binds pat to the top like a let or const does,
+// a value of the wrong shape is an error
+func (c *Compiler) destructure(pat ast.Pattern, constant bool) error {
+	if ident, ok := pat.(*ast.Identifier); ok {
+		c.define(c.symbolTable.Define(ident.Value), constant)
+		return nil
+	}
+
+	c.emit(code.OpPatternBegin, code.None)
+	if err := c.compilePattern(pat, constant); err != nil {
+		return err
+	}
+	c.emit(code.OpPatternEnd)
+	return nil
+}
+
+// compilePattern matches the top against pat and pops it,
+// binding the names of pat on the way
+func (c *Compiler) compilePattern(pat ast.Pattern, constant bool) error {
+	switch pat := pat.(type) {
+	case *ast.WildcardPattern:
+		c.emit(code.OpPop)
+	case *ast.Identifier:
+		c.define(c.symbolTable.Define(pat.Value), constant)
+	case *ast.LiteralPattern:
+		if err := c.Compile(pat.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpMatchLiteral)
+	case *ast.DefaultPattern:
+		return c.compilePattern(pat.Target, constant)
+	case *ast.ArrayPattern:
+		return c.compileArrayPattern(pat, constant)
+	case *ast.HashPattern:
+		return c.compileHashPattern(pat, constant)
+	}
+	return nil
+}
+
+func (c *Compiler) compileArrayPattern(pat *ast.ArrayPattern, constant bool) error {
+	rest := 0
+	if pat.Rest != nil {
+		rest = 1
+	}
+	c.emit(code.OpMatchArray, len(pat.Elements), rest)
+
+	for i, el := range pat.Elements {
+		given := c.emit(code.OpArrayElem, i, code.None)
+		if dp, ok := el.(*ast.DefaultPattern); ok {
+			if err := c.Compile(dp.Default); err != nil {
+				return err
+			}
+		} else {
+			c.emit(code.OpMissingElem, requiredCount(pat.Elements), rest)
+		}
+		c.patch(given, 1, c.here())
+
+		if err := c.compilePattern(el, constant); err != nil {
+			return err
+		}
+	}
+
+	if pat.Rest != nil {
+		c.emit(code.OpArrayRest, len(pat.Elements))
+		c.define(c.symbolTable.Define(pat.Rest.Value), constant)
+	}
+	c.emit(code.OpPop)
+	return nil
+}
+
+func (c *Compiler) compileHashPattern(pat *ast.HashPattern, constant bool) error {
+	c.emit(code.OpMatchHash)
+
+	for _, pair := range pat.Pairs {
+		if err := c.Compile(pair.Key); err != nil {
+			return err
+		}
+		given := c.emit(code.OpHashElem, code.None)
+		if dp, ok := pair.Value.(*ast.DefaultPattern); ok {
+			if err := c.Compile(dp.Default); err != nil {
+				return err
+			}
+		} else {
+			key := c.addConstant(&object.String{Value: pair.Key.String()})
+			c.emit(code.OpMissingKey, key)
+		}
+		c.patch(given, 0, c.here())
+
+		if err := c.compilePattern(pair.Value, constant); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpPop)
+	return nil
+}
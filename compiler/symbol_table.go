@@ -0,0 +1,132 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable resolves the names of one function, Outer is the table
+// of the enclosing function and nil for the top level of the program.
+// Within a function the scopes of for-in bodies, catch clauses and
+// match arms nest as blocks, their locals get slots of their own.
+// The outermost block of the top level holds the globals
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	blocks      []map[string]Symbol // innermost last
+	free        map[string]Symbol
+	FreeSymbols []Symbol // the symbols of Outer this function captures
+
+	numLocals  int
+	localNames []string
+
+	globalNames []string // kept by the top level table only
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		blocks: []map[string]Symbol{{}},
+		free:   map[string]Symbol{},
+	}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// PushBlock opens a nested scope, PopBlock closes it again
+func (s *SymbolTable) PushBlock() { s.blocks = append(s.blocks, map[string]Symbol{}) }
+func (s *SymbolTable) PopBlock()  { s.blocks = s.blocks[:len(s.blocks)-1] }
+
+// Define binds name in the innermost scope, defining a name again
+// gives the symbol it already has there
+func (s *SymbolTable) Define(name string) Symbol {
+	block := s.blocks[len(s.blocks)-1]
+	if sym, ok := block[name]; ok {
+		return sym
+	}
+
+	var sym Symbol
+	if s.Outer == nil && len(s.blocks) == 1 {
+		sym = s.global(name)
+	} else {
+		sym = Symbol{Name: name, Scope: LocalScope, Index: s.numLocals}
+		s.numLocals++
+		s.localNames = append(s.localNames, name)
+	}
+	block[name] = sym
+	return sym
+}
+
+// Resolve finds the innermost binding of name, a local of an
+// enclosing function comes back as a free variable of this one
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	for i := len(s.blocks) - 1; i >= 0; i-- {
+		if sym, ok := s.blocks[i][name]; ok {
+			return sym, true
+		}
+	}
+	if sym, ok := s.free[name]; ok {
+		return sym, true
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	sym, ok := s.Outer.Resolve(name)
+	if !ok || sym.Scope == GlobalScope {
+		return sym, ok
+	}
+	return s.defineFree(sym), true
+}
+
+// ResolveGlobal resolves name like Resolve, a name bound nowhere is
+// taken for a global that the program may still define
+func (s *SymbolTable) ResolveGlobal(name string) Symbol {
+	if sym, ok := s.Resolve(name); ok {
+		return sym
+	}
+	root := s
+	for root.Outer != nil {
+		root = root.Outer
+	}
+	return root.global(name)
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	sym := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.free[original.Name] = sym
+	return sym
+}
+
+func (s *SymbolTable) global(name string) Symbol {
+	if sym, ok := s.blocks[0][name]; ok {
+		return sym
+	}
+	sym := Symbol{Name: name, Scope: GlobalScope, Index: len(s.globalNames)}
+	s.globalNames = append(s.globalNames, name)
+	s.blocks[0][name] = sym
+	return sym
+}
+
+func (s *SymbolTable) NumLocals() int { return s.numLocals }
+
+func (s *SymbolTable) freeNames() []string {
+	names := make([]string, len(s.FreeSymbols))
+	for i, sym := range s.FreeSymbols {
+		names[i] = sym.Name
+	}
+	return names
+}
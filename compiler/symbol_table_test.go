@@ -0,0 +1,75 @@
+package compiler
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	first := NewEnclosedSymbolTable(global)
+	first.Define("b")
+	first.PushBlock()
+	first.Define("c")
+
+	second := NewEnclosedSymbolTable(first)
+	second.Define("d")
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{first, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{first, "b", Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{first, "c", Symbol{Name: "c", Scope: LocalScope, Index: 1}},
+		{second, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{second, "c", Symbol{Name: "c", Scope: FreeScope, Index: 0}},
+		{second, "b", Symbol{Name: "b", Scope: FreeScope, Index: 1}},
+		{second, "d", Symbol{Name: "d", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		sym, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if sym != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, sym)
+		}
+	}
+
+	expectedFree := []Symbol{
+		{Name: "c", Scope: LocalScope, Index: 1},
+		{Name: "b", Scope: LocalScope, Index: 0},
+	}
+	if len(second.FreeSymbols) != len(expectedFree) {
+		t.Fatalf("wrong number of free symbols. got=%d", len(second.FreeSymbols))
+	}
+	for i, sym := range expectedFree {
+		if second.FreeSymbols[i] != sym {
+			t.Errorf("wrong free symbol. expected=%+v, got=%+v", sym, second.FreeSymbols[i])
+		}
+	}
+
+	first.PopBlock()
+	if _, ok := first.Resolve("c"); ok {
+		t.Errorf("name c resolvable after its block")
+	}
+	if first.Define("e").Index != 2 {
+		t.Errorf("block locals reuse slots")
+	}
+}
+
+func TestResolveGlobal(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+
+	later := local.ResolveGlobal("later")
+	if later != (Symbol{Name: "later", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("unbound name resolved to %+v", later)
+	}
+	if sym := global.Define("later"); sym != later {
+		t.Errorf("defining the global gave %+v, want=%+v", sym, later)
+	}
+}
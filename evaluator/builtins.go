@@ -2,6 +2,7 @@ package evaluator
 
 import (
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/clg0803/circus/object"
 )
@@ -15,9 +16,11 @@ var builtins = map[string]*object.Builtin{
 			}
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			case *object.Array:
 				return &object.Integer{Value: int64(len(arg.Elements))}
+			case *object.Range:
+				return &object.Integer{Value: arg.Len()}
 			default:
 				return newError("arg to `len` not supported so far, got %s",
 					args[0].Type())
@@ -99,6 +102,58 @@ var builtins = map[string]*object.Builtin{
 			return &object.Array{Elements: ne}
 		},
 	},
+	"delete": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of args, got %d, want = 2",
+					len(args))
+			}
+			if args[0].Type() != object.HASH_OBJ {
+				return newError("arguments to `delete` must be HASH, got %s",
+					args[0].Type())
+			}
+			hash := args[0].(*object.Hash)
+			key, ok := args[1].(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			p, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				return NULL
+			}
+			delete(hash.Pairs, key.HashKey())
+			return p.Value
+		},
+	},
+	"range": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of args, got %d, want 1 to 3",
+					len(args))
+			}
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("arguments to `range` must be INTEGER, got %s",
+						arg.Type())
+				}
+				bounds[i] = n.Value
+			}
+
+			r := &object.Range{End: bounds[0], Step: 1}
+			if len(bounds) > 1 {
+				r.Start, r.End = bounds[0], bounds[1]
+			}
+			if len(bounds) > 2 {
+				r.Step = bounds[2]
+			}
+			if r.Step == 0 {
+				return newError("range step must not be zero")
+			}
+			return r
+		},
+	},
 	"puts": {
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
@@ -1,13 +1,49 @@
 package evaluator
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"math/big"
+	"runtime/debug"
+	"strings"
 
 	"github.com/clg0803/circus/ast"
 	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/token"
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// Eval evaluates node in env. A whole *ast.Program never brings the
+// process down: an unexpected Go panic comes back as an internal error
+func Eval(node ast.Node, env *object.Environment) (obj object.Object) {
+	if _, ok := node.(*ast.Program); ok {
+		defer func() {
+			if r := recover(); r != nil {
+				obj = &object.Error{
+					Message: fmt.Sprintf("internal error: %v", r),
+					GoStack: string(debug.Stack()),
+				}
+			}
+		}()
+	}
+
+	obj = eval(node, env)
+	// the innermost node that produced the error claims it
+	if node != nil {
+		obj = claimError(obj, node.Pos())
+	}
+	return obj
+}
+
+// claimError gives obj the position pos if it is an error without one
+func claimError(obj object.Object, pos token.Position) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	return obj
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -15,23 +51,38 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntegerLiteral:
+		return &object.BigInteger{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObjects(node.Value)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isControl(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isControl(left) {
+			return left
+		}
+		// short-circuit, the deciding operand is the result
+		switch {
+		case node.Operator == "&&" && !isTruthy(left):
+			return left
+		case node.Operator == "||" && isTruthy(left):
 			return left
+		case node.Operator == "&&" || node.Operator == "||":
+			return Eval(node.Right, env)
 		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isControl(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
@@ -41,46 +92,74 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalBlockStatement(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isControl(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isControl(val) {
+			return val
+		}
+		return &object.Error{Message: thrownMessage(val), Thrown: val}
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isControl(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		constant := node.Token.Type == token.CONST
+		if node.Pattern != nil {
+			if err := destructure(node.Pattern, val, env, constant); err != nil {
+				return err
+			}
+		} else if err := bindName(node.Name, val, env, constant); err != nil {
+			return err
+		}
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body}
+		return &object.Function{Parameters: params, Rest: node.Rest, Body: body,
+			Env: env, Name: node.Name}
 	case *ast.CallExpression:
 		f := Eval(node.Function, env)
-		if isError(f) {
+		if isControl(f) {
 			return f
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isControl(args[0]) {
 			return args[0]
 		}
-		return applyFunction(f, args)
+		return applyFunction(f, args, node.Pos(), env.Calls())
 	case *ast.ArrayLiteral:
 		ele := evalExpressions(node.Elements, env)
-		if len(ele) == 1 && isError(ele[0]) {
+		if len(ele) == 1 && isControl(ele[0]) {
 			return ele[0]
 		}
 		return &object.Array{Elements: ele}
 	case *ast.IndexExpression:
 		l := Eval(node.Left, env)
-		if isError(l) {
+		if isControl(l) {
 			return l
 		}
 		i := Eval(node.Index, env)
-		if isError(i) {
+		if isControl(i) {
 			return i
 		}
 		return evalIndexExpression(l, i)
@@ -92,13 +171,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 func isError(obj object.Object) bool { return obj != nil && obj.Type() == object.ERROR_OBJ }
 
+// applyFunction calls fn, callPos is recorded in the stack of
+// any error coming out of the function body. calls is the number of
+// calls the caller is nested in
 func applyFunction(fn object.Object,
-	args []object.Object) object.Object {
+	args []object.Object, callPos token.Position, calls int) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		eEnv := extendFunctionEnv(fn, args)
-		eva := Eval(fn.Body, eEnv)
-		return unwrapReturnValue(eva)
+		return callFunction(fn, args, callPos, calls)
 	case *object.Builtin:
 		return fn.Fn(args...)
 	default:
@@ -106,13 +186,61 @@ func applyFunction(fn object.Object,
 	}
 }
 
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
+// checkArity wants an argument for every parameter up to the last one
+// without a default, and no extra arguments unless there is a rest one
+func checkArity(fn *object.Function, n int) *object.Error {
+	min, max := requiredCount(fn.Parameters), len(fn.Parameters)
+	switch {
+	case fn.Rest != nil && n < min:
+		return newError("wrong number of arguments: want at least %d, got %d", min, n)
+	case fn.Rest != nil:
+		return nil
+	case (n < min || n > max) && min == max:
+		return newError("wrong number of arguments: want %d, got %d", min, n)
+	case n < min || n > max:
+		return newError("wrong number of arguments: want %d to %d, got %d", min, max, n)
+	}
+	return nil
+}
+
+// extendFunctionEnv binds the arguments, a missing one takes its
+// default, evaluated in the new scope so it can use earlier parameters
 func extendFunctionEnv(fn *object.Function,
-	args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvirnment(fn.Env)
+	args []object.Object, calls int) (*object.Environment, object.Object) {
+	env := object.NewCallEnvirnment(fn.Env, calls)
 	for i, p := range fn.Parameters {
-		env.Set(p.Value, args[i])
+		var arg object.Object
+		if i < len(args) {
+			arg = args[i]
+		} else if arg = Eval(p.(*ast.DefaultPattern).Default, env); isError(arg) {
+			return nil, arg
+		}
+
+		if ident, ok := p.(*ast.Identifier); ok {
+			set(env, ident, arg)
+			continue
+		}
+		if err := destructure(p, arg, env, false); err != nil {
+			return nil, err
+		}
 	}
-	return env
+
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = make([]object.Object, len(args)-len(fn.Parameters))
+			copy(rest, args[len(fn.Parameters):])
+		}
+		set(env, fn.Rest, &object.Array{Elements: rest})
+	}
+	return env, nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -127,8 +255,17 @@ func evalExpressions(args []ast.Expression,
 	var ans []object.Object
 
 	for _, e := range args {
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			elements, err := evalSpread(spread, env)
+			if err != nil {
+				return []object.Object{err}
+			}
+			ans = append(ans, elements...)
+			continue
+		}
+
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isControl(evaluated) {
 			return []object.Object{evaluated}
 		}
 		ans = append(ans, evaluated)
@@ -137,11 +274,43 @@ func evalExpressions(args []ast.Expression,
 	return ans
 }
 
+// evalSpread gives the elements of an array, string or range
+func evalSpread(se *ast.SpreadExpression, env *object.Environment) ([]object.Object, object.Object) {
+	val := Eval(se.Value, env)
+	if isControl(val) {
+		return nil, val
+	}
+	if arr, ok := val.(*object.Array); ok {
+		return arr.Elements, nil
+	}
+
+	if val.Type() != object.HASH_OBJ {
+		var elements []object.Object
+		err := iterate(val, func(_, v object.Object) bool {
+			elements = append(elements, v)
+			return true
+		})
+		if err == nil {
+			return elements, nil
+		}
+	}
+
+	err := newError("cannot spread %s", val.Type())
+	err.Pos = se.Pos()
+	return nil, err
+}
+
 func evalIndexExpression(l, index object.Object) object.Object {
 	switch {
 	case l.Type() == object.ARRAY_OBJ &&
 		index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(l, index)
+	case l.Type() == object.ARRAY_OBJ &&
+		index.Type() == object.BIG_INTEGER_OBJ:
+		return NULL // always out of range
+	case l.Type() == object.STRING_OBJ &&
+		index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(l, index)
 	case l.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(l, index)
 	default:
@@ -160,6 +329,23 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return ao.Elements[idx]
 }
 
+// evalStringIndexExpression indexes runes, not bytes
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	s := str.(*object.String).Value
+	idx := index.(*object.Integer).Value
+
+	if idx < 0 {
+		return NULL
+	}
+	for _, r := range s {
+		if idx == 0 {
+			return &object.String{Value: string(r)}
+		}
+		idx--
+	}
+	return NULL
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	ho := hash.(*object.Hash)
 	k, ok := index.(object.Hashable)
@@ -175,17 +361,17 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	p := make(map[object.HashKey]object.HashPair)
-	for k, v := range node.Pairs {
+	for _, k := range ast.SortedKeys(node) {
 		key := Eval(k, env)
-		if isError(key) {
+		if isControl(key) {
 			return key
 		}
 		hk, ok := key.(object.Hashable)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(v, env)
-		if isError(value) {
+		value := Eval(node.Pairs[k], env)
+		if isControl(value) {
 			return value
 		}
 		hashed := hk.HashKey()
@@ -194,9 +380,29 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 	return &object.Hash{Pairs: p}
 }
 
+// evalInterpolatedString stringifies every part with Inspect
+func evalInterpolatedString(node *ast.InterpolatedString,
+	env *object.Environment) object.Object {
+	var out bytes.Buffer
+	for _, part := range node.Parts {
+		v := Eval(part, env)
+		if isControl(v) {
+			return v
+		}
+		out.WriteString(v.Inspect())
+	}
+	return &object.String{Value: out.String()}
+}
+
 func evalIdentifier(node *ast.Identifier,
 	env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+	if node.Slot != nil {
+		for s := node.Slot; s != nil; s = s.Outer {
+			if val, ok := env.GetSlot(s.Depth, s.Index); ok {
+				return val
+			}
+		}
+	} else if val, ok := env.Get(node.Value); ok {
 		return val
 	}
 
@@ -227,6 +433,9 @@ var (
 	FALSE = &object.Boolean{Value: false}
 
 	NULL = &object.Null{}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func nativeBoolToBooleanObjects(in bool) *object.Boolean {
@@ -250,9 +459,11 @@ func evalPrefixExpression(op string, right object.Object) object.Object {
 func evalInfixExpression(op string,
 	left object.Object, right object.Object) object.Object {
 	switch {
-	case left.Type() == object.INTEGER_OBJ &&
-		right.Type() == object.INTEGER_OBJ:
+	case isInteger(left) && isInteger(right):
 		return evalIntegerInfixExpression(op, left, right)
+	case isNumber(left) && isNumber(right):
+		// at least one side is a FLOAT, the other one is promoted
+		return evalFloatInfixExpression(op, left, right)
 	case left.Type() == object.STRING_OBJ &&
 		right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(op, left, right)
@@ -270,21 +481,248 @@ func evalInfixExpression(op string,
 
 func evalIntegerInfixExpression(op string,
 	left object.Object, right object.Object) object.Object {
-	lv := left.(*object.Integer).Value
-	rv := right.(*object.Integer).Value
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		if res, ok := evalSmallIntegerInfixExpression(op, l.Value, r.Value); ok {
+			return res
+		}
+	}
+	// overflowed or BIG_INTEGER involved
+	return evalBigIntegerInfixExpression(op, left, right)
+}
+
+// evalSmallIntegerInfixExpression does int64 math,
+// it reports false when the result would overflow
+func evalSmallIntegerInfixExpression(op string,
+	lv int64, rv int64) (object.Object, bool) {
 	switch op {
 	case "+":
-		return &object.Integer{Value: lv + rv}
+		v := lv + rv
+		if (lv^v)&(rv^v) < 0 {
+			return nil, false
+		}
+		return &object.Integer{Value: v}, true
 	case "-":
-		return &object.Integer{Value: lv - rv}
+		v := lv - rv
+		if (lv^rv)&(lv^v) < 0 {
+			return nil, false
+		}
+		return &object.Integer{Value: v}, true
 	case "*":
-		return &object.Integer{Value: lv * rv}
+		v := lv * rv
+		if lv != 0 && (v/lv != rv || lv == -1 && rv == math.MinInt64) {
+			return nil, false
+		}
+		return &object.Integer{Value: v}, true
 	case "/":
-		return &object.Integer{Value: lv / rv}
+		if rv == 0 {
+			return newError("division by zero"), true
+		}
+		if lv == math.MinInt64 && rv == -1 {
+			return nil, false
+		}
+		return &object.Integer{Value: lv / rv}, true
+	case "%":
+		if rv == 0 {
+			return newError("division by zero"), true
+		}
+		return &object.Integer{Value: lv % rv}, true
+	case "&":
+		return &object.Integer{Value: lv & rv}, true
+	case "|":
+		return &object.Integer{Value: lv | rv}, true
+	case "^":
+		return &object.Integer{Value: lv ^ rv}, true
+	case "<<":
+		if rv < 0 {
+			return newError("negative shift count: %d", rv), true
+		}
+		if rv >= 63 || (lv<<rv)>>rv != lv {
+			return nil, false
+		}
+		return &object.Integer{Value: lv << rv}, true
+	case ">>":
+		if rv < 0 {
+			return newError("negative shift count: %d", rv), true
+		}
+		return &object.Integer{Value: lv >> uint64(rv)}, true
+	case "**":
+		return nil, false // exponentiation overflows too easily, done on big.Int
+	case "<":
+		return nativeBoolToBooleanObjects(lv < rv), true
+	case ">":
+		return nativeBoolToBooleanObjects(lv > rv), true
+	case "<=":
+		return nativeBoolToBooleanObjects(lv <= rv), true
+	case ">=":
+		return nativeBoolToBooleanObjects(lv >= rv), true
+	case "==":
+		return nativeBoolToBooleanObjects(lv == rv), true
+	case "!=":
+		return nativeBoolToBooleanObjects(lv != rv), true
+	default:
+		return newError("unknown operator: %s %s %s",
+			object.INTEGER_OBJ, op, object.INTEGER_OBJ), true
+	}
+}
+
+func evalBigIntegerInfixExpression(op string,
+	left object.Object, right object.Object) object.Object {
+	lv := toBig(left)
+	rv := toBig(right)
+	switch op {
+	case "+":
+		return normalizeInteger(new(big.Int).Add(lv, rv))
+	case "-":
+		return normalizeInteger(new(big.Int).Sub(lv, rv))
+	case "*":
+		return normalizeInteger(new(big.Int).Mul(lv, rv))
+	case "/", "%":
+		if rv.Sign() == 0 {
+			return newError("division by zero")
+		}
+		if op == "/" {
+			return normalizeInteger(new(big.Int).Quo(lv, rv))
+		}
+		return normalizeInteger(new(big.Int).Rem(lv, rv))
+	case "&":
+		return normalizeInteger(new(big.Int).And(lv, rv))
+	case "|":
+		return normalizeInteger(new(big.Int).Or(lv, rv))
+	case "^":
+		return normalizeInteger(new(big.Int).Xor(lv, rv))
+	case "<<", ">>":
+		if rv.Sign() < 0 {
+			return newError("negative shift count: %s", rv)
+		}
+		if !rv.IsInt64() || rv.Int64() > math.MaxInt32 {
+			return newError("shift count too large: %s", rv)
+		}
+		if op == "<<" {
+			return normalizeInteger(new(big.Int).Lsh(lv, uint(rv.Int64())))
+		}
+		return normalizeInteger(new(big.Int).Rsh(lv, uint(rv.Int64())))
+	case "**":
+		return evalPower(lv, rv)
+	case "<":
+		return nativeBoolToBooleanObjects(lv.Cmp(rv) < 0)
+	case ">":
+		return nativeBoolToBooleanObjects(lv.Cmp(rv) > 0)
+	case "<=":
+		return nativeBoolToBooleanObjects(lv.Cmp(rv) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObjects(lv.Cmp(rv) >= 0)
+	case "==":
+		return nativeBoolToBooleanObjects(lv.Cmp(rv) == 0)
+	case "!=":
+		return nativeBoolToBooleanObjects(lv.Cmp(rv) != 0)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), op, right.Type())
+	}
+}
+
+// maxPowerBits bounds the size of the result of **,
+// a larger one fails instead of running out of memory
+const maxPowerBits = 1 << 24
+
+// evalPower gives lv ** rv, a negative exponent gives a float
+func evalPower(lv, rv *big.Int) object.Object {
+	if rv.Sign() < 0 {
+		if lv.Sign() == 0 {
+			return newError("division by zero")
+		}
+		l, _ := new(big.Float).SetInt(lv).Float64()
+		r, _ := new(big.Float).SetInt(rv).Float64()
+		return &object.Float{Value: math.Pow(l, r)}
+	}
+
+	// the result has at least (bits of |lv| - 1) * rv + 1 bits,
+	// 0, 1 and -1 stay that small whatever the exponent
+	if bits := new(big.Int).Abs(lv).BitLen() - 1; bits > 0 {
+		if !rv.IsInt64() || rv.Int64() > maxPowerBits/int64(bits) {
+			return newError("exponent too large: %s", rv)
+		}
+	}
+	return normalizeInteger(new(big.Int).Exp(lv, rv, nil))
+}
+
+func isInteger(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.INTEGER_OBJ || t == object.BIG_INTEGER_OBJ
+}
+
+// toBig converts an integer to *big.Int, callers check isInteger first
+func toBig(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInteger:
+		return obj.Value
+	}
+	return new(big.Int)
+}
+
+// normalizeInteger demotes v to an Integer when it fits in int64
+func normalizeInteger(v *big.Int) object.Object {
+	if v.IsInt64() {
+		return &object.Integer{Value: v.Int64()}
+	}
+	return &object.BigInteger{Value: v}
+}
+
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat converts a number to float64, callers check isNumber first
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInteger:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *object.Float:
+		return obj.Value
+	}
+	return 0
+}
+
+func evalFloatInfixExpression(op string,
+	left object.Object, right object.Object) object.Object {
+	lv := toFloat(left)
+	rv := toFloat(right)
+	switch op {
+	case "+":
+		return &object.Float{Value: lv + rv}
+	case "-":
+		return &object.Float{Value: lv - rv}
+	case "*":
+		return &object.Float{Value: lv * rv}
+	case "/", "%":
+		// no silent Inf or NaN, floats fail like integers do
+		if rv == 0 {
+			return newError("division by zero")
+		}
+		if op == "/" {
+			return &object.Float{Value: lv / rv}
+		}
+		return &object.Float{Value: math.Mod(lv, rv)}
+	case "**":
+		if lv == 0 && rv < 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Pow(lv, rv)}
 	case "<":
 		return nativeBoolToBooleanObjects(lv < rv)
 	case ">":
 		return nativeBoolToBooleanObjects(lv > rv)
+	case "<=":
+		return nativeBoolToBooleanObjects(lv <= rv)
+	case ">=":
+		return nativeBoolToBooleanObjects(lv >= rv)
 	case "==":
 		return nativeBoolToBooleanObjects(lv == rv)
 	case "!=":
@@ -295,15 +733,31 @@ func evalIntegerInfixExpression(op string,
 	}
 }
 
+// evalStringInfixExpression compares strings lexicographically by bytes,
+// which for UTF-8 is the order of code points
 func evalStringInfixExpression(op string,
 	left object.Object, right object.Object) object.Object {
-	if op != "+" {
+	lv := left.(*object.String).Value
+	rv := right.(*object.String).Value
+	switch op {
+	case "+":
+		return &object.String{Value: lv + rv}
+	case "<":
+		return nativeBoolToBooleanObjects(lv < rv)
+	case ">":
+		return nativeBoolToBooleanObjects(lv > rv)
+	case "<=":
+		return nativeBoolToBooleanObjects(lv <= rv)
+	case ">=":
+		return nativeBoolToBooleanObjects(lv >= rv)
+	case "==":
+		return nativeBoolToBooleanObjects(lv == rv)
+	case "!=":
+		return nativeBoolToBooleanObjects(lv != rv)
+	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), op, right.Type())
 	}
-	lv := left.(*object.String).Value
-	rv := right.(*object.String).Value
-	return &object.String{Value: lv + rv}
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -321,16 +775,24 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			return normalizeInteger(new(big.Int).Neg(toBig(right)))
+		}
+		return &object.Integer{Value: -right.Value}
+	case *object.BigInteger:
+		return normalizeInteger(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	v := right.(*object.Integer).Value
-	return &object.Integer{Value: -v}
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	con := Eval(ie.Condition, env)
-	if isError(con) {
+	if isControl(con) {
 		return con
 	}
 	if isTruthy(con) {
@@ -342,6 +804,159 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+// evalTryExpression runs the catch clause on an error from the block,
+// the finally clause always runs and a return, throw, break or continue
+// in it wins over whatever the block or catch clause produced
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	res := Eval(te.Block, env)
+
+	if err, ok := res.(*object.Error); ok && te.Catch != nil {
+		catchEnv := object.NewEnclosedEnvirnment(env)
+		set(catchEnv, te.CatchParam, err.Caught())
+		res = Eval(te.Catch, catchEnv)
+	}
+
+	if te.Finally != nil {
+		if f := Eval(te.Finally, env); isControl(f) {
+			return f
+		}
+	}
+
+	return res
+}
+
+// thrownMessage describes a thrown value for tracebacks, a rethrown
+// runtime error keeps its message
+func thrownMessage(val object.Object) string {
+	switch val := val.(type) {
+	case *object.String:
+		return val.Value
+	case *object.Hash:
+		msg, ok := val.Pairs[(&object.String{Value: "message"}).HashKey()]
+		if s, isStr := msg.Value.(*object.String); ok && isStr {
+			return s.Value
+		}
+	}
+	return val.Inspect()
+}
+
+func evalAssignExpression(ae *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := ae.Target.(type) {
+	case *ast.Identifier:
+		return evalIdentifierAssignment(ae, target, env)
+	case *ast.IndexExpression:
+		return evalIndexAssignment(ae, target, env)
+	default:
+		return newError("cannot assign to %s", ae.Target.String())
+	}
+}
+
+// evalIdentifierAssignment updates the innermost existing binding,
+// assignment never creates a variable
+func evalIdentifierAssignment(ae *ast.AssignExpression, target *ast.Identifier,
+	env *object.Environment) object.Object {
+	name := target.Value
+	var owner *object.Environment
+	var cur object.Object
+	var constant bool
+	var slot *ast.Slot // the first of target.Slot and its outer ones that is bound
+	if target.Slot != nil {
+		for slot = target.Slot; slot != nil; slot = slot.Outer {
+			if cur, _ = env.GetSlot(slot.Depth, slot.Index); cur != nil {
+				owner = env.Scope(slot.Depth)
+				constant = owner.IsConstSlot(slot.Index)
+				break
+			}
+		}
+	} else if owner = env.Resolve(name); owner != nil {
+		cur, _ = owner.Get(name)
+		constant = owner.IsConst(name)
+	}
+
+	if owner == nil {
+		if _, ok := builtins[name]; ok {
+			return newError("cannot assign to builtin: %s", name)
+		}
+		return newError("identifier not found: " + name)
+	}
+	if constant {
+		return newError("cannot assign to constant: %s", name)
+	}
+
+	val := evalAssignedValue(ae, cur, env)
+	if isControl(val) {
+		return val
+	}
+
+	if slot != nil {
+		return owner.SetSlot(slot.Index, val)
+	}
+	return owner.Set(name, val)
+}
+
+// evalIndexAssignment stores into the array or hash in place
+func evalIndexAssignment(ae *ast.AssignExpression, ie *ast.IndexExpression,
+	env *object.Environment) object.Object {
+	l := Eval(ie.Left, env)
+	if isControl(l) {
+		return l
+	}
+	i := Eval(ie.Index, env)
+	if isControl(i) {
+		return i
+	}
+
+	switch l := l.(type) {
+	case *object.Array:
+		idx, ok := i.(*object.Integer)
+		if !ok {
+			if i.Type() == object.BIG_INTEGER_OBJ {
+				return newError("index out of range: %s (length %d)",
+					i.Inspect(), len(l.Elements))
+			}
+			return newError("array index must be INTEGER, got %s", i.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(l.Elements)) {
+			return newError("index out of range: %d (length %d)",
+				idx.Value, len(l.Elements))
+		}
+		val := evalAssignedValue(ae, l.Elements[idx.Value], env)
+		if isControl(val) {
+			return val
+		}
+		l.Elements[idx.Value] = val
+		return val
+	case *object.Hash:
+		key, ok := i.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", i.Type())
+		}
+		var cur object.Object = NULL
+		if p, ok := l.Pairs[key.HashKey()]; ok {
+			cur = p.Value
+		}
+		val := evalAssignedValue(ae, cur, env)
+		if isControl(val) {
+			return val
+		}
+		l.Pairs[key.HashKey()] = object.HashPair{Key: i, Value: val}
+		return val
+	default:
+		return newError("index assignment not supported: %s", l.Type())
+	}
+}
+
+// evalAssignedValue evaluates the right side of ae, a compound
+// assignment combines it with the current value cur
+func evalAssignedValue(ae *ast.AssignExpression, cur object.Object,
+	env *object.Environment) object.Object {
+	val := Eval(ae.Value, env)
+	if isControl(val) || ae.Operator == "=" {
+		return val
+	}
+	return evalInfixExpression(strings.TrimSuffix(ae.Operator, "="), cur, val)
+}
+
 func isTruthy(con object.Object) bool {
 	switch con {
 	case NULL:
@@ -360,16 +975,131 @@ func evalBlockStatement(b *ast.BlockStatement, env *object.Environment) object.O
 
 	for _, s := range b.Statements {
 		ans = Eval(s, env)
-		if ans != nil {
-			if t := ans.Type(); t == object.RETURN_VALUE_OBJ || t == object.ERROR_OBJ {
-				return ans
-			}
+		if isControl(ans) {
+			return ans
 		}
 	}
 
 	return ans
 }
 
+// isControl reports whether obj cuts a block short: a return,
+// an error, or a break or continue heading for its loop. Like an error
+// any of them leaves the expression it comes up in at once
+func isControl(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ,
+		object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		con := Eval(ws.Condition, env)
+		if isControl(con) {
+			return con
+		}
+		if !isTruthy(con) {
+			return NULL
+		}
+		if res, stop := loopControl(Eval(ws.Body, env)); stop {
+			return res
+		}
+	}
+}
+
+// evalForStatement runs the body once per element, each round gets
+// its own scope so closures made in the body keep that round's values
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isControl(iterable) {
+		return iterable
+	}
+
+	_, isHash := iterable.(*object.Hash)
+	var out object.Object = NULL
+	err := iterate(iterable, func(key, value object.Object) bool {
+		loopEnv := object.NewEnclosedEnvirnment(env)
+		switch {
+		case fs.Key != nil:
+			set(loopEnv, fs.Key, key)
+			set(loopEnv, fs.Value, value)
+		case isHash:
+			set(loopEnv, fs.Value, key)
+		default:
+			set(loopEnv, fs.Value, value)
+		}
+
+		res, stop := loopControl(Eval(fs.Body, loopEnv))
+		if stop {
+			out = res
+		}
+		return !stop
+	})
+	if err != nil {
+		return err
+	}
+	return out
+}
+
+// loopControl tells a loop what to do with the result of its body,
+// stop reports the loop is over and res is then what it evaluates to
+func loopControl(body object.Object) (res object.Object, stop bool) {
+	switch {
+	case body == BREAK:
+		return NULL, true
+	case body == CONTINUE || !isControl(body):
+		return nil, false
+	default: // return or error
+		return body, true
+	}
+}
+
+// iterate calls yield with index and element, or key and value for
+// a hash, until it runs out or yield returns false
+func iterate(obj object.Object, yield func(key, value object.Object) bool) *object.Error {
+	switch obj := obj.(type) {
+	case *object.Array:
+		// the body may assign to elements but never changes the length
+		for i := 0; i < len(obj.Elements); i++ {
+			if !yield(&object.Integer{Value: int64(i)}, obj.Elements[i]) {
+				return nil
+			}
+		}
+	case *object.String:
+		i := 0
+		for _, r := range obj.Value {
+			if !yield(&object.Integer{Value: int64(i)}, &object.String{Value: string(r)}) {
+				return nil
+			}
+			i++
+		}
+	case *object.Hash:
+		// iterate over a snapshot so the body may add and delete keys
+		for _, p := range obj.SortedPairs() {
+			if !yield(p.Key, p.Value) {
+				return nil
+			}
+		}
+	case *object.Range:
+		n := obj.Len()
+		for i := int64(0); i < n; i++ {
+			v := &object.Integer{Value: obj.Start + i*obj.Step}
+			if !yield(&object.Integer{Value: i}, v) {
+				return nil
+			}
+		}
+	default:
+		return newError("not iterable: %s", obj.Type())
+	}
+	return nil
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -3,8 +3,11 @@
 package evaluator
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/clg0803/circus/ast"
 	"github.com/clg0803/circus/lexer"
 	"github.com/clg0803/circus/object"
 	"github.com/clg0803/circus/parser"
@@ -498,3 +501,1191 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+let newAdder = fn(x) {
+  fn(y) { x + y };
+};
+let addTwo = newAdder(2);
+addTwo(2);`, 4},
+		{`
+let newCounter = fn() {
+  let count = 0;
+  fn() { count + 1 };
+};
+let counter = newCounter();
+counter() + counter();`, 2},
+		{`
+let add = fn(a) { fn(b) { fn(c) { a + b + c } } };
+add(1)(2)(3);`, 6},
+		{`
+let outer = fn(a) {
+  let middle = fn(b) {
+    let inner = fn(c) { a * 100 + b * 10 + c };
+    inner;
+  };
+  middle;
+};
+outer(1)(2)(3);`, 123},
+		{`
+let map = fn(arr, f) {
+  let iter = fn(arr, acc) {
+    if (len(arr) == 0) { acc } else { iter(rest(arr), push(acc, f(first(arr)))) }
+  };
+  iter(arr, []);
+};
+let doubled = map([1, 2, 3], fn(x) { x * 2 });
+doubled[0] + doubled[1] + doubled[2];`, 12},
+		{`
+let reduce = fn(arr, init, f) {
+  if (len(arr) == 0) { init } else { reduce(rest(arr), f(init, first(arr)), f) }
+};
+reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x });`, 10},
+		{`
+let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };
+fact(5);`, 120},
+		{`
+let Y = fn(f) {
+  fn(x) { x(x) }(fn(x) { f(fn(v) { x(x)(v) }) })
+};
+let fact = Y(fn(self) { fn(n) { if (n < 2) { 1 } else { n * self(n - 1) } } });
+fact(6);`, 720},
+		{`
+let compose = fn(f, g) { fn(x) { g(f(x)) } };
+let inc = fn(x) { x + 1 };
+let dbl = fn(x) { x * 2 };
+compose(inc, dbl)(5);`, 12},
+		{`
+let x = 10;
+let shadow = fn(x) { fn() { x } };
+shadow(1)() + x;`, 11},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\nlet b = a + true;", "script.mk:2:9"},
+		{"let f = fn() {\n  missing\n};\nf();", "script.mk:2:3"},
+		{"len(1, 2)", "script.mk:1:1"},
+		{"-true", "script.mk:1:1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile("script.mk", tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		evaluated := Eval(program, object.NewEnvirnment())
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.String() != tt.expected {
+			t.Errorf("wrong error position. expected=%q, got=%q",
+				tt.expected, errObj.Pos.String())
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+  x / 0
+};
+let outer = fn(x) {
+  inner(x) + 1
+};
+let wrap = fn() { fn() { outer(1) }() };
+wrap();`
+
+	l := lexer.NewFile("script.mk", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	evaluated := Eval(program, object.NewEnvirnment())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := []struct {
+		function string
+		callPos  string
+	}{
+		{"inner", "script.mk:5:3"},
+		{"outer", "script.mk:7:26"},
+		{"<anonymous>", "script.mk:7:19"},
+		{"wrap", "script.mk:8:1"},
+	}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack depth. expected=%d, got=%d (%+v)",
+			len(expected), len(errObj.Stack), errObj.Stack)
+	}
+	for i, tt := range expected {
+		frame := errObj.Stack[i]
+		if frame.Function != tt.function || frame.CallPos.String() != tt.callPos {
+			t.Errorf("stack[%d] wrong. expected=%s at %s, got=%s at %s",
+				i, tt.function, tt.callPos, frame.Function, frame.CallPos)
+		}
+	}
+
+	traceback := `Traceback (most recent call last):
+  script.mk:8:1, in <main>
+  script.mk:7:19, in wrap
+  script.mk:7:26, in <anonymous>
+  script.mk:5:3, in outer
+  script.mk:2:3, in inner
+ERROR: division by zero`
+	if errObj.Traceback() != traceback {
+		t.Errorf("wrong traceback. expected=\n%s\ngot=\n%s",
+			traceback, errObj.Traceback())
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{".5 + .25", 0.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 / 4.0", 2.5},
+		{"3 * 1.5 - 1", 3.5},
+		{"50 * 0.2", 10},
+		{"let avg = fn(a, b) { (a + b) / 2.0 }; avg(1, 2)", 1.5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+
+	comparisons := []struct {
+		input    string
+		expected bool
+	}{
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
+	}
+
+	for _, tt := range comparisons {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+
+	// integer division stays integral
+	testIntegerObject(t, testEval("7 / 2"), 3)
+	// whole floats find the integer key and vice versa
+	testIntegerObject(t, testEval(`{1: 10, 2.5: 20}[1.0]`), 10)
+	testIntegerObject(t, testEval(`{1.0: 10, 2.5: 20}[1]`), 10)
+	testIntegerObject(t, testEval(`{1: 10, 2.5: 20}[2.5]`), 20)
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func TestBigIntegerArithmetic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{"123456789012345678901234567890 * 10 + 5", "1234567890123456789012345678905"},
+		{"-123456789012345678901234567890 / 7", "-17636684144620811271604938270"},
+		{`
+let pow = fn(b, e) { if (e == 0) { 1 } else { b * pow(b, e - 1) } };
+pow(2, 100)`, "1267650600228229401496703205376"},
+		{`
+let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };
+fact(25)`, "15511210043330985984000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		result, ok := evaluated.(*object.BigInteger)
+		if !ok {
+			t.Errorf("%q: object is not BigInteger. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if result.Value.String() != tt.expected {
+			t.Errorf("%q: object has wrong value. got=%s, want=%s",
+				tt.input, result.Value, tt.expected)
+		}
+	}
+
+	// demoted back to Integer once the value fits again
+	demoted := []struct {
+		input    string
+		expected int64
+	}{
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+		{"123456789012345678901234567890 - 123456789012345678901234567880", 10},
+		{"18446744073709551616 / 4294967296", 4294967296},
+		{"-(9223372036854775807 + 1) + 1", -9223372036854775807},
+	}
+	for _, tt := range demoted {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	comparisons := []struct {
+		input    string
+		expected bool
+	}{
+		{"9223372036854775808 > 9223372036854775807", true},
+		{"9223372036854775807 + 1 == 9223372036854775808", true},
+		{"-9223372036854775809 < 0", true},
+		{"9223372036854775808 != 9223372036854775808", false},
+		{"9223372036854775808 == 9223372036854775808.0", true},
+	}
+	for _, tt := range comparisons {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+
+	testFloatObject(t, testEval("9223372036854775808 * 0.5"), 4611686018427387904)
+	testIntegerObject(t, testEval(`{9223372036854775808: 1}[9223372036854775807 + 1]`), 1)
+	testIntegerObject(t, testEval(`{9223372036854775808.0: 1}[9223372036854775808]`), 1)
+}
+
+func TestUnicodeStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("héllo")`, 5},
+		{`len("日本語")`, 3},
+		{`len("🐒\n")`, 2},
+		{`len("")`, 0},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`let s = "abc"; s[0] + s[2]`, "ac"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-1]`, nil},
+		{`"tab\there"`, "tab\there"},
+		{"`C:\\path`", `C:\path`},
+		{`let größe = 3; größe * 2`, 6},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. expected=%q, got=%q", expected, str.Value)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Ann"; "Hello ${name}"`, "Hello Ann"},
+		{`let items = [1, 2]; "you have ${len(items)} items"`, "you have 2 items"},
+		{`"${1} + ${2.5} = ${1 + 2.5}"`, "1 + 2.5 = 3.5"},
+		{`"${true} ${[1, "a"]} ${if (false) { 1 }}"`, "true [1, a] null"},
+		{`let f = fn(x) { "<${x}>" }; "${f(f("y"))}"`, "<<y>>"},
+		{`"${"a"}${"b"}"`, "ab"},
+		{`"cost: \${price}"`, "cost: ${price}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. expected=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+
+	evaluated := testEval(`"a ${missing} b"`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "identifier not found: missing" {
+		t.Errorf("expected identifier error. got=%T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"7 % 3", 7 % 3},
+		{"-7 % 3", -7 % 3},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"1 >> 70", 0},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"1 + 2 * 3 % 4", 3},
+		{"2 ** 100 % 1000", 376},
+		{"(2 ** 70) >> 68", 4},
+		{"(2 ** 64 + 5) & 7", 5},
+		{"(1 << 64) | 1", "18446744073709551617"},
+		{"1 << 63", "9223372036854775808"},
+		{"3 ** 40", "12157665459056928801"},
+		{"2 ** -1", 0.5},
+		{"1 ** (2 ** 70)", 1},
+		{"(-1) ** (2 ** 70 + 1)", -1},
+		{"0 ** 100000000000", 0},
+		{"(-2) ** -1", -0.5},
+		{"7.5 % 2", 1.5},
+		{"2.0 ** 0.5 * 2.0 ** 0.5", 2.0000000000000004},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{"1.5 <= 2", true},
+		{"2 ** 64 >= 2 ** 64", true},
+		{`"apple" < "banana"`, true},
+		{`"b" > "abc"`, true},
+		{`"abc" <= "abc"`, true},
+		{`"abc" >= "abd"`, false},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{"true && false", false},
+		{"1 && 2", 2},
+		{"0 && 2", 2},
+		{"false && 2", false},
+		{"null_ish || 1", "identifier not found: null_ish"},
+		{"false || 3", 3},
+		{`"x" || 3`, "x"},
+		{"if (false) { 1 } || 9", 9},
+		{"1 < 2 && 2 < 3", true},
+		{"1 << -1", "negative shift count: -1"},
+		{"true & false", "unknown operator: BOOLEAN & BOOLEAN"},
+		{"1.5 << 1", "unknown operator: FLOAT << INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.BigInteger:
+				if obj.Value.String() != expected {
+					t.Errorf("%q: wrong value. expected=%s, got=%s", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, expected, obj.Message)
+				}
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%q: wrong value. expected=%q, got=%q", tt.input, expected, obj.Value)
+				}
+			default:
+				t.Errorf("%q: unexpected object. got=%T (%+v)", tt.input, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// the right operand would be an error if it were evaluated
+		{"let r = false && missing; if (r) { 1 } else { 2 }", 2},
+		{"let r = true || missing; if (r) { 1 } else { 2 }", 1},
+		{`
+let boom = fn() { 1 + true };
+let a = false && boom();
+let b = 5 || boom();
+b`, 5},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"let zero = 0; 10 / zero", "division by zero"},
+		{"(2 ** 64) / 0", "division by zero"},
+		{"(2 ** 64) % (1 - 1)", "division by zero"},
+		{"1.5 / 0", "division by zero"},
+		{"1 / 0.0", "division by zero"},
+		{"0 ** -1", "division by zero"},
+		{"0.0 ** -2", "division by zero"},
+		{"(2 ** 64 - 2 ** 64) ** -1", "division by zero"},
+		{"2 ** 100000000000", "exponent too large: 100000000000"},
+		{"10 ** 10000000", "exponent too large: 10000000"},
+		{"3 ** (2 ** 70)", "exponent too large: 1180591620717411303424"},
+		{"let add = fn(a, b) { a + b }; add(1)", "wrong number of arguments: want 2, got 1"},
+		{"let add = fn(a, b) { a + b }; add(1, 2, 3)", "wrong number of arguments: want 2, got 3"},
+		{"fn() { 1 }(1)", "wrong number of arguments: want 0, got 1"},
+		{"let f = fn(x) { x / 0 }; let g = fn() { f(1) + 1 }; g()", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q",
+				tt.input, tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	env := object.NewEnvirnment()
+	env.Set("boom", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		var arr []object.Object
+		return arr[len(args)] // index out of range
+	}})
+
+	program := parser.New(lexer.New("let x = 1; boom(x);")).ParseProgram()
+	evaluated := Eval(program, env)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if !strings.HasPrefix(errObj.Message, "internal error: runtime error: index out of range") {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+	if !strings.Contains(errObj.GoStack, "goroutine") {
+		t.Errorf("internal error carries no stack. got=%q", errObj.GoStack)
+	}
+
+	// the environment stays usable after the panic
+	testIntegerObject(t, Eval(parser.New(lexer.New("x + 1")).ParseProgram(), env), 2)
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { 1 / 0 } catch (e) { 2 }`, 2},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { throw "bad input" } catch (e) { e }`, "bad input"},
+		{`try { throw 42 } catch (e) { e + 1 }`, 43},
+		{`try { throw {"code": 7} } catch (e) { e["code"] }`, 7},
+		{`let f = fn() { throw "deep" }; let g = fn() { f() }; try { g() } catch (e) { e }`, "deep"},
+		{`let f = fn() { 1 / 0 }; try { f() } catch (e) { len(e["stack"]) }`, 2},
+		{`try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e }`, 2},
+		{`try { try { 1 / 0 } catch (e) { throw e } } catch (e) { e["message"] }`, "division by zero"},
+		{`try { throw 1 } catch (e) { let x = e; }; x`, "identifier not found: x"},
+		{`let x = 0; try { x } finally { 5 }`, 0},
+		{`try { 1 } catch (e) { 2 } finally { throw "from finally" }`, "from finally"},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, 1},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn() { try { throw 1 } catch (e) { return 10 } finally { 3 }; 20 }; f()`, 10},
+		{`let f = fn() { try { throw 1 } finally { return 4 } }; f()`, 4},
+		{`let f = fn() { try { 1 } finally { 2 }; 3 }; f()`, 3},
+		{`try { throw "uncaught" } finally { 1 }`, "uncaught"},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestCaughtErrorStack(t *testing.T) {
+	input := `let inner = fn() { 1 / 0 };
+let outer = fn() { inner() };
+try { outer() } catch (e) { e["stack"] }`
+
+	l := lexer.NewFile("script.mk", input)
+	p := parser.New(l)
+	evaluated := Eval(p.ParseProgram(), object.NewEnvirnment())
+
+	stack, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T(%+v)", evaluated, evaluated)
+	}
+	expected := []string{
+		"script.mk:3:7, in <main>",
+		"script.mk:2:20, in outer",
+		"script.mk:1:20, in inner",
+	}
+	if len(stack.Elements) != len(expected) {
+		t.Fatalf("wrong stack depth. expected=%d, got=%d", len(expected), len(stack.Elements))
+	}
+	for i, want := range expected {
+		if got := stack.Elements[i].Inspect(); got != want {
+			t.Errorf("stack[%d] wrong. expected=%q, got=%q", i, want, got)
+		}
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x", 15},
+		{"let x = 10; x -= 5; x", 5},
+		{"let x = 10; x *= 5; x", 50},
+		{"let x = 10; x /= 5; x", 2},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let a = 0; let b = 0; a = b = 3; a + b", 6},
+		{"let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n", 2},
+		{"let counter = fn() { let c = 0; fn() { c += 1 } }; let next = counter(); next(); next()", 2},
+		{"let x = 1; let f = fn() { let x = 5; x = 6 }; f(); x", 1},
+		{"let x = 1; if (true) { x = 7 }; x", 7},
+		{"y = 1", "identifier not found: y"},
+		{"let f = fn() { z = 1 }; f()", "identifier not found: z"},
+		{"len = 1", "cannot assign to builtin: len"},
+		{"let x = 1; x /= 0", "division by zero"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"const c = 1; c", 1},
+		{"const c = 1; c = 2", "cannot assign to constant: c"},
+		{"const c = 1; c += 1", "cannot assign to constant: c"},
+		{"const c = 1; let f = fn() { c = 2 }; f()", "cannot assign to constant: c"},
+		{"const c = 1; let c = 2", "cannot redeclare constant: c"},
+		{"const c = 1; let f = fn() { let c = 2; c = 3 }; f()", 3},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[0] = 10; a[0]", 10},
+		{"let a = [1, 2, 3]; a[2] += 5; a[2]", 8},
+		{"let a = [1, 2, 3]; a[1] = 7", 7},
+		{"let a = [[1, 2], [3, 4]]; a[1][0] = 9; a[1][0]", 9},
+		{"let a = [1, 2, 3]; let b = a; b[0] = 42; a[0]", 42},
+		{"let a = [1, 2, 3]; let f = fn(arr) { arr[1] = 0 }; f(a); a[1]", 0},
+		{"let a = [1, 2, 3]; let b = push(a, 4); b[0] = 9; a[0]", 1},
+		{"let mk = fn() { [0] }; let a = mk(); a[0] = 1; mk()[0]", 0},
+		{"let a = [1, 2, 3]; a[3] = 0", "index out of range: 3 (length 3)"},
+		{"let a = [1, 2, 3]; a[-1] = 0", "index out of range: -1 (length 3)"},
+		{"let a = [1]; a[2 ** 64] = 0", "index out of range: 18446744073709551616 (length 1)"},
+		{`let a = [1]; a["x"] = 0`, "array index must be INTEGER, got STRING"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{`let h = {"a": 1}; h["a"] = 2; h["a"]`, 2},
+		{`let h = {}; h["b"] = 3; h["b"]`, 3},
+		{`let h = {"n": 1}; h["n"] *= 10; h["n"]`, 10},
+		{`let h = {}; h[1] = "one"; h[1]`, "one"},
+		{`let h = {}; let g = h; g["k"] = 5; h["k"]`, 5},
+		{`let h = {}; h[[1]] = 1`, "unusable as hash key: ARRAY"},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a")`, 1},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a"); h["b"]`, 2},
+		{`let h = {"a": 1}; delete(h, "z")`, nil},
+		{`let h = {"a": 1}; delete(h, "a"); h["a"]`, nil},
+		{`delete([1], 0)`, "arguments to `delete` must be HASH, got ARRAY"},
+		{`delete({}, fn(x) { x })`, "unusable as hash key: FUNCTION"},
+		{`delete({})`, "wrong number of args, got 1, want = 2"},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+// testExpectedObject checks obj against an int, a string (the value of
+// a String or the message of an Error) or nil for NULL
+func testExpectedObject(t *testing.T, input string, obj object.Object, expected interface{}) {
+	t.Helper()
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, obj, int64(expected))
+	case nil:
+		testNullObject(t, obj)
+	case string:
+		switch obj := obj.(type) {
+		case *object.String:
+			if obj.Value != expected {
+				t.Errorf("%q: expected=%q, got=%q", input, expected, obj.Value)
+			}
+		case *object.Error:
+			if obj.Message != expected {
+				t.Errorf("%q: wrong error message. expected=%q, got=%q",
+					input, expected, obj.Message)
+			}
+		default:
+			t.Errorf("%q: unexpected object. got=%T(%+v)", input, obj, obj)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { i += 1 }; i", 10},
+		{"let i = 0; while (false) { i = 1 }; i", 0},
+		{"while (false) { 1 }", nil},
+		{"let s = 0; for (x in [1, 2, 3]) { s += x }; s", 6},
+		{"let s = 0; for (i, x in [10, 20, 30]) { s += i * x }; s", 80},
+		{`let s = ""; for (c in "héllo") { s = c + s }; s`, "olléh"},
+		{`let s = 0; for (i, c in "abc") { s += i }; s`, 3},
+		{`let s = ""; for (k in {"b": 2, "a": 1, "c": 3}) { s += k }; s`, "abc"},
+		{`let s = 0; for (k, v in {"b": 2, "a": 1}) { s += v }; s`, 3},
+		{`let s = ""; for (k in {10: 1, 9: 2, -1: 3}) { s += "${k},"}; s`, "-1,9,10,"},
+		{"let s = 0; for (i in range(5)) { s += i }; s", 10},
+		{"let s = 0; for (i in range(2, 5)) { s += i }; s", 9},
+		{"let s = 0; for (i in range(10, 0, -3)) { s += i }; s", 22},
+		{"let s = 0; for (i in range(5, 5)) { s += 1 }; s", 0},
+		{"len(range(0, 10, 3))", 4},
+		{"len(range(10, 0))", 0},
+		{"range(1, 2, 0)", "range step must not be zero"},
+		{"for (x in 5) { x }", "not iterable: INTEGER"},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break } }; i", 5},
+		{"let s = 0; for (i in range(10)) { if (i % 2 == 0) { continue } s += i }; s", 25},
+		{"let n = 0; for (i in range(3)) { for (j in range(3)) { if (j == 1) { break } n += 1 } }; n", 3},
+		{"let f = fn() { for (i in range(10)) { if (i == 4) { return i } } }; f()", 4},
+		{"let f = fn() { while (true) { return 7 } }; f()", 7},
+		{"for (i in range(3)) { if (i == 1) { 1 / 0 } }", "division by zero"},
+		{"let i = 0; while (i < 3) { try { i += 1; continue } finally { i += 10 } }; i", 11},
+		{"let i = 0; while (true) { try { break } finally { i = 1 } }; i", 1},
+		{"let fs = []; for (i in range(3)) { fs = push(fs, fn() { i }) }; fs[0]() + fs[2]()", 2},
+		{"let x = 1; for (x in [5]) { x }; x", 1},
+		{"let a = [1, 2, 3]; for (i, x in a) { a[i] = x * 2 }; a[2]", 6},
+		{`let h = {"a": 1}; for (k in h) { h["b"] = 2 }; len(push([], h["b"]))`, 1},
+		{"let n = 0; for (i in range(100000)) { n += 1 }; n", 100000},
+
+		// a break, continue or return in an expression leaves it at once
+		{"let n = 0; for (i in [1, 2]) { n += 1; let a = [1, if (true) { break }] }; n", 1},
+		{"let n = 0; for (c in [true, false]) { let v = if (c) { continue }; n += 1 }; n", 1},
+		{"let n = 0; while (n < 5) { n += 1; len([if (n < 5) { continue }]) }; n", 5},
+		{"let n = 0; for (i in [1, 2, 3]) { n += [1, match (i) { 2 => { continue }, _ => i }][1] }; n", 4},
+		{"let n = 0; for (i in [1, 2]) { n = n + if (true) { break } }; n", 0},
+		{"let n = 0; let h = {}; for (i in [1]) { h[if (true) { break }] = 1; n = 1 }; n", 0},
+		{`let s = ""; for (i in [1, 2]) { s += "${if (i == 1) { continue }}" }; s`, "null"},
+		{"let f = fn() { let v = if (true) { return 3 }; 4 }; f()", 3},
+		{"let f = fn() { [1, if (true) { return 5 }] }; f()", 5},
+		{"let f = fn(x) { x }; let n = 0; for (i in [1, 2]) { f(if (i == 1) { continue }); n += i }; n", 2},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestElseIf(t *testing.T) {
+	classify := `let classify = fn(x) {
+  if (x < 0) { "neg" } else if (x == 0) { "zero" } else if (x < 10) { "small" } else { "big" }
+};`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{classify + "classify(-5)", "neg"},
+		{classify + "classify(0)", "zero"},
+		{classify + "classify(3)", "small"},
+		{classify + "classify(30)", "big"},
+		{"if (false) { 1 } else if (false) { 2 }", nil},
+		{"let f = fn(x) { if (x) { return 1 } else if (true) { return 2 }; 3 }; f(false)", 2},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (1) { 1 => "one", _ => "other" }`, "one"},
+		{`match (7) { 1 => "one", _ => "other" }`, "other"},
+		{`match (2) { 1, 2, 3 => "few", _ => "many" }`, "few"},
+		{`match (-3) { -3 => "minus three" }`, "minus three"},
+		{`match (2.0) { 2 => "two" }`, "two"},
+		{`match ("x") { "y" => 1, "x" => 2 }`, 2},
+		{`match (true) { false => 1, true => 2 }`, 2},
+		{`match (5) { 1 => 1 }`, nil},
+		{`match ([1, 2]) { [a, b] => a + b }`, 3},
+		{`match ([1, 2, 3]) { [a, b] => 0, [a, b, c] => a + b + c }`, 6},
+		{`match ([1, [2, 3]]) { [1, [x, _]] => x }`, 2},
+		{`match ([2, 9]) { [1, x] => x, [x, 9] => x * 10 }`, 20},
+		{`match ({"name": "ann", "age": 30}) { {"age": a} => a }`, 30},
+		{`match ({"kind": "circle", "r": 2}) { {"kind": "square", "side": s} => s * s, {"kind": "circle", "r": r} => 3 * r * r }`, 12},
+		{`match ({"a": 1}) { {"b": x} => x, _ => "no b" }`, "no b"},
+		{`match ("str") { [a] => a, {"k": v} => v, s => s }`, "str"},
+		{`match (12) { n if n < 10 => "small", n if n < 100 => "medium", _ => "large" }`, "medium"},
+		{`match ([3, 4]) { [a, b] if a > b => "desc", [a, b] => "asc" }`, "asc"},
+		{`match (5) { n => { let doubled = n * 2; doubled } }`, 10},
+		{`let n = 1; match (5) { n => n }; n`, 1},
+		{`let x = 0; match ([1, 2]) { [x, 3] => x, _ => x }`, 0},
+		{`match (1 / 0) { _ => 1 }`, "division by zero"},
+		{`match (1) { n if n / 0 => 1 }`, "division by zero"},
+		{`let f = fn(x) { match (x) { 0 => { return "zero" }, _ => 1 }; "after" }; f(0)`, "zero"},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; len(rest) * 100 + rest[0] * 10 + rest[1]", 234},
+		{"let [a, ...rest] = [1]; len(rest)", 0},
+		{"let [...all] = [1, 2]; len(all)", 2},
+		{"let arr = [1, 2, 3]; let [...copy] = arr; copy[0] = 9; arr[0]", 1},
+		{"let [a, [b, c]] = [1, [2, 3]]; a + b + c", 6},
+		{"let [a, b = 5] = [1]; a + b", 6},
+		{"let [a, b = a * 2] = [3]; b", 6},
+		{"let [_, second] = [1, 2]; second", 2},
+		{`let {name, age} = {"name": "ann", "age": 30}; name`, "ann"},
+		{`let {name, age} = {"name": "ann", "age": 30, "x": 1}; age`, 30},
+		{`let {name = "anon"} = {}; name`, "anon"},
+		{`let {name: who} = {"name": "bob"}; who`, "bob"},
+		{`let {home: {city}} = {"home": {"city": "oslo"}}; city`, "oslo"},
+		{`let {pos: [x, y] = [3, 4]} = {}; x * y`, 12},
+		{`let {1: one, true: yes} = {1: "a", true: "b"}; one + yes`, "ab"},
+		{"let [a, b] = [1, 2, 3]", "cannot destructure ARRAY: expected 2 elements, got 3"},
+		{"let [a, b, c] = [1]", "cannot destructure ARRAY: expected 3 elements, got 1"},
+		{"let [a, b, c = 1, ...r] = [1]", "cannot destructure ARRAY: expected at least 2 elements, got 1"},
+		{"let [a, b] = 5", "cannot destructure INTEGER: expected an array, got INTEGER"},
+		{"let [a, [b]] = [1, 2]", "cannot destructure ARRAY: expected an array, got INTEGER"},
+		{`let {name} = [1]`, "cannot destructure ARRAY: expected a hash, got ARRAY"},
+		{`let {name, age} = {"name": "ann"}`, "cannot destructure HASH: missing key age"},
+		{`let [1, x] = [2, 3]`, "cannot destructure ARRAY: expected 1, got 2"},
+		{"let [a = 1 / 0] = []", "division by zero"},
+		{"const [a, b] = [1, 2]; a = 3", "cannot assign to constant: a"},
+		{"const [a, b] = [1, 2]; b", 2},
+		{"const c = 1; let [c] = [2]", "cannot redeclare constant: c"},
+		{"let f = fn([a, b]) { a + b }; f([1, 2])", 3},
+		{`let f = fn({name}, greeting) { greeting + " " + name }; f({"name": "ann"}, "hi")`, "hi ann"},
+		{`let f = fn({x = 0, y = 0}) { x + y }; f({"y": 2})`, 2},
+		{"let f = fn([a, b]) { a + b }; f([1])", "cannot destructure ARRAY: expected 2 elements, got 1"},
+		{`let f = fn({name}) { name }; f(1)`, "cannot destructure INTEGER: expected a hash, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestParametersAndSpread(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x, y = x * 2) { y }; f(4)", 8},
+		{"let y = 1; let f = fn(x = y) { x }; let y = 2; f()", 2},
+		{"let f = fn(x = 1 / 0) { x }; f(5)", 5},
+		{"let f = fn(x = 1 / 0) { x }; f()", "division by zero"},
+		{"let f = fn(first, ...others) { len(others) }; f(1, 2, 3)", 2},
+		{"let f = fn(first, ...others) { len(others) }; f(1)", 0},
+		{"let f = fn(...all) { all[1] }; f(1, 2, 3)", 2},
+		{"let f = fn(a, b = 2, ...r) { a + b + len(r) }; f(1)", 3},
+		{"let f = fn(a, b = 2, ...r) { a + b + len(r) }; f(1, 5, 0, 0)", 8},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; let args = [1, 2, 3]; f(...args)", 123},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(1, ...[2, 3])", 123},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(...range(1, 4))", 123},
+		{"let f = fn(...xs) { len(xs) }; f(...[], ...[1], ...[2, 3])", 3},
+		{`let f = fn(...cs) { cs[2] }; f(..."abc")`, "c"},
+		{"len([0, ...[1, 2], 3])", 4},
+		{"let xs = [1, 2]; let ys = [...xs]; ys[0] = 9; xs[0]", 1},
+		{"let f = fn(...xs) { xs[0] = 9 }; let a = [1]; f(...a); a[0]", 1},
+		{`let f = fn(x) { x }; f(...{"a": 1})`, "cannot spread HASH"},
+		{"let f = fn(x) { x }; f(...5)", "cannot spread INTEGER"},
+		{"let f = fn(x, y) { x }; f(1)", "wrong number of arguments: want 2, got 1"},
+		{"let f = fn(x, y = 1) { x }; f()", "wrong number of arguments: want 1 to 2, got 0"},
+		{"let f = fn(x, y = 1) { x }; f(1, 2, 3)", "wrong number of arguments: want 1 to 2, got 3"},
+		{"let f = fn(x, ...r) { x }; f()", "wrong number of arguments: want at least 1, got 0"},
+		{"let f = fn(x, y) { x }; f(...[1, 2, 3])", "wrong number of arguments: want 2, got 3"},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } };
+count(1000000, 0)`, 1000000},
+		{`let count = fn(n, acc) { if (n == 0) { return acc; } return count(n - 1, acc + 1); };
+count(1000000, 0)`, 1000000},
+		{`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+if (even(1000001)) { "even" } else { "odd" }`, "odd"},
+		{`let sum = fn(xs, i = 0, acc = 0) {
+  if (i == len(xs)) { acc } else { sum(xs, i + 1, acc + xs[i]) }
+};
+sum([1, 2, 3, 4])`, 10},
+		{"let f = fn(n) { if (n == 0) { len(\"abc\") } else { f(n - 1) } }; f(3)", 3},
+		{"let f = fn(n) { if (n == 0) { len(1) } else { f(n - 1) } }; f(3)", "arg to `len` not supported so far, got INTEGER"},
+		{"let f = fn(n) { if (n == 0) { f() } else { f(n - 1) } }; f(3)", "wrong number of arguments: want 1, got 0"},
+		{"let f = fn(n) { try { g(n) } catch (e) { e } }; let g = fn(n) { throw n }; f(7)", 7},
+		{"let f = fn() { 5(1) }; f()", "not a function: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCallErrorStack(t *testing.T) {
+	input := `let loop = fn(n) {
+  if (n == 0) { 1 / 0 } else { loop(n - 1) }
+};
+let start = fn() { loop(1000) };
+start();`
+
+	l := lexer.NewFile("script.mk", input)
+	p := parser.New(l)
+	evaluated := Eval(p.ParseProgram(), object.NewEnvirnment())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	traceback := `Traceback (most recent call last):
+  script.mk:5:1, in <main>
+  script.mk:4:20, in start
+  script.mk:2:32, in loop
+  script.mk:2:17, in loop
+ERROR: division by zero`
+	if errObj.Traceback() != traceback {
+		t.Errorf("wrong traceback. expected=\n%s\ngot=\n%s",
+			traceback, errObj.Traceback())
+	}
+}
+
+func TestRecursionDepth(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn() { 1 + f() }; f()", "maximum recursion depth exceeded"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(5000)", 5000},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(100000)", 0},
+		{"let f = fn() { 1 + f() }; try { f() } catch (e) { e[\"message\"] }", "maximum recursion depth exceeded"},
+	}
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, testEval(tt.input), tt.expected)
+	}
+
+	input := `let f = fn() {
+  1 + f()
+};
+f();`
+	l := lexer.NewFile("script.mk", input)
+	evaluated := Eval(parser.New(l).ParseProgram(), object.NewEnvirnment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	traceback := fmt.Sprintf(`Traceback (most recent call last):
+  script.mk:4:1, in <main>
+  script.mk:2:7, in f
+  script.mk:2:7, in f
+  script.mk:2:7, in f
+  [previous line repeated %d more times]
+ERROR: maximum recursion depth exceeded`, MaxCalls-3)
+	if errObj.Traceback() != traceback {
+		t.Errorf("wrong traceback. expected=\n%s\ngot=\n%s",
+			traceback, errObj.Traceback())
+	}
+}
+
+func TestResolveSlots(t *testing.T) {
+	input := `let a = 1;
+let f = fn(b, ...c) { let d = a + b; fn() { d + len(c) } };
+for (k, v in [1]) { try { v } catch (e) { e + k } }`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	if diags := Resolve(program); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+
+	var got []string
+	var visit func(n ast.Node)
+	collect := func(i *ast.Identifier) {
+		if i.Slot == nil {
+			got = append(got, i.Value+"=name")
+		} else {
+			got = append(got, fmt.Sprintf("%s=%d.%d", i.Value, i.Slot.Depth, i.Slot.Index))
+		}
+	}
+	visit = func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			collect(n.Name)
+			visit(n.Value)
+		case *ast.ExpressionStatement:
+			visit(n.Expression)
+		case *ast.BlockStatement:
+			for _, s := range n.Statements {
+				visit(s)
+			}
+		case *ast.ForStatement:
+			collect(n.Key)
+			collect(n.Value)
+			visit(n.Body)
+		case *ast.TryExpression:
+			visit(n.Block)
+			collect(n.CatchParam)
+			visit(n.Catch)
+		case *ast.FunctionLiteral:
+			for _, p := range n.Parameters {
+				collect(p.(*ast.Identifier))
+			}
+			if n.Rest != nil {
+				collect(n.Rest)
+			}
+			visit(n.Body)
+		case *ast.CallExpression:
+			visit(n.Function)
+			for _, a := range n.Arguments {
+				visit(a)
+			}
+		case *ast.InfixExpression:
+			visit(n.Left)
+			visit(n.Right)
+		case *ast.Identifier:
+			collect(n)
+		}
+	}
+	for _, s := range program.Statements {
+		visit(s)
+	}
+
+	expected := []string{
+		"a=0.0",
+		"f=0.1",
+		"b=0.0", "c=0.1", "d=0.2", "a=1.0", "b=0.0",
+		"d=1.2", "len=name", "c=1.1",
+		"k=0.1", "v=0.0", "v=0.0", "e=0.0", "e=0.0", "k=1.1",
+	}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong slots.\nexpected=%v\ngot=     %v", expected, got)
+	}
+}
+
+func TestResolveUndefined(t *testing.T) {
+	input := `let f = fn(x) { x + y };
+puts(f(1), {"k": z});
+match (1) { n if n > w => n }
+q = 1;`
+
+	program := parser.New(lexer.NewFile("script.mk", input)).ParseProgram()
+	var got []string
+	for _, d := range Resolve(program) {
+		got = append(got, d.String())
+	}
+
+	expected := []string{
+		"script.mk:1:21: error[E011]: identifier not found: y",
+		"script.mk:2:18: error[E011]: identifier not found: z",
+		"script.mk:3:22: error[E011]: identifier not found: w",
+		"script.mk:4:1: error[E011]: identifier not found: q",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong diagnostics.\nexpected=\n%s\ngot=\n%s",
+			strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+// TestResolvedEval evaluates each program by name and by slot,
+// both must give the same result
+func TestResolvedEval(t *testing.T) {
+	tests := []string{
+		`let a = 1; let b = a + 2; b * 3`,
+		`let f = fn(x) { fn(y) { x + y } }; f(1)(2)`,
+		`let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()`,
+		`let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(10)`,
+		`let g = fn() { h() }; let h = fn() { 7 }; g()`,
+		`let fs = []; for (i in [1, 2, 3]) { fs = push(fs, fn() { i }) } fs[0]() + fs[2]()`,
+		`let s = 0; for (k, v in [5, 6]) { s += k * v } s`,
+		`let s = ""; for (k in {"a": 1}) { s += k } s`,
+		`let i = 0; while (i < 5) { if (i == 3) { break; } i += 1 } i`,
+		`try { throw "boom" } catch (e) { e + "!" }`,
+		`let x = 1; try { x = 2; 1 / 0 } catch (e) { x += 10 } finally { x *= 2 }`,
+		`let [a, [b, c], ...r] = [1, [2, 3], 4, 5]; a + b + c + len(r)`,
+		`let {"k": v, "d": w = v + 1} = {"k": 1}; [v, w]`,
+		`let f = fn(a, b = a * 2, ...rest) { [a, b, rest] }; f(1)`,
+		`match ([1, 2]) { [x] => x, [x, y] if x > y => 0, [x, y] => x + y }`,
+		`match (3) { 1, 2 => "small", n => n * 2 }`,
+		`match ([1, 5]) { [x, 1], [1, x] => x }`,
+		`const c = 1; c = 2`,
+		`const c = 1; let c = 2`,
+		`let f = fn() { const k = 1; k += 1 }; f()`,
+		`len = 1`,
+		`let f = fn(x) { x }; f()`,
+		`1 + true`,
+	}
+
+	for _, input := range tests {
+		byName := testEval(input)
+
+		program := parser.New(lexer.New(input)).ParseProgram()
+		if diags := Resolve(program); len(diags) != 0 {
+			t.Errorf("%q: unexpected diagnostics: %v", input, diags)
+			continue
+		}
+		bySlot := Eval(program, object.NewEnvirnment())
+
+		if byName.Inspect() != bySlot.Inspect() {
+			t.Errorf("%q: by name %s, by slot %s", input, byName.Inspect(), bySlot.Inspect())
+		}
+	}
+}
+
+// TestResolveShadowing uses names before the let that shadows them has
+// run, by slot each must give what it gives by name
+func TestResolveShadowing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // Inspect of the result, or the error message
+	}{
+		{`let x = 1; let f = fn() { let y = x; let x = 2; y }; f()`, "1"},
+		{`let x = 1; let f = fn() { let y = x; let x = 2; [y, x] }; f()`, "[1, 2]"},
+		{`let f = fn() { let y = x; let x = 2; y }; let x = 3; f()`, "3"},
+		{`let x = 1; let f = fn() { x = 5; let x = 2; x }; [f(), x]`, "[2, 5]"},
+		{`let x = 1; let f = fn() { let g = fn() { x }; let a = g(); let x = 2; [a, g()] }; f()`, "[1, 2]"},
+		{`let x = 1; let f = fn() { let g = fn() { x }; if (false) { let x = 2 }; g() }; f()`, "1"},
+		{`let x = 1; let f = fn(a = x) { let x = 2; a }; f()`, "1"},
+		{`let x = 1; for (i in [1, 2]) { let y = x; let x = y + 10 } x`, "1"},
+		{`let s = []; let x = 0; for (i in [1, 2]) { s = push(s, x); let x = i } s`, "[0, 0]"},
+		{`let x = 1; match (2) { n => { let y = x; let x = n; y + x } }`, "3"},
+		{`let x = 1; try { 1 / 0 } catch (e) { let y = x; let x = 2; y + x }`, "3"},
+		{`let f = fn() { let n = len("ab"); let len = fn(s) { 0 }; n + len("abc") }; f()`, "2"},
+		{`let f = fn() { let y = x; let x = 2; y }; f()`, "identifier not found: x"},
+		{`const c = 1; let f = fn() { c = 2; let c = 3 }; f()`, "cannot assign to constant: c"},
+		{`let x = if (true) { }; x`, "<nil>"},
+		{`let x = 1; let f = fn() { let x = if (true) { }; x }; f()`, "<nil>"},
+	}
+
+	inspect := func(obj object.Object) string {
+		switch obj := obj.(type) {
+		case nil:
+			return "<nil>" // the value of an empty block
+		case *object.Error:
+			return obj.Message
+		}
+		return obj.Inspect()
+	}
+
+	for _, tt := range tests {
+		byName := inspect(testEval(tt.input))
+		if byName != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, byName)
+		}
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		Resolve(program)
+		bySlot := inspect(Eval(program, object.NewEnvirnment()))
+		if byName != bySlot {
+			t.Errorf("%q: by name %s, by slot %s", tt.input, byName, bySlot)
+		}
+	}
+}
+
+const benchmarkInput = `let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+let deep = fn(a) { fn(b) { fn(c) { fn(d) {
+  let s = 0; let i = 0;
+  while (i < 200) { s += a + b + c + d + i; i += 1 }
+  s
+} } } };
+fib(15) + deep(1)(2)(3)(4)`
+
+// BenchmarkEval compares looking variables up by name through the
+// chain of scopes with looking them up by their resolved slot
+func BenchmarkEval(b *testing.B) {
+	b.Run("names", func(b *testing.B) {
+		program := parser.New(lexer.New(benchmarkInput)).ParseProgram()
+		for i := 0; i < b.N; i++ {
+			Eval(program, object.NewEnvirnment())
+		}
+	})
+	b.Run("slots", func(b *testing.B) {
+		program := parser.New(lexer.New(benchmarkInput)).ParseProgram()
+		Resolve(program)
+		for i := 0; i < b.N; i++ {
+			Eval(program, object.NewEnvirnment())
+		}
+	})
+}
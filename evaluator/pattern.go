@@ -0,0 +1,207 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/object"
+)
+
+// evalMatchExpression takes the first arm with a matching pattern and
+// a true guard, each attempt binds into its own scope so a failed one
+// leaves nothing behind. Without such an arm the result is null
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isControl(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		for _, pat := range arm.Patterns {
+			armEnv := object.NewEnclosedEnvirnment(env)
+			ok, err := matchPattern(pat, subject, armEnv)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if arm.Guard != nil {
+				guard := Eval(arm.Guard, armEnv)
+				if isControl(guard) {
+					return guard
+				}
+				if !isTruthy(guard) {
+					continue
+				}
+			}
+			return Eval(arm.Body, armEnv)
+		}
+	}
+
+	return NULL
+}
+
+// matchPattern reports whether val has the shape of pat,
+// binding the names in pat into env on the way
+func matchPattern(pat ast.Pattern, val object.Object, env *object.Environment) (bool, object.Object) {
+	mismatch, err := bindPattern(pat, val, env, false)
+	return mismatch == "" && err == nil, err
+}
+
+// destructure binds pat like a let or const does,
+// a value of the wrong shape is an error
+func destructure(pat ast.Pattern, val object.Object, env *object.Environment, constant bool) object.Object {
+	mismatch, err := bindPattern(pat, val, env, constant)
+	if err != nil {
+		return err
+	}
+	if mismatch != "" {
+		return newError("cannot destructure %s: %s", val.Type(), mismatch)
+	}
+	return nil
+}
+
+// bindPattern binds the names in pat to the matching parts of val.
+// A val of the wrong shape gives a description of the mismatch, an
+// error from evaluating a default or rebinding a constant gives err
+func bindPattern(pat ast.Pattern, val object.Object, env *object.Environment,
+	constant bool) (mismatch string, err object.Object) {
+	switch pat := pat.(type) {
+	case *ast.WildcardPattern:
+		return "", nil
+	case *ast.Identifier:
+		return "", bindName(pat, val, env, constant)
+	case *ast.LiteralPattern:
+		lit := Eval(pat.Value, env)
+		if evalInfixExpression("==", lit, val) != TRUE {
+			return fmt.Sprintf("expected %s, got %s", lit.Inspect(), val.Inspect()), nil
+		}
+		return "", nil
+	case *ast.DefaultPattern:
+		return bindPattern(pat.Target, val, env, constant)
+	case *ast.ArrayPattern:
+		return bindArrayPattern(pat, val, env, constant)
+	case *ast.HashPattern:
+		return bindHashPattern(pat, val, env, constant)
+	default:
+		return "", newError("unknown pattern: %T", pat)
+	}
+}
+
+func bindArrayPattern(pat *ast.ArrayPattern, val object.Object, env *object.Environment,
+	constant bool) (string, object.Object) {
+	arr, ok := val.(*object.Array)
+	if !ok {
+		return fmt.Sprintf("expected an array, got %s", val.Type()), nil
+	}
+
+	n := len(arr.Elements)
+	if n > len(pat.Elements) && pat.Rest == nil {
+		return fmt.Sprintf("expected %d elements, got %d", len(pat.Elements), n), nil
+	}
+
+	for i, el := range pat.Elements {
+		var elVal object.Object
+		if i < n {
+			elVal = arr.Elements[i]
+		} else if def, ok := el.(*ast.DefaultPattern); ok {
+			if elVal = Eval(def.Default, env); isControl(elVal) {
+				return "", elVal
+			}
+		} else {
+			atLeast := ""
+			if pat.Rest != nil {
+				atLeast = "at least "
+			}
+			return fmt.Sprintf("expected %s%d elements, got %d", atLeast, requiredCount(pat.Elements), n), nil
+		}
+
+		if mismatch, err := bindPattern(el, elVal, env, constant); mismatch != "" || err != nil {
+			return mismatch, err
+		}
+	}
+
+	if pat.Rest != nil {
+		rest := []object.Object{}
+		if n > len(pat.Elements) {
+			rest = make([]object.Object, n-len(pat.Elements))
+			copy(rest, arr.Elements[len(pat.Elements):])
+		}
+		return "", bindName(pat.Rest, &object.Array{Elements: rest}, env, constant)
+	}
+	return "", nil
+}
+
+// requiredCount counts the patterns up to the last one without a default
+func requiredCount(patterns []ast.Pattern) int {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if _, ok := patterns[i].(*ast.DefaultPattern); !ok {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func bindHashPattern(pat *ast.HashPattern, val object.Object, env *object.Environment,
+	constant bool) (string, object.Object) {
+	hash, ok := val.(*object.Hash)
+	if !ok {
+		return fmt.Sprintf("expected a hash, got %s", val.Type()), nil
+	}
+
+	for _, pair := range pat.Pairs {
+		key := Eval(pair.Key, env)
+		var v object.Object
+		if p, ok := hash.Pairs[key.(object.Hashable).HashKey()]; ok {
+			v = p.Value
+		} else if def, ok := pair.Value.(*ast.DefaultPattern); ok {
+			if v = Eval(def.Default, env); isControl(v) {
+				return "", v
+			}
+		} else {
+			return fmt.Sprintf("missing key %s", pair.Key.String()), nil
+		}
+
+		if mismatch, err := bindPattern(pair.Value, v, env, constant); mismatch != "" || err != nil {
+			return mismatch, err
+		}
+	}
+	return "", nil
+}
+
+// bindName binds the name of ident in env, refusing to shadow a
+// constant of env
+func bindName(ident *ast.Identifier, val object.Object, env *object.Environment,
+	constant bool) object.Object {
+	if ident.Slot != nil {
+		if env.IsConstSlot(ident.Slot.Index) {
+			return newError("cannot redeclare constant: %s", ident.Value)
+		}
+		if constant {
+			env.SetConstSlot(ident.Slot.Index, val)
+		} else {
+			env.SetSlot(ident.Slot.Index, val)
+		}
+		return nil
+	}
+
+	if env.IsConst(ident.Value) {
+		return newError("cannot redeclare constant: %s", ident.Value)
+	}
+	if constant {
+		env.SetConst(ident.Value, val)
+	} else {
+		env.Set(ident.Value, val)
+	}
+	return nil
+}
+
+// set binds a parameter or loop variable of a fresh scope
+func set(env *object.Environment, ident *ast.Identifier, val object.Object) {
+	if ident.Slot != nil {
+		env.SetSlot(ident.Slot.Index, val)
+	} else {
+		env.Set(ident.Value, val)
+	}
+}
@@ -0,0 +1,217 @@
+package evaluator
+
+import (
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/parser"
+)
+
+// scope maps the names it declares to their index
+type scope struct {
+	names map[string]int
+	outer *scope
+}
+
+type resolver struct {
+	scope       *scope
+	diagnostics []parser.Diagnostic
+}
+
+// Resolve works out ahead of evaluation which variable each identifier
+// of program refers to and sets its Slot, so Eval finds the variable by
+// index instead of by name. A builtin keeps a nil Slot, a name that is
+// neither is reported.
+//
+// The scopes are those of Eval: the program, a function call, a round
+// of a for-in loop, a catch clause and an attempt at a match arm, blocks
+// share the scope they are in. The names a let binds anywhere in a
+// scope belong to it from its start, an identifier used before its let
+// has run gets the variable of the same name further out instead
+func Resolve(program *ast.Program) []parser.Diagnostic {
+	r := &resolver{}
+	r.open(Declarations(nil, program))
+	for _, s := range program.Statements {
+		r.node(s)
+	}
+	return r.diagnostics
+}
+
+func (r *resolver) open(names []string) {
+	s := &scope{names: make(map[string]int, len(names)), outer: r.scope}
+	for i, name := range names {
+		s.names[name] = i
+	}
+	r.scope = s
+}
+
+func (r *resolver) close() { r.scope = r.scope.outer }
+
+// use resolves an identifier that reads or assigns a variable, to
+// every scope out that declares it
+func (r *resolver) use(ident *ast.Identifier) {
+	ident.Slot = nil
+	next := &ident.Slot
+	depth := 0
+	for s := r.scope; s != nil; s = s.outer {
+		if i, ok := s.names[ident.Value]; ok {
+			*next = &ast.Slot{Depth: depth, Index: i}
+			next = &(*next).Outer
+		}
+		depth++
+	}
+	if ident.Slot != nil {
+		return
+	}
+
+	if _, ok := builtins[ident.Value]; !ok {
+		r.diagnostics = append(r.diagnostics, parser.Diagnostic{
+			Severity: parser.SeverityError,
+			Code:     parser.ErrUndefined,
+			Message:  "identifier not found: " + ident.Value,
+			Pos:      ident.Pos(),
+			End:      ident.End(),
+		})
+	}
+}
+
+// bind resolves an identifier that the current scope declares
+func (r *resolver) bind(ident *ast.Identifier) {
+	ident.Slot = &ast.Slot{Index: r.scope.names[ident.Value]}
+}
+
+func (r *resolver) node(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		r.node(node.Value)
+		if node.Pattern != nil {
+			r.pattern(node.Pattern)
+		} else {
+			r.bind(node.Name)
+		}
+	case *ast.ExpressionStatement:
+		r.node(node.Expression)
+	case *ast.ReturnStatement:
+		r.node(node.ReturnValue)
+	case *ast.ThrowStatement:
+		r.node(node.Value)
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, s := range node.Statements {
+			r.node(s)
+		}
+	case *ast.WhileStatement:
+		r.node(node.Condition)
+		r.node(node.Body)
+	case *ast.ForStatement:
+		r.node(node.Iterable)
+		vars := []ast.Pattern{node.Value}
+		if node.Key != nil {
+			vars = append(vars, node.Key)
+		}
+		r.open(Declarations(vars, node.Body))
+		for _, v := range vars {
+			r.bind(v.(*ast.Identifier))
+		}
+		r.node(node.Body)
+		r.close()
+
+	case *ast.Identifier:
+		r.use(node)
+	case *ast.InterpolatedString:
+		for _, p := range node.Parts {
+			r.node(p)
+		}
+	case *ast.PrefixExpression:
+		r.node(node.Right)
+	case *ast.InfixExpression:
+		r.node(node.Left)
+		r.node(node.Right)
+	case *ast.AssignExpression:
+		r.node(node.Target)
+		r.node(node.Value)
+	case *ast.IfExpression:
+		r.node(node.Condition)
+		r.node(node.Consequence)
+		r.node(node.Alternative)
+	case *ast.TryExpression:
+		r.node(node.Block)
+		if node.Catch != nil {
+			r.open(Declarations([]ast.Pattern{node.CatchParam}, node.Catch))
+			r.bind(node.CatchParam)
+			r.node(node.Catch)
+			r.close()
+		}
+		r.node(node.Finally)
+	case *ast.FunctionLiteral:
+		params := node.Parameters[:len(node.Parameters):len(node.Parameters)]
+		if node.Rest != nil {
+			params = append(params, node.Rest)
+		}
+		r.open(Declarations(params, node.Body))
+		for _, p := range params {
+			r.pattern(p)
+		}
+		r.node(node.Body)
+		r.close()
+	case *ast.SpreadExpression:
+		r.node(node.Value)
+	case *ast.CallExpression:
+		r.node(node.Function)
+		for _, a := range node.Arguments {
+			r.node(a)
+		}
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			r.node(e)
+		}
+	case *ast.IndexExpression:
+		r.node(node.Left)
+		r.node(node.Index)
+	case *ast.HashLiteral:
+		// in source order, so that diagnostics come out in a stable order
+		for _, k := range ast.SortedKeys(node) {
+			r.node(k)
+			r.node(node.Pairs[k])
+		}
+	case *ast.MatchExpression:
+		r.node(node.Subject)
+		for _, arm := range node.Arms {
+			// every pattern of the arm binds into a scope laid out the
+			// same way, as its guard and body are shared
+			r.open(Declarations(arm.Patterns, arm.Guard, arm.Body))
+			for _, p := range arm.Patterns {
+				r.pattern(p)
+			}
+			r.node(arm.Guard)
+			r.node(arm.Body)
+			r.close()
+		}
+	}
+}
+
+// pattern binds the names pat declares and resolves the expressions
+// of its literals, keys and defaults
+func (r *resolver) pattern(pat ast.Pattern) {
+	switch pat := pat.(type) {
+	case *ast.Identifier:
+		r.bind(pat)
+	case *ast.LiteralPattern:
+		r.node(pat.Value)
+	case *ast.DefaultPattern:
+		r.node(pat.Default)
+		r.pattern(pat.Target)
+	case *ast.ArrayPattern:
+		for _, el := range pat.Elements {
+			r.pattern(el)
+		}
+		if pat.Rest != nil {
+			r.bind(pat.Rest)
+		}
+	case *ast.HashPattern:
+		for _, p := range pat.Pairs {
+			r.node(p.Key)
+			r.pattern(p.Value)
+		}
+	}
+}
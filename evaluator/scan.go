@@ -0,0 +1,129 @@
+package evaluator
+
+import "github.com/clg0803/circus/ast"
+
+// Declarations lists the names that patterns bind, then those that let
+// and const bind in the scope the nodes belong to. It leaves out
+// function literals, for-in bodies, catch clauses and match arms, which
+// open scopes of their own. Declaring these names up front lets a
+// closure refer to a variable that its scope binds only after the
+// closure is made
+func Declarations(patterns []ast.Pattern, nodes ...ast.Node) []string {
+	d := &declared{seen: map[string]bool{}}
+	for _, p := range patterns {
+		d.pattern(p)
+	}
+	for _, n := range nodes {
+		d.node(n)
+	}
+	return d.names
+}
+
+type declared struct {
+	names []string
+	seen  map[string]bool
+}
+
+func (d *declared) add(name string) {
+	if !d.seen[name] {
+		d.seen[name] = true
+		d.names = append(d.names, name)
+	}
+}
+
+func (d *declared) node(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			d.node(s)
+		}
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, s := range node.Statements {
+			d.node(s)
+		}
+	case *ast.LetStatement:
+		d.node(node.Value)
+		if node.Pattern != nil {
+			d.pattern(node.Pattern)
+		} else {
+			d.add(node.Name.Value)
+		}
+	case *ast.ExpressionStatement:
+		d.node(node.Expression)
+	case *ast.ReturnStatement:
+		d.node(node.ReturnValue)
+	case *ast.ThrowStatement:
+		d.node(node.Value)
+	case *ast.WhileStatement:
+		d.node(node.Condition)
+		d.node(node.Body)
+	case *ast.ForStatement:
+		d.node(node.Iterable)
+	case *ast.PrefixExpression:
+		d.node(node.Right)
+	case *ast.InfixExpression:
+		d.node(node.Left)
+		d.node(node.Right)
+	case *ast.AssignExpression:
+		d.node(node.Target)
+		d.node(node.Value)
+	case *ast.IfExpression:
+		d.node(node.Condition)
+		d.node(node.Consequence)
+		d.node(node.Alternative)
+	case *ast.TryExpression:
+		d.node(node.Block)
+		d.node(node.Finally)
+	case *ast.MatchExpression:
+		d.node(node.Subject)
+	case *ast.CallExpression:
+		d.node(node.Function)
+		for _, a := range node.Arguments {
+			d.node(a)
+		}
+	case *ast.SpreadExpression:
+		d.node(node.Value)
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			d.node(e)
+		}
+	case *ast.IndexExpression:
+		d.node(node.Left)
+		d.node(node.Index)
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			d.node(k)
+			d.node(v)
+		}
+	case *ast.InterpolatedString:
+		for _, p := range node.Parts {
+			d.node(p)
+		}
+	}
+}
+
+// pattern declares the names pat binds, and what the
+// expressions of its defaults declare
+func (d *declared) pattern(pat ast.Pattern) {
+	switch pat := pat.(type) {
+	case *ast.Identifier:
+		d.add(pat.Value)
+	case *ast.DefaultPattern:
+		d.node(pat.Default)
+		d.pattern(pat.Target)
+	case *ast.ArrayPattern:
+		for _, el := range pat.Elements {
+			d.pattern(el)
+		}
+		if pat.Rest != nil {
+			d.add(pat.Rest.Value)
+		}
+	case *ast.HashPattern:
+		for _, p := range pat.Pairs {
+			d.pattern(p.Value)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package evaluator
+
+import "github.com/clg0803/circus/object"
+
+// The vm runs compiled programs with the semantics of the evaluator,
+// these give it the operators, builtins and conversions to share
+
+// Infix applies the binary operator op, && and || excepted
+func Infix(op string, left, right object.Object) object.Object {
+	return evalInfixExpression(op, left, right)
+}
+
+// Prefix applies the unary operator op
+func Prefix(op string, right object.Object) object.Object {
+	return evalPrefixExpression(op, right)
+}
+
+// Index gives left[index]
+func Index(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// IsTruthy reports whether obj counts as true in a condition
+func IsTruthy(obj object.Object) bool { return isTruthy(obj) }
+
+// ThrownMessage is the message of the error made by `throw val`
+func ThrownMessage(val object.Object) string { return thrownMessage(val) }
+
+// LookupBuiltin gives the builtin function called name
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	b, ok := builtins[name]
+	return b, ok
+}
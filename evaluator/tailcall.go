@@ -0,0 +1,136 @@
+package evaluator
+
+import (
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/token"
+)
+
+// tailCall is what a call to a Monkey function in tail position
+// evaluates to: applyFunction makes the call once the caller's body is
+// done, so recursion through tail calls runs in constant Go stack.
+// It never leaves the evaluator
+type tailCall struct {
+	fn      *object.Function
+	args    []object.Object
+	callPos token.Position
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalTail evaluates node in tail position of a function body. The tail
+// positions are the last statement of the body, the value of a return
+// there, and again the last statement of either branch of an if found
+// in one. Anything else, a try block say, is evaluated as usual
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		return evalTailBlock(node, env)
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env)
+	case *ast.ReturnStatement:
+		val := evalTail(node.ReturnValue, env)
+		if _, ok := val.(*tailCall); ok || isControl(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+	case *ast.IfExpression:
+		con := Eval(node.Condition, env)
+		if isControl(con) {
+			return con
+		}
+		if isTruthy(con) {
+			return evalTail(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTail(node.Alternative, env)
+		}
+		return NULL
+	case *ast.CallExpression:
+		f := Eval(node.Function, env)
+		if isControl(f) {
+			return f
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isControl(args[0]) {
+			return args[0]
+		}
+		if fn, ok := f.(*object.Function); ok {
+			return &tailCall{fn: fn, args: args, callPos: node.Pos()}
+		}
+		return claimError(applyFunction(f, args, node.Pos(), env.Calls()), node.Pos())
+	default:
+		return Eval(node, env)
+	}
+}
+
+func evalTailBlock(b *ast.BlockStatement, env *object.Environment) object.Object {
+	var ans object.Object
+
+	for i, s := range b.Statements {
+		if i == len(b.Statements)-1 {
+			return evalTail(s, env)
+		}
+		ans = Eval(s, env)
+		if isControl(ans) {
+			return ans
+		}
+	}
+
+	return ans
+}
+
+// callFunction runs fn and then each function its body tail-calls in
+// turn. The frames of the tail calls are kept for error stacks, but a
+// call that comes back to a frame already there drops the loop between
+// them, so a tail-recursive loop keeps one frame per call site.
+// Tail calls do not nest, but more than MaxCalls calls in progress is
+// an error rather than an overflow of the Go stack
+func callFunction(fn *object.Function, args []object.Object,
+	callPos token.Position, calls int) object.Object {
+	frames := []object.Frame{{Function: functionName(fn), CallPos: callPos}}
+	if calls++; calls > MaxCalls {
+		return claimError(newError("maximum recursion depth exceeded"), callPos)
+	}
+
+	for {
+		if err := checkArity(fn, len(args)); err != nil {
+			return withStack(claimError(err, callPos), frames[:len(frames)-1])
+		}
+		env, err := extendFunctionEnv(fn, args, calls)
+		if err != nil {
+			return withStack(claimError(err, callPos), frames[:len(frames)-1])
+		}
+
+		res := evalTail(fn.Body, env)
+		tc, ok := res.(*tailCall)
+		if !ok {
+			return unwrapReturnValue(withStack(res, frames))
+		}
+
+		fn, args, callPos = tc.fn, tc.args, tc.callPos
+		frames = pushFrame(frames, object.Frame{Function: functionName(fn), CallPos: callPos})
+	}
+}
+
+// MaxCalls bounds the calls in progress at once, on the vm as well
+const MaxCalls = 10000
+
+func pushFrame(frames []object.Frame, f object.Frame) []object.Frame {
+	for i, g := range frames {
+		if g == f {
+			return frames[:i+1]
+		}
+	}
+	return append(frames, f)
+}
+
+// withStack appends frames, outermost first, to the stack of an error
+func withStack(obj object.Object, frames []object.Frame) object.Object {
+	if err, ok := obj.(*object.Error); ok {
+		for i := len(frames) - 1; i >= 0; i-- {
+			err.Stack = append(err.Stack, frames[i])
+		}
+	}
+	return obj
+}
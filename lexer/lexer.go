@@ -1,43 +1,108 @@
 package lexer
 
 import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/clg0803/circus/token"
 )
 
-// ACII supported only
+// Lexer reads UTF-8 source, positions count columns in runes
+// and offsets in bytes
 type Lexer struct {
+	filename     string
 	input        string
 	position     int
 	readposition int
-	ch           byte
+	ch           rune
+
+	line   int // line of l.ch
+	column int // column of l.ch
+
+	// interps holds the '{' nesting depth inside every open `${`,
+	// the '}' at depth 0 resumes the enclosing string
+	interps []int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile is like New but records filename in every token position
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	w := 0
 	if l.readposition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readposition]
+		l.ch, w = utf8.DecodeRuneInString(l.input[l.readposition:])
 	}
 	l.position = l.readposition
-	l.readposition++
+	l.readposition += w
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readposition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readposition]
+		r, _ := utf8.DecodeRuneInString(l.input[l.readposition:])
+		return r
 	}
 }
 
+// pos returns the position of l.ch
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// endPos returns the position just past l.ch
+func (l *Lexer) endPos() token.Position {
+	p := l.pos()
+	p.Offset = l.readposition
+	p.Column++
+	return p
+}
+
 func (l *Lexer) NextToken() (tok token.Token) {
-	l.skipWhiteSpace()
+	var comments []token.Comment
+	for {
+		l.skipWhiteSpace()
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			break
+		}
+		c, ok := l.readComment()
+		if !ok {
+			return token.Token{Type: token.ILLEGAL, Literal: c.Text,
+				Pos: c.Pos, End: c.End, Comments: comments}
+		}
+		comments = append(comments, c)
+	}
+
+	start := l.pos()
+	defer func() {
+		if !tok.Pos.IsValid() { // a bad escape points into the string
+			tok.Pos, tok.End = start, l.pos()
+		}
+		tok.Comments = comments
+	}()
 	switch l.ch {
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
@@ -48,26 +113,101 @@ func (l *Lexer) NextToken() (tok token.Token) {
 	case ')':
 		tok = newToken(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.interps); n > 0 {
+			l.interps[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interps); n > 0 {
+			if l.interps[n-1] == 0 {
+				l.interps = l.interps[:n-1]
+				tok = l.readString(true)
+				break
+			}
+			l.interps[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar() // eat '='
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: "+="}
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar() // eat '='
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: "-="}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar() // eat '='
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: "/="}
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		switch l.peekChar() {
+		case '*':
+			l.readChar() // eat '*'
+			tok = token.Token{Type: token.POW, Literal: "**"}
+		case '=':
+			l.readChar() // eat '='
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: "*="}
+		default:
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			l.readChar() // eat '='
+			tok = token.Token{Type: token.LT_EQ, Literal: "<="}
+		case '<':
+			l.readChar() // eat '<'
+			tok = token.Token{Type: token.SHL, Literal: "<<"}
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			l.readChar() // eat '='
+			tok = token.Token{Type: token.GT_EQ, Literal: ">="}
+		case '>':
+			l.readChar() // eat '>'
+			tok = token.Token{Type: token.SHR, Literal: ">>"}
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar() // eat '&'
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar() // eat '|'
+			tok = token.Token{Type: token.OR, Literal: "||"}
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
 	case '=':
-		if l.peekChar() == '=' {
+		switch l.peekChar() {
+		case '=':
 			l.readChar() // eat '='
 			tok = token.Token{Type: token.EQ, Literal: "=="}
-		} else {
+		case '>':
+			l.readChar() // eat '>'
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
+		default:
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '!':
@@ -78,17 +218,31 @@ func (l *Lexer) NextToken() (tok token.Token) {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '"': // string
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString(false)
+	case '`': // raw string
+		tok = l.readRawString()
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		switch {
+		case strings.HasPrefix(l.input[l.position:], "..."):
+			l.readChar()
+			l.readChar() // eat ".."
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		case isDigit(l.peekChar()):
+			tok.Literal, tok.Type = l.readNumber()
+			return
+		default:
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+		return // stay at the end of input
 
 	default: // identifier
 		if isLetter(l.ch) {
@@ -96,8 +250,7 @@ func (l *Lexer) NextToken() (tok token.Token) {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
 			return
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -108,7 +261,7 @@ func (l *Lexer) NextToken() (tok token.Token) {
 	return
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
@@ -120,10 +273,11 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[p:l.position]
 }
 
-func isLetter(ch byte) bool {
+func isLetter(ch rune) bool {
 	return 'a' <= ch && ch <= 'z' ||
 		'A' <= ch && ch <= 'Z' ||
-		ch == '_'
+		ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
 func (l *Lexer) skipWhiteSpace() {
@@ -132,25 +286,220 @@ func (l *Lexer) skipWhiteSpace() {
 	}
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads `12`, `3.14`, `.5`, `1e-9` or `2.5E3`,
+// anything with a fraction or an exponent is a FLOAT
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	p := l.position
+	t := token.TokenType(token.INT)
+
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		t = token.FLOAT
+		l.readChar() // eat '.'
+		l.readDigits()
+	}
+	if (l.ch == 'e' || l.ch == 'E') && l.isExponent() {
+		t = token.FLOAT
+		l.readChar() // eat 'e'
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+
+	return l.input[p:l.position], t
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[p:l.position]
 }
 
-func isDigit(ch byte) bool {
+// isExponent reports whether the 'e' at l.ch is followed by digits
+func (l *Lexer) isExponent() bool {
+	i := l.readposition
+	if i < len(l.input) && (l.input[i] == '+' || l.input[i] == '-') {
+		i++
+	}
+	return i < len(l.input) && isDigit(rune(l.input[i]))
+}
+
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) readString() string {
-	p := l.position + 1 // eat '"'
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// readString reads a "..." string decoding its escape sequences,
+// it stops on the closing quote. A string cut by a newline or the end of
+// input gives an ILLEGAL token with the text read so far, a bad escape
+// sequence gives an ILLEGAL token with just the escape.
+//
+// `${` stops the string too, "a ${x} b ${y} c" is lexed as
+// STRING_HEAD(a ) x STRING_MID( b ) y STRING_TAIL( c), resume is set
+// when reading on from the '}' that closes an interpolation
+func (l *Lexer) readString(resume bool) token.Token {
+	var out strings.Builder
+	var bad *token.Token
+	p, start := l.position, l.pos()
+
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case '"':
+			if bad != nil {
+				return *bad
+			}
+			if resume {
+				return token.Token{Type: token.STRING_TAIL, Literal: out.String()}
+			}
+			return token.Token{Type: token.STRING, Literal: out.String()}
+		case '$':
+			if l.peekChar() != '{' {
+				out.WriteRune(l.ch)
+				break
+			}
+			l.readChar() // l.ch is '{'
+			l.interps = append(l.interps, 0)
+			if bad != nil {
+				return *bad
+			}
+			if resume {
+				return token.Token{Type: token.STRING_MID, Literal: out.String()}
+			}
+			return token.Token{Type: token.STRING_HEAD, Literal: out.String()}
+		case '\n', 0:
+			// always starts with '"' so the parser knows what broke,
+			// the span ends at the last char of the string
+			return token.Token{Type: token.ILLEGAL, Literal: `"` + l.input[p+1:l.position],
+				Pos: start, End: l.pos()}
+		case '\\':
+			start, q := l.pos(), l.position
+			if !l.readEscape(&out) && bad == nil {
+				bad = &token.Token{Type: token.ILLEGAL,
+					Literal: l.input[q:l.readposition], Pos: start, End: l.endPos()}
+			}
+		default:
+			out.WriteRune(l.ch)
+		}
+	}
+}
+
+var escapes = map[rune]rune{
+	'n': '\n', 't': '\t', 'r': '\r', '0': 0,
+	'\\': '\\', '"': '"', '\'': '\'', '$': '$',
+}
+
+// readEscape decodes the escape sequence at l.ch == '\\',
+// leaving l.ch on its last char
+func (l *Lexer) readEscape(out *strings.Builder) bool {
+	if r, ok := escapes[l.peekChar()]; ok {
+		l.readChar()
+		out.WriteRune(r)
+		return true
+	}
+
+	switch l.peekChar() {
+	case 'x': // \xHH
+		l.readChar()
+		return l.readHexEscape(out, 2, false)
+	case 'u': // \uHHHH or \u{H...}
+		l.readChar()
+		if l.peekChar() == '{' {
+			l.readChar()
+			return l.readHexEscape(out, 6, true)
+		}
+		return l.readHexEscape(out, 4, false)
+	default:
+		if l.peekChar() != '\n' && l.peekChar() != 0 {
+			l.readChar()
+		}
+		return false
+	}
+}
+
+// readHexEscape reads up to n hex digits, exactly n unless braced
+func (l *Lexer) readHexEscape(out *strings.Builder, n int, braced bool) bool {
+	var digits strings.Builder
+	for digits.Len() < n && isHexDigit(l.peekChar()) {
+		l.readChar()
+		digits.WriteRune(l.ch)
+	}
+	if braced {
+		if l.peekChar() != '}' || digits.Len() == 0 {
+			return false
+		}
+		l.readChar()
+	} else if digits.Len() != n {
+		return false
+	}
+
+	v, _ := strconv.ParseUint(digits.String(), 16, 32)
+	if !utf8.ValidRune(rune(v)) {
+		return false
+	}
+	out.WriteRune(rune(v))
+	return true
+}
+
+// readRawString reads a `...` string verbatim, it may span lines
+func (l *Lexer) readRawString() token.Token {
+	p, start := l.position, l.pos()
+	for {
+		l.readChar()
+		switch l.ch {
+		case '`':
+			return token.Token{Type: token.STRING, Literal: l.input[p+1 : l.position]}
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[p:l.position],
+				Pos: start, End: l.pos()}
+		}
+	}
+}
+
+// readComment reads a comment starting at l.ch, it reports false
+// when a block comment runs into the end of input
+func (l *Lexer) readComment() (token.Comment, bool) {
+	c := token.Comment{Pos: l.pos()}
+	p := l.position
+	ok := true
+
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	} else {
+		ok = l.skipBlockComment()
+	}
+
+	c.Text = l.input[p:l.position]
+	c.End = l.pos()
+	return c, ok
+}
+
+// block comments nest: /* a /* b */ c */
+func (l *Lexer) skipBlockComment() bool {
+	depth := 0
+	for {
+		switch {
+		case l.ch == 0:
+			return false
+		case l.ch == '/' && l.peekChar() == '*':
+			l.readChar()
+			l.readChar()
+			depth++
+		case l.ch == '*' && l.peekChar() == '/':
+			l.readChar()
+			l.readChar()
+			depth--
+			if depth == 0 {
+				return true
+			}
+		default:
+			l.readChar()
 		}
 	}
-	return l.input[p:l.position]
 }
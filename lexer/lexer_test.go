@@ -15,7 +15,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -142,3 +142,400 @@ if (5 < 10) {
 	}
 }
 
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  add(x, \"ab\")\n"
+
+	tests := []struct {
+		expectedType token.TokenType
+		line, column int
+		offset       int
+		endColumn    int
+	}{
+		{token.LET, 1, 1, 0, 4},
+		{token.IDENT, 1, 5, 4, 6},
+		{token.ASSIGN, 1, 7, 6, 8},
+		{token.INT, 1, 9, 8, 10},
+		{token.SEMICOLON, 1, 10, 9, 11},
+		{token.IDENT, 2, 3, 13, 6},
+		{token.LPAREN, 2, 6, 16, 7},
+		{token.IDENT, 2, 7, 17, 8},
+		{token.COMMA, 2, 8, 18, 9},
+		{token.STRING, 2, 10, 20, 14},
+		{token.RPAREN, 2, 14, 24, 15},
+		{token.EOF, 3, 1, 26, 1},
+	}
+
+	l := NewFile("script.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.Filename != "script.mk" {
+			t.Fatalf("tests[%d] - filename wrong. got=%q", i, tok.Pos.Filename)
+		}
+		if tok.Pos.Line != tt.line || tok.Pos.Column != tt.column {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.line, tt.column, tok.Pos.Line, tok.Pos.Column)
+		}
+		if tok.Pos.Offset != tt.offset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d, got=%d",
+				i, tt.offset, tok.Pos.Offset)
+		}
+		if tok.End.Column != tt.endColumn {
+			t.Fatalf("tests[%d] - end column wrong. expected=%d, got=%d",
+				i, tt.endColumn, tok.End.Column)
+		}
+	}
+
+	if s := (token.Position{Filename: "script.mk", Line: 12, Column: 7}).String(); s != "script.mk:12:7" {
+		t.Errorf("Position.String() wrong. got=%q", s)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 5; // trailing
+/* block /* nested */ still comment */ x / 2
+/**/ x
+// at the end`
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.LET, "let", []string{"// leading"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "5", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// trailing", "/* block /* nested */ still comment */"}},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", nil},
+		{token.IDENT, "x", []string{"/**/"}},
+		{token.EOF, "", []string{"// at the end"}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if len(tok.Comments) != len(tt.expectedComments) {
+			t.Fatalf("tests[%d] - wrong number of comments. expected=%d, got=%d",
+				i, len(tt.expectedComments), len(tok.Comments))
+		}
+		for j, c := range tok.Comments {
+			if c.Text != tt.expectedComments[j] {
+				t.Fatalf("tests[%d] - comment[%d] wrong. expected=%q, got=%q",
+					i, j, tt.expectedComments[j], c.Text)
+			}
+			if input[c.Pos.Offset:c.End.Offset] != c.Text {
+				t.Fatalf("tests[%d] - comment[%d] has wrong span", i, j)
+			}
+		}
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	l := New("let x = 1; /* outer /* inner */ never closed")
+
+	for i := 0; i < 5; i++ {
+		l.NextToken()
+	}
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.ILLEGAL, tok.Type)
+	}
+	if tok.Literal != "/* outer /* inner */ never closed" {
+		t.Fatalf("literal wrong. got=%q", tok.Literal)
+	}
+	if tok.Pos.Column != 12 {
+		t.Fatalf("position wrong. got=%s", tok.Pos)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("expected EOF after unterminated comment. got=%q", tok.Type)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `3.14 .5 1e-9 2.5E3 1e+2 42 7.x 3e a.b`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, ".5"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E3"},
+		{token.FLOAT, "1e+2"},
+		{token.INT, "42"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.INT, "3"},
+		{token.IDENT, "e"},
+		{token.IDENT, "a"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "b"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"plain"`, token.STRING, "plain"},
+		{`"a\nb\tc\r"`, token.STRING, "a\nb\tc\r"},
+		{`"say \"hi\""`, token.STRING, `say "hi"`},
+		{`"back\\slash"`, token.STRING, `back\slash`},
+		{`"it\'s"`, token.STRING, "it's"},
+		{`"nul\0"`, token.STRING, "nul\x00"},
+		{`"\x41\x42"`, token.STRING, "AB"},
+		{`"café"`, token.STRING, "café"},
+		{`"\u{1F412}"`, token.STRING, "🐒"},
+		{`"héllo wörld"`, token.STRING, "héllo wörld"},
+		{"`raw \\n \"quoted\"`", token.STRING, `raw \n "quoted"`},
+		{"`multi\nline`", token.STRING, "multi\nline"},
+		{`"open`, token.ILLEGAL, `"open`},
+		{"\"cut\nhere\"", token.ILLEGAL, `"cut`},
+		{"`open", token.ILLEGAL, "`open"},
+		{`"bad \q escape"`, token.ILLEGAL, `\q`},
+		{`"\x4"`, token.ILLEGAL, `\x4`},
+		{`"\u{110000}"`, token.ILLEGAL, `\u{110000}`},
+	}
+
+	for i, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStringErrorRecovery(t *testing.T) {
+	l := New("let s = \"a \\q b\"; let t = \"open\nlet u = 1;")
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		column          int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "s", 5},
+		{token.ASSIGN, "=", 7},
+		{token.ILLEGAL, `\q`, 12},
+		{token.SEMICOLON, ";", 17},
+		{token.LET, "let", 19},
+		{token.IDENT, "t", 23},
+		{token.ASSIGN, "=", 25},
+		{token.ILLEGAL, `"open`, 27},
+		{token.LET, "let", 1},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.column {
+			t.Fatalf("tests[%d] - column wrong. expected=%d, got=%d",
+				i, tt.column, tok.Pos.Column)
+		}
+	}
+}
+
+func TestUnicodeSource(t *testing.T) {
+	input := `let größe = "日本語"; größe + π`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		column          int
+		offset          int
+	}{
+		{token.LET, "let", 1, 0},
+		{token.IDENT, "größe", 5, 4},
+		{token.ASSIGN, "=", 11, 12},
+		{token.STRING, "日本語", 13, 14},
+		{token.SEMICOLON, ";", 18, 25},
+		{token.IDENT, "größe", 20, 27},
+		{token.PLUS, "+", 26, 35},
+		{token.IDENT, "π", 28, 37},
+		{token.EOF, "", 29, 39},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.column || tok.Pos.Offset != tt.offset {
+			t.Fatalf("tests[%d] - position wrong. expected=col %d offset %d, got=col %d offset %d",
+				i, tt.column, tt.offset, tok.Pos.Column, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	input := `"Hi ${name}, ${ {"a": 1}["a"] } and ${"in ${x}"}!" "$5 \${no}"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING_HEAD, "Hi "},
+		{token.IDENT, "name"},
+		{token.STRING_MID, ", "},
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "a"},
+		{token.RBRACKET, "]"},
+		{token.STRING_MID, " and "},
+		{token.STRING_HEAD, "in "},
+		{token.IDENT, "x"},
+		{token.STRING_TAIL, ""},
+		{token.STRING_TAIL, "!"},
+		{token.STRING, "$5 ${no}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := `a <= b >= c % d ** e && f || g & h | i ^ j << k >> l < m > n * o += p -= q *= r /= s = t => u ...v`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"}, {token.LT_EQ, "<="},
+		{token.IDENT, "b"}, {token.GT_EQ, ">="},
+		{token.IDENT, "c"}, {token.PERCENT, "%"},
+		{token.IDENT, "d"}, {token.POW, "**"},
+		{token.IDENT, "e"}, {token.AND, "&&"},
+		{token.IDENT, "f"}, {token.OR, "||"},
+		{token.IDENT, "g"}, {token.BIT_AND, "&"},
+		{token.IDENT, "h"}, {token.BIT_OR, "|"},
+		{token.IDENT, "i"}, {token.BIT_XOR, "^"},
+		{token.IDENT, "j"}, {token.SHL, "<<"},
+		{token.IDENT, "k"}, {token.SHR, ">>"},
+		{token.IDENT, "l"}, {token.LT, "<"},
+		{token.IDENT, "m"}, {token.GT, ">"},
+		{token.IDENT, "n"}, {token.ASTERISK, "*"},
+		{token.IDENT, "o"}, {token.PLUS_ASSIGN, "+="},
+		{token.IDENT, "p"}, {token.MINUS_ASSIGN, "-="},
+		{token.IDENT, "q"}, {token.ASTERISK_ASSIGN, "*="},
+		{token.IDENT, "r"}, {token.SLASH_ASSIGN, "/="},
+		{token.IDENT, "s"}, {token.ASSIGN, "="},
+		{token.IDENT, "t"}, {token.ARROW, "=>"},
+		{token.IDENT, "u"}, {token.ELLIPSIS, "..."},
+		{token.IDENT, "v"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	input := `const try catch finally throw while for in break continue inside`
+
+	expected := []token.TokenType{
+		token.CONST, token.TRY, token.CATCH, token.FINALLY, token.THROW,
+		token.WHILE, token.FOR, token.IN, token.BREAK, token.CONTINUE,
+		token.IDENT, token.EOF,
+	}
+
+	l := New(input)
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt, tok.Type)
+		}
+	}
+}
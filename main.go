@@ -4,16 +4,94 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 
+	"github.com/clg0803/circus/astfile"
+	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/parser"
 	"github.com/clg0803/circus/repl"
 )
 
+// usage: circus [script], circus vm [script], circus build script [out]
+// or circus dump script. Without a script it starts the repl, vm runs
+// the script or the repl on the bytecode vm instead of the evaluator
 func main() {
+	args, engine := os.Args[1:], repl.EngineEval
+	if len(args) > 0 && args[0] == "vm" {
+		args, engine = args[1:], repl.EngineVM
+	}
+
+	switch {
+	case engine == repl.EngineEval && len(args) > 1 && args[0] == "build":
+		buildFile(args[1], args[2:])
+		return
+	case engine == repl.EngineEval && len(args) > 1 && args[0] == "dump":
+		dumpFile(args[1])
+		return
+	case len(args) > 0:
+		runFile(args[0], engine)
+		return
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Hello %s! This is the Monkey lang! 🐵🙊🙉🙈\n", user.Username)
 	fmt.Printf("Feel free to type in commands \n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, engine)
+}
+
+func runFile(filename string, engine repl.Engine) {
+	src := readFile(filename)
+	ok := false
+	if astfile.IsPrecompiled(src) {
+		program, err := astfile.Load(src)
+		exitOn(err)
+		ok = repl.Exec(program, engine, os.Stderr)
+	} else {
+		ok = repl.Run(filename, string(src), engine, os.Stderr)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// buildFile writes the precompiled program of a script, next to it
+// with a .mkc extension unless an output file is given
+func buildFile(filename string, out []string) {
+	src := readFile(filename)
+	p := parser.New(lexer.NewFile(filename, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprint(os.Stderr, parser.RenderAll(string(src), p.Errors()))
+		os.Exit(1)
+	}
+
+	data, err := astfile.Encode(program)
+	exitOn(err)
+
+	target := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mkc"
+	if len(out) > 0 {
+		target = out[0]
+	}
+	exitOn(os.WriteFile(target, data, 0o644))
+}
+
+func dumpFile(filename string) {
+	exitOn(astfile.Dump(os.Stdout, readFile(filename)))
+}
+
+func readFile(filename string) []byte {
+	src, err := os.ReadFile(filename)
+	exitOn(err)
+	return src
+}
+
+func exitOn(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -7,22 +7,69 @@ func NewEnvirnment() *Environment {
 	return &Environment{store: s, outer: nil}
 }
 
+// NewEnclosedEnvirnment makes the map of names on first use, a scope
+// of a resolved program only ever uses its slots
 func NewEnclosedEnvirnment(outer *Environment) *Environment {
-	env := NewEnvirnment()
-	env.outer = outer
-	return env
+	return &Environment{outer: outer, calls: outer.calls}
+}
+
+// NewCallEnvirnment makes the scope of a function call whose closure
+// is outer, calls is the number of calls it is nested in
+func NewCallEnvirnment(outer *Environment, calls int) *Environment {
+	return &Environment{outer: outer, calls: calls}
 }
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	consts map[string]bool // names bound by `const` in this scope
+	outer  *Environment
+
+	slots []slot // variables of a resolved program, by index
+	calls int    // function calls in progress, see NewCallEnvirnment
+}
+
+// Calls gives the number of function calls e is evaluated in
+func (e *Environment) Calls() int { return e.calls }
+
+// slot is a variable that the resolver gave an index, bound tells a
+// variable set to the nil of an empty block from one not set yet
+type slot struct {
+	value    Object
+	bound    bool
+	constant bool
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
 
+// SetConst binds name like Set and marks it read-only
+func (e *Environment) SetConst(name string, val Object) Object {
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	e.consts[name] = true
+	return e.Set(name, val)
+}
+
+// IsConst reports whether name is a constant of this very scope
+func (e *Environment) IsConst(name string) bool { return e.consts[name] }
+
+// Resolve walks outward and returns the scope that binds name,
+// nil if no scope does
+func (e *Environment) Resolve(name string) *Environment {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return env
+		}
+	}
+	return nil
+}
+
 func (e *Environment) Get(name string) (obj Object, ok bool) {
 	obj, ok = e.store[name]
 	if !ok && e.outer != nil {
@@ -30,3 +77,49 @@ func (e *Environment) Get(name string) (obj Object, ok bool) {
 	}
 	return
 }
+
+// Scope returns the scope depth levels out from e
+func (e *Environment) Scope(depth int) *Environment {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	return env
+}
+
+// GetSlot returns the variable at index of the scope depth levels out,
+// ok is false while it is not set yet
+func (e *Environment) GetSlot(depth, index int) (obj Object, ok bool) {
+	env := e.Scope(depth)
+	if index < len(env.slots) {
+		return env.slots[index].value, env.slots[index].bound
+	}
+	return nil, false
+}
+
+// SetSlot binds the variable at index of this scope
+func (e *Environment) SetSlot(index int, val Object) Object {
+	e.grow(index)
+	e.slots[index].value, e.slots[index].bound = val, true
+	return val
+}
+
+// SetConstSlot binds the variable at index like SetSlot and marks it
+// read-only
+func (e *Environment) SetConstSlot(index int, val Object) Object {
+	e.grow(index)
+	e.slots[index] = slot{value: val, bound: true, constant: true}
+	return val
+}
+
+// IsConstSlot reports whether the variable at index of this very scope
+// is a constant
+func (e *Environment) IsConstSlot(index int) bool {
+	return index < len(e.slots) && e.slots[index].constant
+}
+
+func (e *Environment) grow(index int) {
+	if index >= len(e.slots) {
+		e.slots = append(e.slots, make([]slot, index+1-len(e.slots))...)
+	}
+}
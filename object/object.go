@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/code"
+	"github.com/clg0803/circus/token"
 )
 
 // consider every value in Monkey as an Object
@@ -17,6 +23,8 @@ type BuiltinFunction func(args ...Object) Object //  内置函数
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	BIG_INTEGER_OBJ  = "BIG_INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	STRING_OBJ       = "STRING"
 	NULL_OBJ         = "NULL"
@@ -25,6 +33,11 @@ const (
 	FUNCTION_OBJ     = "FUNCTION"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	RANGE_OBJ        = "RANGE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 
 	BUILTIN_OBJ = "BUILTIN"
 )
@@ -48,6 +61,30 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// BigInteger holds integers that do not fit in int64, the evaluator
+// demotes results back to Integer as soon as they fit again
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (b *BigInteger) Type() ObjectType { return BIG_INTEGER_OBJ }
+func (b *BigInteger) Inspect() string  { return b.Value.String() }
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows a float as one, `3.0` rather than `3`
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // no fraction, exponent, Inf or NaN
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -62,6 +99,9 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+// Array and Hash are reference values: `let b = a` makes both names
+// share one array, so an index assignment through either is seen by
+// both. Builtins like push and rest return a fresh copy instead
 type Array struct {
 	Elements []Object
 }
@@ -98,17 +138,101 @@ func (r *ReturnValue) Inspect() string {
 
 type Error struct {
 	Message string
+	Pos     token.Position // where the error was raised, if known
+	Stack   []Frame        // calls the error left, innermost first
+	GoStack string         // set for internal errors recovered from a Go panic
+	Thrown  Object         // the value given to `throw`, nil for runtime errors
+}
+
+// Frame is a call of Function made at CallPos
+type Frame struct {
+	Function string // function name or <anonymous>
+	CallPos  token.Position
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
 	return "ERROR: " + e.Message
 }
 
+// Traceback formats the error Python style, most recent call last:
+//
+//	Traceback (most recent call last):
+//	  script.mk:9:1, in <main>
+//	  script.mk:5:3, in outer
+//	  script.mk:2:10, in inner
+//	ERROR: division by zero
+//
+// A line that repeats more than three times in a row is written three
+// times and then counted, like a deep recursion would be
+func (e *Error) Traceback() string {
+	if len(e.Stack) == 0 {
+		return e.Inspect()
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Traceback (most recent call last):\n")
+	lines := e.traceLines()
+	for i := 0; i < len(lines); {
+		n := 1
+		for i+n < len(lines) && lines[i+n] == lines[i] {
+			n++
+		}
+		for j := 0; j < n && j < 3; j++ {
+			out.WriteString("  " + lines[i] + "\n")
+		}
+		if n > 3 {
+			out.WriteString(fmt.Sprintf("  [previous line repeated %d more times]\n", n-3))
+		}
+		i += n
+	}
+	out.WriteString("ERROR: " + e.Message)
+	return out.String()
+}
+
+// traceLines gives one `pos, in function` line per frame, outermost first
+func (e *Error) traceLines() []string {
+	lines := make([]string, 0, len(e.Stack)+1)
+	fn := "<main>"
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		lines = append(lines, fmt.Sprintf("%s, in %s", e.Stack[i].CallPos, fn))
+		fn = e.Stack[i].Function
+	}
+	return append(lines, fmt.Sprintf("%s, in %s", e.Pos, fn))
+}
+
+// Caught is what a `catch (e)` clause binds: the thrown value as is,
+// or for runtime errors a hash with "message" and "stack" keys
+func (e *Error) Caught() Object {
+	if e.Thrown != nil {
+		return e.Thrown
+	}
+
+	lines := e.traceLines()
+	stack := &Array{Elements: make([]Object, len(lines))}
+	for i, line := range lines {
+		stack.Elements[i] = &String{Value: line}
+	}
+
+	h := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for _, p := range []HashPair{
+		{Key: &String{Value: "message"}, Value: &String{Value: e.Message}},
+		{Key: &String{Value: "stack"}, Value: stack},
+	} {
+		h.Pairs[p.Key.(Hashable).HashKey()] = p
+	}
+	return h
+}
+
 type Function struct {
-	Parameters []*ast.Identifier
+	Parameters []ast.Pattern
+	Rest       *ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string // empty for anonymous functions
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -119,6 +243,9 @@ func (f *Function) Inspect() string {
 	for _, p := range f.Parameters {
 		params = append(params, p.String())
 	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
 	out.WriteString("fn")
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
@@ -129,6 +256,55 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// CompiledFunction is a function literal lowered to bytecode by the
+// compiler, the vm runs it as the Fn of a Closure
+type CompiledFunction struct {
+	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	NumLocals    int
+	NumParams    int // without the rest parameter
+	MinArgs      int // parameters up to the last one without a default
+	Rest         bool
+	Name         string   // empty for anonymous functions
+	Source       string   // how the function literal inspects
+	LocalNames   []string // for errors about unset variables
+	FreeNames    []string
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function with the variables it captured,
+// to Monkey code it is a FUNCTION like any other
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return c.Fn.Source }
+
+// Cell holds a variable of the vm, closures share the cells of the
+// variables they capture so that they see later assignments.
+// Value is nil until the variable is bound, until then Outer is the
+// variable of the same name it shadows
+type Cell struct {
+	Value Object
+	Const bool
+	Outer *Cell
+}
+
+// Bound gives the cell a use of c's variable reads and assigns: c
+// itself once bound, before that the cell it shadows
+func (c *Cell) Bound() *Cell {
+	for c.Value == nil && c.Outer != nil {
+		c = c.Outer
+	}
+	return c
+}
+
 type Hashable interface {
 	HashKey() HashKey
 }
@@ -154,6 +330,34 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a BigInteger lives in the same domain as Integer keys,
+// so equal values hash identically whatever their representation
+func (b *BigInteger) HashKey() HashKey {
+	if b.Value.IsInt64() {
+		return (&Integer{Value: b.Value.Int64()}).HashKey()
+	}
+	h := fnv.New64a()
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(b.Value.Bytes())
+
+	return HashKey{Type: INTEGER_OBJ, Value: h.Sum64()}
+}
+
+// HashKey of a whole float is the one of the equal integer,
+// so that `h[1]` and `h[1.0]` find the same pair
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+			return (&Integer{Value: int64(f.Value)}).HashKey()
+		}
+		i, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInteger{Value: i}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -170,11 +374,52 @@ type Hash struct {
 	Pairs map[HashKey]HashPair // hash(key) -> {key: value}
 }
 
+// SortedPairs returns the pairs ordered by key, numbers by value and
+// other keys by type and then text, so that iteration is repeatable
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, p := range h.Pairs {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return keyLess(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func keyLess(a, b Object) bool {
+	x, aNum := numericValue(a)
+	y, bNum := numericValue(b)
+	switch {
+	case aNum && bNum:
+		return x.Cmp(y) < 0
+	case aNum != bNum:
+		return aNum // numbers first
+	case a.Type() != b.Type():
+		return a.Type() < b.Type()
+	}
+	return a.Inspect() < b.Inspect()
+}
+
+func numericValue(o Object) (*big.Float, bool) {
+	switch o := o.(type) {
+	case *Integer:
+		return new(big.Float).SetInt64(o.Value), true
+	case *BigInteger:
+		return new(big.Float).SetInt(o.Value), true
+	case *Float:
+		if !math.IsNaN(o.Value) {
+			return big.NewFloat(o.Value), true
+		}
+	}
+	return nil, false
+}
+
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for _, p := range h.Pairs {
+	for _, p := range h.SortedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			p.Key.Inspect(), p.Value.Inspect()))
 	}
@@ -183,3 +428,42 @@ func (h *Hash) Inspect() string {
 	out.WriteString("}")
 	return out.String()
 }
+
+// Range is the lazy integer sequence made by range(start, end, step),
+// End is excluded
+type Range struct {
+	Start, End, Step int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.End)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// Len is the number of values the range yields
+func (r *Range) Len() int64 {
+	// unsigned so that End - Start cannot overflow
+	var n uint64
+	switch {
+	case r.Step > 0 && r.Start < r.End:
+		n = (uint64(r.End)-uint64(r.Start)-1)/uint64(r.Step) + 1
+	case r.Step < 0 && r.Start > r.End:
+		n = (uint64(r.Start)-uint64(r.End)-1)/(-uint64(r.Step)) + 1
+	}
+	return int64(n)
+}
+
+// Break and Continue carry loop control out of a loop body,
+// like ReturnValue does for functions
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
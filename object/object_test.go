@@ -1,6 +1,11 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -20,3 +25,108 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 2.5}).HashKey() != (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 3.5}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+	if (&Float{Value: 3}).HashKey() != (&Integer{Value: 3}).HashKey() {
+		t.Errorf("whole float and equal integer have different hash keys")
+	}
+	if (&Float{Value: 0}).HashKey() != (&Float{Value: math.Copysign(0, -1)}).HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3, "3.0"},
+		{3.14, "3.14"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("Inspect() wrong. expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestBigIntegerHashKey(t *testing.T) {
+	huge1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	huge2, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	if (&BigInteger{Value: huge1}).HashKey() != (&BigInteger{Value: huge2}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+	if (&BigInteger{Value: huge1}).HashKey() == (&BigInteger{Value: new(big.Int).Neg(huge1)}).HashKey() {
+		t.Errorf("big integers with opposite signs have same hash keys")
+	}
+	if (&BigInteger{Value: big.NewInt(42)}).HashKey() != (&Integer{Value: 42}).HashKey() {
+		t.Errorf("big and small integer with same value have different hash keys")
+	}
+}
+
+func TestRangeLen(t *testing.T) {
+	tests := []struct {
+		r        Range
+		expected int64
+	}{
+		{Range{Start: 0, End: 10, Step: 1}, 10},
+		{Range{Start: 0, End: 10, Step: 3}, 4},
+		{Range{Start: 10, End: 0, Step: -3}, 4},
+		{Range{Start: 5, End: 5, Step: 1}, 0},
+		{Range{Start: 10, End: 0, Step: 1}, 0},
+		{Range{Start: 0, End: 10, Step: -1}, 0},
+		{Range{Start: math.MinInt64, End: math.MaxInt64, Step: math.MaxInt64}, 3},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.Len(); got != tt.expected {
+			t.Errorf("%s: wrong length. expected=%d, got=%d", tt.r.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestHashSortedPairs(t *testing.T) {
+	h := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for _, k := range []Object{
+		&String{Value: "b"}, &Integer{Value: 10}, &Boolean{Value: true}, &String{Value: "a"},
+		&Float{Value: 2.5}, &Integer{Value: -3}, &Boolean{Value: false},
+	} {
+		h.Pairs[k.(Hashable).HashKey()] = HashPair{Key: k, Value: k}
+	}
+
+	var keys []string
+	for _, p := range h.SortedPairs() {
+		keys = append(keys, p.Key.Inspect())
+	}
+	expected := "-3 2.5 10 false true a b"
+	if got := strings.Join(keys, " "); got != expected {
+		t.Errorf("wrong order. expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestHashInspect(t *testing.T) {
+	h := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for i, k := range []Object{&String{Value: "b"}, &Integer{Value: 2}, &String{Value: "a"}, &Integer{Value: 1}} {
+		h.Pairs[k.(Hashable).HashKey()] = HashPair{Key: k, Value: &Integer{Value: int64(i)}}
+	}
+
+	expected := "{1: 3, 2: 1, a: 2, b: 0}"
+	for i := 0; i < 10; i++ {
+		if got := h.Inspect(); got != expected {
+			t.Fatalf("wrong Inspect. expected=%q, got=%q", expected, got)
+		}
+	}
+}
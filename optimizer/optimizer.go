@@ -0,0 +1,311 @@
+// Package optimizer rewrites a parsed program, before it is evaluated,
+// into one that gives the same results with less work:
+//
+//   - prefix and infix expressions on integer, string and boolean
+//     literals are folded into the literal they evaluate to, an
+//     operation that fails, like a division by zero, is left to fail
+//     when it runs, and so is a power too big for an integer literal
+//   - an if expression whose condition is a literal loses the branch
+//     that can never run, as a statement it is replaced by the
+//     statements of the branch that does
+//   - statements after a return, throw, break or continue are dropped
+//
+// Folding uses the operators of the evaluator, so a folded literal is
+// exactly what evaluating the expression would have given.
+package optimizer
+
+import (
+	"math/bits"
+	"strconv"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/token"
+)
+
+// Optimize rewrites program in place and returns it
+func Optimize(program *ast.Program) *ast.Program {
+	program.Statements = statements(program.Statements)
+	return program
+}
+
+// statements optimizes a list of statements that run in order in one
+// scope, a block or the program
+func statements(stmts []ast.Statement) []ast.Statement {
+	out := make([]ast.Statement, 0, len(stmts))
+	for i, s := range stmts {
+		s = statement(s)
+
+		// the value of the list is that of its last statement, so a
+		// last if that runs an empty branch has to stay
+		if body, ok := taken(s); ok && (i < len(stmts)-1 || len(body) > 0) {
+			out = append(out, body...)
+		} else {
+			out = append(out, s)
+		}
+
+		if len(out) > 0 && jumps(out[len(out)-1]) {
+			break
+		}
+	}
+	return out
+}
+
+// taken gives the statements of the branch an if statement runs,
+// ok is false if s is not an if statement with a literal condition
+func taken(s ast.Statement) (body []ast.Statement, ok bool) {
+	es, ok := s.(*ast.ExpressionStatement)
+	if !ok {
+		return nil, false
+	}
+	ie, ok := es.Expression.(*ast.IfExpression)
+	if !ok {
+		return nil, false
+	}
+	con, ok := constant(ie.Condition)
+	switch {
+	case !ok:
+		return nil, false
+	case evaluator.IsTruthy(con):
+		return ie.Consequence.Statements, true
+	case ie.Alternative != nil:
+		return ie.Alternative.Statements, true
+	}
+	return nil, true
+}
+
+// jumps reports whether s always leaves the statements it is in
+func jumps(s ast.Statement) bool {
+	switch s.(type) {
+	case *ast.ReturnStatement, *ast.ThrowStatement,
+		*ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	}
+	return false
+}
+
+func statement(s ast.Statement) ast.Statement {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		s.Value = expression(s.Value)
+		if s.Pattern != nil {
+			pattern(s.Pattern)
+		}
+	case *ast.ReturnStatement:
+		s.ReturnValue = expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		s.Value = expression(s.Value)
+	case *ast.ExpressionStatement:
+		s.Expression = expression(s.Expression)
+	case *ast.BlockStatement:
+		block(s)
+	case *ast.WhileStatement:
+		s.Condition = expression(s.Condition)
+		block(s.Body)
+	case *ast.ForStatement:
+		s.Iterable = expression(s.Iterable)
+		block(s.Body)
+	}
+	return s
+}
+
+func block(b *ast.BlockStatement) {
+	if b != nil {
+		b.Statements = statements(b.Statements)
+	}
+}
+
+// pattern optimizes the defaults in pat, the literals it matches
+// are left as they are
+func pattern(pat ast.Pattern) {
+	switch pat := pat.(type) {
+	case *ast.DefaultPattern:
+		pat.Default = expression(pat.Default)
+		pattern(pat.Target)
+	case *ast.ArrayPattern:
+		for _, el := range pat.Elements {
+			pattern(el)
+		}
+	case *ast.HashPattern:
+		for _, p := range pat.Pairs {
+			pattern(p.Value)
+		}
+	}
+}
+
+// expression gives the optimized form of e, which may be e itself
+func expression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		e.Right = expression(e.Right)
+		if right, ok := constant(e.Right); ok {
+			return fold(e, evaluator.Prefix(e.Operator, right))
+		}
+	case *ast.InfixExpression:
+		e.Left = expression(e.Left)
+		e.Right = expression(e.Right)
+		return infix(e)
+	case *ast.IfExpression:
+		// pruning first leaves the branch that never runs as it is
+		e.Condition = expression(e.Condition)
+		prune(e)
+		block(e.Consequence)
+		block(e.Alternative)
+	case *ast.AssignExpression:
+		e.Target = expression(e.Target)
+		e.Value = expression(e.Value)
+	case *ast.InterpolatedString:
+		for i, p := range e.Parts {
+			e.Parts[i] = expression(p)
+		}
+	case *ast.TryExpression:
+		block(e.Block)
+		block(e.Catch)
+		block(e.Finally)
+	case *ast.FunctionLiteral:
+		for _, p := range e.Parameters {
+			pattern(p)
+		}
+		block(e.Body)
+	case *ast.SpreadExpression:
+		e.Value = expression(e.Value)
+	case *ast.CallExpression:
+		e.Function = expression(e.Function)
+		for i, a := range e.Arguments {
+			e.Arguments[i] = expression(a)
+		}
+	case *ast.ArrayLiteral:
+		for i, el := range e.Elements {
+			e.Elements[i] = expression(el)
+		}
+	case *ast.IndexExpression:
+		e.Left = expression(e.Left)
+		e.Index = expression(e.Index)
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(e.Pairs))
+		for k, v := range e.Pairs {
+			pairs[expression(k)] = expression(v)
+		}
+		e.Pairs = pairs
+	case *ast.MatchExpression:
+		e.Subject = expression(e.Subject)
+		for _, arm := range e.Arms {
+			for _, p := range arm.Patterns {
+				pattern(p)
+			}
+			if arm.Guard != nil {
+				arm.Guard = expression(arm.Guard)
+			}
+			block(arm.Body)
+		}
+	}
+	return e
+}
+
+// infix folds e when its operands decide it. With a literal on the
+// left && and || come down to one of their operands, like evaluating
+// them would
+func infix(e *ast.InfixExpression) ast.Expression {
+	left, ok := constant(e.Left)
+	if !ok {
+		return e
+	}
+	switch {
+	case e.Operator == "&&" && !evaluator.IsTruthy(left):
+		return e.Left
+	case e.Operator == "||" && evaluator.IsTruthy(left):
+		return e.Left
+	case e.Operator == "&&" || e.Operator == "||":
+		return e.Right
+	}
+
+	right, ok := constant(e.Right)
+	if !ok || tooBig(e.Operator, left, right) {
+		return e
+	}
+	return fold(e, evaluator.Infix(e.Operator, left, right))
+}
+
+// maxFoldedBits bounds the integers folding works out, one that needs
+// more has no literal and could take long to get to
+const maxFoldedBits = 64
+
+// tooBig reports whether left ** right is an integer above the bound
+func tooBig(op string, left, right object.Object) bool {
+	l, ok := left.(*object.Integer)
+	r, isInt := right.(*object.Integer)
+	if op != "**" || !ok || !isInt || r.Value <= 1 {
+		return false
+	}
+	n := uint64(l.Value)
+	if l.Value < 0 {
+		n = -n
+	}
+	return n > 1 && r.Value > maxFoldedBits/int64(bits.Len64(n)-1)
+}
+
+// prune drops the branch of ie that its condition never takes, the
+// branch that is left becomes the consequence
+func prune(ie *ast.IfExpression) {
+	con, ok := constant(ie.Condition)
+	switch {
+	case !ok:
+	case evaluator.IsTruthy(con):
+		ie.Alternative = nil
+	case ie.Alternative != nil:
+		ie.Condition = literal(evaluator.TRUE, ie.Condition)
+		ie.Consequence, ie.Alternative = ie.Alternative, nil
+	default:
+		// false without an alternative is null, the consequence never runs
+		ie.Consequence = &ast.BlockStatement{Token: ie.Consequence.Token,
+			RBrace: ie.Consequence.RBrace}
+	}
+}
+
+// constant gives the value of e if it is an integer, string or
+// boolean literal
+func constant(e ast.Expression) (object.Object, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: e.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: e.Value}, true
+	case *ast.Boolean:
+		if e.Value {
+			return evaluator.TRUE, true
+		}
+		return evaluator.FALSE, true
+	}
+	return nil, false
+}
+
+// fold replaces e by the literal of val, e stays if val is an error
+// or a value that has no literal of its own
+func fold(e ast.Expression, val object.Object) ast.Expression {
+	if lit := literal(val, e); lit != nil {
+		return lit
+	}
+	return e
+}
+
+// literal gives the literal of val spanning the source of e,
+// nil if val is not an integer, string or boolean
+func literal(val object.Object, e ast.Expression) ast.Expression {
+	tok := token.Token{Pos: e.Pos(), End: e.End()}
+	switch val := val.(type) {
+	case *object.Integer:
+		tok.Type, tok.Literal = token.INT, strconv.FormatInt(val.Value, 10)
+		return &ast.IntegerLiteral{Token: tok, Value: val.Value}
+	case *object.String:
+		tok.Type, tok.Literal = token.STRING, val.Value
+		return &ast.StringLiteral{Token: tok, Value: val.Value}
+	case *object.Boolean:
+		tok.Type, tok.Literal = token.FALSE, "false"
+		if val.Value {
+			tok.Type, tok.Literal = token.TRUE, "true"
+		}
+		return &ast.Boolean{Token: tok, Value: val.Value}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/parser"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // String of the optimized program
+	}{
+		// folding
+		{`60 * 60 * 24`, `86400`},
+		{`1 + 2 * 3 - 4`, `3`},
+		{`-5 + 2`, `-3`},
+		{`-(2 * 3)`, `-6`},
+		{`10 / 3`, `3`},
+		{`"a" + "b" + "c"`, `abc`},
+		{`1 < 2`, `true`},
+		{`"a" == "b"`, `false`},
+		{`!true`, `false`},
+		{`!!5`, `true`},
+		{`true == !false`, `true`},
+		{`let x = 2 * 3; x * (4 + 5)`, `let x = 6;(x * 9)`},
+		{`fn(a) { a + 1 * 2 }`, `fn(a) (a + 2)`},
+		{`[1 + 1, "x" + "y"][0 + 1]`, `([2, xy][1])`},
+		{`true && x`, `x`},
+		{`false && x`, `false`},
+		{`0 || 1 + 1`, `0`},
+		{`false || "y" + "z"`, `yz`},
+		{`x && true`, `(x && true)`},
+
+		// folding keeps what fails for the evaluation to report
+		{`1 / 0`, `(1 / 0)`},
+		{`(2 * 3) / (1 - 1)`, `(6 / 0)`},
+		{`-"a"`, `(-a)`},
+		{`1 + "a"`, `(1 + a)`},
+		{`2.5 * 2`, `(2.5 * 2)`},
+		{`9223372036854775807 + 1`, `(9223372036854775807 + 1)`},
+		{`2 ** 10`, `1024`},
+		{`(-3) ** 3`, `-27`},
+		{`1 ** 100000000000`, `1`},
+		{`2 ** 100000000000`, `(2 ** 100000000000)`},
+		{`-9223372036854775807 - 1 ** 2`, `-9223372036854775808`},
+		{`1 + x`, `(1 + x)`},
+
+		// dead branches
+		{`if (true) { 1 } else { 2 }`, `1`},
+		{`if (false) { 1 } else { 2 }`, `2`},
+		{`if (1 > 2) { 1 }`, `iffalse `},
+		{`if (false) { 1 }; 3`, `3`},
+		{`let y = if (2 > 1) { "yes" } else { "no" }; y`, `let y = iftrue yes;y`},
+		{`let y = if (1 > 2) { "yes" } else { "no" }; y`, `let y = iftrue no;y`},
+		{`if (x) { 1 + 1 } else { 2 + 2 }`, `ifx 2else4`},
+		{`if (true) { let a = 1; a } else { 2 }; 5`, `let a = 1;a5`},
+		{`if (false) { 2 ** 100000000000 }`, `iffalse `},
+		{`if (true) { 1 } else { 10 ** 10000000 }`, `1`},
+		{`let y = if (1 > 2) { 7 ** 100000000 } else { 3 }; y`, `let y = iftrue 3;y`},
+
+		// unreachable statements
+		{`fn() { return 1; 2; 3 }`, `fn() return 1;`},
+		{`fn() { throw "e"; 2 }`, `fn() throw e;`},
+		{`while (x) { break; x = 1 }`, `whilex break;`},
+		{`for (i in a) { continue; i }`, `for (i in a) continue;`},
+		{`fn() { if (true) { return 1; } 2 }`, `fn() return 1;`},
+		{`return 1; let z = 2;`, `return 1;`},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		if got := Optimize(program).String(); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFoldedLiterals(t *testing.T) {
+	program := Optimize(parse(t, "let x = 6 *\n 7;"))
+	lit, ok := program.Statements[0].(*ast.LetStatement).Value.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("not folded into an IntegerLiteral, got %T",
+			program.Statements[0].(*ast.LetStatement).Value)
+	}
+	if lit.Value != 42 || lit.TokenLiteral() != "42" {
+		t.Errorf("wrong literal, got %d (%q)", lit.Value, lit.TokenLiteral())
+	}
+	if p, e := lit.Pos(), lit.End(); p.Line != 1 || p.Column != 9 || e.Line != 2 || e.Column != 3 {
+		t.Errorf("literal should span the expression, got %s to %s", lit.Pos(), lit.End())
+	}
+}
+
+// TestSameOutput evaluates each program as parsed and as optimized,
+// resolved as well the way scripts are run, all must give the same
+// value or the same traceback
+func TestSameOutput(t *testing.T) {
+	tests := []string{
+		`let day = 60 * 60 * 24; day * 7`,
+		`let f = fn(n) { if (true) { return n * (2 + 3); } n }; f(4)`,
+		`let f = fn(n) { if (1 > 2) { return 0; } else { n + 1 } }; f(4)`,
+		`if (false) { 1 }`,
+		`let a = 1; if (false) { 1 }`,
+		`let x = if (true) { }; x`,
+		`let s = 0; let i = 0; while (i < 10) { i += 1; if (true) { continue; } s += i } [s, i]`,
+		`let s = 0; for (i in [1, 2, 3]) { s += i * (1 + 1); if (false) { break; } } s`,
+		`let g = fn() { return "a" + "b"; puts("never") }; g()`,
+		`"x" + 1`,
+		`let f = fn() { 10 / (5 - 5) }; f()`,
+		`let f = fn() { 1 + -true }; f()`,
+		`true && 1 / 0`,
+		`false || [1, 2 * 2][1]`,
+		`let {"k": v = 2 * 21} = {}; v`,
+		`match (3 * 2) { 6 => "six" + "!", _ => "other" }`,
+		`try { throw "a" + "b"; 1 } catch (e) { e }`,
+		`let f = fn(n) { if (n > 0) { return f(n - 1); } 100 - 1 }; f(3)`,
+		`2 ** 100000000000`,
+		`(-9223372036854775807 - 1) ** 100000000000`,
+		`let f = fn() { g }; let r = "ran"; if (false) { let g = 2 }`,
+		`let f = fn() { g }; if (false) { let g = 2 }; f()`,
+		`let f = fn() { let h = fn() { g }; return 1; let g = 2 }; f()`,
+	}
+
+	for _, input := range tests {
+		expected := eval(parse(t, input))
+		if got := eval(Optimize(parse(t, input))); got != expected {
+			t.Errorf("%q: expected %s, got %s", input, expected, got)
+		}
+		if got := eval(Optimize(resolve(t, parse(t, input)))); got != expected {
+			t.Errorf("%q resolved: expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.NewFile("script.mk", input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parse errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func resolve(t *testing.T, program *ast.Program) *ast.Program {
+	t.Helper()
+	if diags := evaluator.Resolve(program); len(diags) != 0 {
+		t.Fatalf("resolve errors: %v", diags)
+	}
+	return program
+}
+
+func eval(program *ast.Program) string {
+	val := evaluator.Eval(program, object.NewEnvirnment())
+	switch val := val.(type) {
+	case nil:
+		return "<nil>"
+	case *object.Error:
+		return val.Traceback()
+	}
+	return val.Inspect()
+}
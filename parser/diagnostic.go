@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/clg0803/circus/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// MarshalText lets Severity show up as "error" / "warning" in JSON
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "error":
+		*s = SeverityError
+	case "warning":
+		*s = SeverityWarning
+	default:
+		return fmt.Errorf("unknown severity %q", text)
+	}
+	return nil
+}
+
+// Code identifies the kind of a diagnostic, stable across releases
+type Code string
+
+const (
+	ErrUnexpectedToken  Code = "E001" // expected one token, found another
+	ErrExpectedExpr     Code = "E002" // no expression can start with the token
+	ErrInvalidInteger   Code = "E003" // integer literal out of range
+	ErrIllegalCharacter Code = "E004" // lexer produced an ILLEGAL token
+	ErrUnterminated     Code = "E005" // comment or string not closed
+	ErrInvalidFloat     Code = "E006" // float literal out of range
+	ErrInvalidEscape    Code = "E007" // unknown escape sequence in a string
+	ErrInvalidTarget    Code = "E008" // left side of an assignment is not assignable
+	ErrOutsideLoop      Code = "E009" // break or continue outside of a loop
+	ErrInvalidPattern   Code = "E010" // not a valid match pattern
+	ErrUndefined        Code = "E011" // name bound by no scope and no builtin
+	ErrParameterOrder   Code = "E012" // parameter without a default after one with
+)
+
+// Diagnostic is a single problem found while parsing,
+// Pos and End span the offending source text
+type Diagnostic struct {
+	Severity Severity          `json:"severity"`
+	Code     Code              `json:"code"`
+	Message  string            `json:"message"`
+	Pos      token.Position    `json:"pos"`
+	End      token.Position    `json:"end"`
+	Expected []token.TokenType `json:"expected,omitempty"`
+	Found    token.TokenType   `json:"found,omitempty"`
+	Hint     string            `json:"hint,omitempty"`
+}
+
+// String formats the diagnostic on one line: `file:line:col: error[E001]: msg`
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Pos, d.Severity, d.Code, d.Message)
+}
+
+func (d Diagnostic) Error() string { return d.String() }
+
+// Render prints the diagnostic followed by the offending line of source
+// with the span underlined by carets
+//
+//	script.mk:2:5: error[E001]: expected next token to be IDENT, got = instead
+//	  2 | let = 7;
+//	    |     ^
+func (d Diagnostic) Render(source string) string {
+	var out bytes.Buffer
+	out.WriteString(d.String())
+	out.WriteString("\n")
+
+	lines := strings.Split(source, "\n")
+	if d.Pos.IsValid() && d.Pos.Line <= len(lines) {
+		line := strings.TrimRight(lines[d.Pos.Line-1], "\r")
+		gutter := fmt.Sprintf("%d", d.Pos.Line)
+		blank := strings.Repeat(" ", len(gutter))
+
+		fmt.Fprintf(&out, "  %s | %s\n", gutter, line)
+		fmt.Fprintf(&out, "  %s | %s%s\n", blank,
+			caretIndent(line, d.Pos.Column), caretUnderline(line, d.Pos, d.End))
+	}
+
+	if d.Hint != "" {
+		fmt.Fprintf(&out, "  = hint: %s\n", d.Hint)
+	}
+
+	return out.String()
+}
+
+// caretIndent keeps tabs so the caret lines up under the source,
+// columns count runes
+func caretIndent(line string, column int) string {
+	var b strings.Builder
+	i := 1
+	for _, r := range line {
+		if i >= column {
+			break
+		}
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+		i++
+	}
+	return b.String()
+}
+
+func caretUnderline(line string, pos, end token.Position) string {
+	n := 1
+	width := utf8.RuneCountInString(line)
+	if end.Line == pos.Line && end.Column > pos.Column {
+		n = end.Column - pos.Column
+	} else if end.Line > pos.Line && width >= pos.Column {
+		n = width - pos.Column + 1
+	}
+	return strings.Repeat("^", n)
+}
+
+// RenderAll renders every diagnostic against the same source
+func RenderAll(source string, diags []Diagnostic) string {
+	var out bytes.Buffer
+	for _, d := range diags {
+		out.WriteString(d.Render(source))
+	}
+	return out.String()
+}
@@ -0,0 +1,221 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/token"
+)
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		code     Code
+		message  string
+		line     int
+		column   int
+		expected []token.TokenType
+		found    token.TokenType
+	}{
+		{"let = 5;", ErrUnexpectedToken,
+			"expected next token to be IDENT, got = instead", 1, 5,
+			[]token.TokenType{token.IDENT}, token.ASSIGN},
+		{"let x 5;", ErrUnexpectedToken,
+			"expected next token to be =, got 5 instead", 1, 7,
+			[]token.TokenType{token.ASSIGN}, token.INT},
+		{"add(1, 2", ErrUnexpectedToken,
+			"expected next token to be ), got EOF instead", 1, 9,
+			[]token.TokenType{token.RPAREN}, token.EOF},
+		{"\n  * 5", ErrExpectedExpr,
+			"expected an expression, got * instead", 2, 3,
+			nil, token.ASTERISK},
+		{"09", ErrInvalidInteger,
+			`could not parse "09" as Integer`, 1, 1,
+			nil, token.INT},
+		{`{"a" 1}`, ErrUnexpectedToken,
+			"expected next token to be :, got 1 instead", 1, 6,
+			[]token.TokenType{token.COLON}, token.INT},
+		{"let x = 1: puts(x)", ErrExpectedExpr,
+			"expected an expression, got : instead", 1, 10,
+			nil, token.COLON},
+		{"let x = @;", ErrIllegalCharacter,
+			`illegal character "@"`, 1, 9,
+			nil, token.ILLEGAL},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected diagnostics", tt.input)
+			continue
+		}
+		d := errors[0]
+		if d.Severity != SeverityError {
+			t.Errorf("%q: wrong severity. got=%s", tt.input, d.Severity)
+		}
+		if d.Code != tt.code {
+			t.Errorf("%q: wrong code. expected=%s, got=%s", tt.input, tt.code, d.Code)
+		}
+		if d.Message != tt.message {
+			t.Errorf("%q: wrong message. expected=%q, got=%q", tt.input, tt.message, d.Message)
+		}
+		if d.Pos.Line != tt.line || d.Pos.Column != tt.column {
+			t.Errorf("%q: wrong position. expected=%d:%d, got=%d:%d",
+				tt.input, tt.line, tt.column, d.Pos.Line, d.Pos.Column)
+		}
+		if !equalTypes(d.Expected, tt.expected) {
+			t.Errorf("%q: wrong expected set. expected=%v, got=%v", tt.input, tt.expected, d.Expected)
+		}
+		if d.Found != tt.found {
+			t.Errorf("%q: wrong found token. expected=%s, got=%s", tt.input, tt.found, d.Found)
+		}
+	}
+}
+
+func equalTypes(a, b []token.TokenType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	input := "let x = 5;\n\tlet = foo;\n"
+	p := New(lexer.NewFile("script.mk", input))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected diagnostics")
+	}
+
+	expected := "script.mk:2:6: error[E001]: expected next token to be IDENT, got = instead\n" +
+		"  2 | \tlet = foo;\n" +
+		"    | \t    ^\n"
+	if got := errors[0].Render(input); got != expected {
+		t.Errorf("wrong rendering.\nexpected=%q\ngot=     %q", expected, got)
+	}
+
+	d := Diagnostic{
+		Pos:  token.Position{Line: 1, Column: 5},
+		End:  token.Position{Line: 1, Column: 8},
+		Code: ErrExpectedExpr, Message: "oops", Hint: "try harder",
+	}
+	expected = "1:5: error[E002]: oops\n" +
+		"  1 | let foo\n" +
+		"    |     ^^^\n" +
+		"  = hint: try harder\n"
+	if got := d.Render("let foo"); got != expected {
+		t.Errorf("wrong rendering.\nexpected=%q\ngot=     %q", expected, got)
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	p := New(lexer.NewFile("script.mk", "let = 5;"))
+	p.ParseProgram()
+
+	data, err := json.Marshal(p.Errors()[0])
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %s", err)
+	}
+	expected := `{"severity":"error","code":"E001",` +
+		`"message":"expected next token to be IDENT, got = instead",` +
+		`"pos":{"file":"script.mk","offset":4,"line":1,"column":5},` +
+		`"end":{"file":"script.mk","offset":5,"line":1,"column":6},` +
+		`"expected":["IDENT"],"found":"="}`
+	if string(data) != expected {
+		t.Errorf("wrong json.\nexpected=%s\ngot=     %s", expected, data)
+	}
+
+	var back Diagnostic
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s", err)
+	}
+	if back.String() != p.Errors()[0].String() {
+		t.Errorf("round trip changed diagnostic. got=%s", back)
+	}
+}
+
+func TestUnterminatedCommentDiagnostic(t *testing.T) {
+	p := New(lexer.New("let x = 1; // fine\nlet y = /* oops"))
+	program := p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. expected=1, got=%d", len(errors))
+	}
+	if errors[0].Code != ErrUnterminated || errors[0].Message != "unterminated block comment" {
+		t.Errorf("wrong diagnostic. got=%s", errors[0])
+	}
+	if errors[0].Pos.Line != 2 || errors[0].Pos.Column != 9 {
+		t.Errorf("wrong position. got=%s", errors[0].Pos)
+	}
+	if len(program.Statements) != 1 {
+		t.Errorf("wrong number of statements. got=%d", len(program.Statements))
+	}
+}
+
+func TestStringDiagnostics(t *testing.T) {
+	input := "let a = \"bad \\q\";\nlet b = \"open\nlet c = `raw\n"
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	expected := []struct {
+		code    Code
+		message string
+		line    int
+		column  int
+	}{
+		{ErrInvalidEscape, `invalid escape sequence "\\q"`, 1, 14},
+		{ErrUnterminated, "unterminated string", 2, 9},
+		{ErrUnterminated, "unterminated raw string", 3, 9},
+	}
+
+	errors := p.Errors()
+	if len(errors) != len(expected) {
+		t.Fatalf("wrong number of errors. expected=%d, got=%d (%v)",
+			len(expected), len(errors), errors)
+	}
+	for i, tt := range expected {
+		d := errors[i]
+		if d.Code != tt.code || d.Message != tt.message {
+			t.Errorf("errors[%d] wrong. got=%s", i, d)
+		}
+		if d.Pos.Line != tt.line || d.Pos.Column != tt.column {
+			t.Errorf("errors[%d] wrong position. expected=%d:%d, got=%s",
+				i, tt.line, tt.column, d.Pos)
+		}
+	}
+	if len(program.Statements) != 0 {
+		t.Errorf("wrong number of statements. got=%d", len(program.Statements))
+	}
+
+	rendered := errors[0].Render(input)
+	if want := "  1 | let a = \"bad \\q\";\n    |              ^^\n"; !strings.Contains(rendered, want) {
+		t.Errorf("wrong rendering. got=%q", rendered)
+	}
+	if want := "  2 | let b = \"open\n    |         ^^^^^\n"; !strings.Contains(errors[1].Render(input), want) {
+		t.Errorf("wrong rendering. got=%q", errors[1].Render(input))
+	}
+	for _, open := range []string{`"abc`, "`abc"} {
+		p := New(lexer.New(open))
+		p.ParseProgram()
+		if want := "  1 | " + open + "\n    | ^^^^\n"; !strings.Contains(p.Errors()[0].Render(open), want) {
+			t.Errorf("wrong rendering. got=%q", p.Errors()[0].Render(open))
+		}
+	}
+	d := Diagnostic{Pos: token.Position{Line: 1, Column: 9}, End: token.Position{Line: 1, Column: 11}}
+	if want := "  1 | let é = 日本\n    |         ^^\n"; !strings.Contains(d.Render("let é = 日本"), want) {
+		t.Errorf("wrong unicode rendering. got=%q", d.Render("let é = 日本"))
+	}
+}
@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/clg0803/circus/ast"
 	"github.com/clg0803/circus/lexer"
@@ -13,11 +16,19 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = += -= *= /=
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // < or >
+	BIT_OR      // |
+	BIT_XOR     // ^
+	BIT_AND     // &
+	SHIFT       // << or >>
 	SUM         //+
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // ** binds tighter than prefix: -2 ** 2 == -(2 ** 2)
 	CALL        // func call
 	INDEX       // array[index]
 )
@@ -25,16 +36,32 @@ const (
 // parser/parser.go
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              LOGICAL_OR,
+	token.AND:             LOGICAL_AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.BIT_OR:          BIT_OR,
+	token.BIT_XOR:         BIT_XOR,
+	token.BIT_AND:         BIT_AND,
+	token.SHL:             SHIFT,
+	token.SHR:             SHIFT,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.POW:             POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type (
@@ -44,7 +71,18 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []Diagnostic
+
+	// panicking is set by the first error in a statement and cleared once
+	// the parser has synchronized, follow-on errors are dropped meanwhile
+	panicking bool
+
+	// loopDepth counts the loops around the current statement,
+	// a function body starts again from zero
+	loopDepth int
+
+	// braces counts the '{' not yet closed up to and including curToken
+	braces int
 
 	curToken  token.Token
 	peekToken token.Token
@@ -64,7 +102,7 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []Diagnostic{},
 	}
 
 	p.nextToken()
@@ -75,13 +113,17 @@ func New(l *lexer.Lexer) *Parser {
 
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerIdentifier)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
@@ -94,25 +136,100 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POW, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 
 	return p
 }
 
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []Diagnostic {
 	return p.errors
 }
 
+// errorAt records an error diagnostic spanning tok
+func (p *Parser) errorAt(tok token.Token, code Code, format string, a ...interface{}) *Diagnostic {
+	if p.panicking {
+		return &Diagnostic{} // a cascade of the reported error
+	}
+	p.panicking = true
+	p.errors = append(p.errors, Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Found:    tok.Type,
+	})
+	return &p.errors[len(p.errors)-1]
+}
+
 func (p *Parser) peekErrors(t token.TokenType) {
-	m := fmt.Sprintf("expected next token to be %s, got %s instead\n",
-		t, p.curToken.Type)
-	p.errors = append(p.errors, m)
+	if p.peekTokenIs(token.ILLEGAL) {
+		p.illegalError(p.peekToken)
+		return
+	}
+	d := p.errorAt(p.peekToken, ErrUnexpectedToken,
+		"expected next token to be %s, got %s instead", t, describe(p.peekToken))
+	d.Expected = []token.TokenType{t}
+	if p.peekTokenIs(token.EOF) {
+		d.Hint = "the input ended early, is a closing bracket missing?"
+	}
+}
+
+// illegalError reports an ILLEGAL token, the lexer leaves the
+// offending text in the literal so its first char tells what went wrong
+func (p *Parser) illegalError(tok token.Token) {
+	switch {
+	case strings.HasPrefix(tok.Literal, "/*"):
+		d := p.errorAt(tok, ErrUnterminated, "unterminated block comment")
+		d.Hint = "block comments nest, every /* needs its own */"
+	case strings.HasPrefix(tok.Literal, `"`):
+		d := p.errorAt(tok, ErrUnterminated, "unterminated string")
+		d.Hint = "use a `raw string` to span several lines"
+	case strings.HasPrefix(tok.Literal, "`"):
+		p.errorAt(tok, ErrUnterminated, "unterminated raw string")
+	case strings.HasPrefix(tok.Literal, `\`) && len(tok.Literal) > 1:
+		d := p.errorAt(tok, ErrInvalidEscape, "invalid escape sequence %q", tok.Literal)
+		d.Hint = `known escapes are \n \t \r \0 \\ \" \' \$ \xHH \uHHHH \u{H...}`
+	default:
+		p.errorAt(tok, ErrIllegalCharacter, "illegal character %q", tok.Literal)
+	}
+}
+
+// describe names a token for messages, `EOF` or the literal text
+func describe(tok token.Token) string {
+	if tok.Type == token.EOF {
+		return "EOF"
+	}
+	return tok.Literal
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.braces++
+	case token.RBRACE:
+		p.braces--
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -120,40 +237,108 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
-		s := p.parseStatement()
-		program.Statements = append(program.Statements, s)
+		if s := p.parseStatement(); s != nil {
+			program.Statements = append(program.Statements, s)
+		}
 		p.nextToken()
 	}
 
 	return program
 }
 
+// parseStatement returns nil for a statement that failed to parse,
+// leaving curToken on the last token of it
 func (p *Parser) parseStatement() ast.Statement {
+	start := p.braces
+	if p.curTokenIs(token.LBRACE) {
+		start--
+	}
+
+	var s ast.Statement
 	switch p.curToken.Type {
-	case token.LET:
-		return p.parseLetStatement()
+	case token.LET, token.CONST:
+		if ls := p.parseLetStatement(); ls != nil {
+			s = ls
+		}
 	case token.RETURN:
-		return p.parseReturnStatement()
+		if rs := p.parseReturnStatement(); rs != nil {
+			s = rs
+		}
+	case token.THROW:
+		if ts := p.parseThrowStatement(); ts != nil {
+			s = ts
+		}
+	case token.WHILE:
+		if ws := p.parseWhileStatement(); ws != nil {
+			s = ws
+		}
+	case token.FOR:
+		if fs := p.parseForStatement(); fs != nil {
+			s = fs
+		}
+	case token.BREAK, token.CONTINUE:
+		s = p.parseLoopControl()
 	default:
-		return p.parseExpressionStatement()
+		if es := p.parseExpressionStatement(); es != nil {
+			s = es
+		}
+	}
+
+	if p.panicking {
+		p.synchronize(start)
+		return nil
+	}
+	return s
+}
+
+// synchronize skips what is left of a broken statement that started
+// with start braces open. Once the braces the statement opened are
+// closed again it stops on a ';' or before a keyword starting a
+// statement or an unmatched '}' so that the next statement starts on a
+// clean boundary
+func (p *Parser) synchronize(start int) {
+	p.panicking = false
+
+	for !p.curTokenIs(token.EOF) {
+		if p.braces <= start {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.CONST, token.RETURN, token.THROW,
+				token.WHILE, token.FOR, token.BREAK, token.CONTINUE,
+				token.RBRACE, token.EOF:
+				return
+			}
+		}
+		p.nextToken()
 	}
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	s := &ast.LetStatement{Token: p.curToken}
 
-	if !p.exceptPeek(token.IDENT) {
-		return nil
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		if s.Pattern = p.parsePattern(); s.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.exceptPeek(token.IDENT) {
+			return nil
+		}
+		s.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	}
 
-	s.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
 	if !p.exceptPeek(token.ASSIGN) {
 		return nil
 	}
 
 	p.nextToken()
 	s.Value = p.parseExpression(LOWEST)
+	if fl, ok := s.Value.(*ast.FunctionLiteral); ok && s.Name != nil {
+		fl.Name = s.Name.Value
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -210,6 +395,101 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return s
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	s := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+
+	s.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return s
+}
+
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	s := &ast.WhileStatement{Token: p.curToken}
+	if !p.exceptPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	s.Condition = p.parseExpression(LOWEST)
+
+	if !p.exceptPeek(token.RPAREN) || !p.exceptPeek(token.LBRACE) {
+		return nil
+	}
+	s.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return s
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	s := &ast.ForStatement{Token: p.curToken}
+	if !p.exceptPeek(token.LPAREN) || !p.exceptPeek(token.IDENT) {
+		return nil
+	}
+	s.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.exceptPeek(token.IDENT) {
+			return nil
+		}
+		s.Key = s.Value
+		s.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.exceptPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	s.Iterable = p.parseExpression(LOWEST)
+
+	if !p.exceptPeek(token.RPAREN) || !p.exceptPeek(token.LBRACE) {
+		return nil
+	}
+	s.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return s
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
+// parseLoopControl parses break and continue, both only make sense
+// inside a loop of the same function
+func (p *Parser) parseLoopControl() ast.Statement {
+	var s ast.Statement
+	if p.curTokenIs(token.BREAK) {
+		s = &ast.BreakStatement{Token: p.curToken}
+	} else {
+		s = &ast.ContinueStatement{Token: p.curToken}
+	}
+
+	if p.loopDepth == 0 {
+		p.errorAt(p.curToken, ErrOutsideLoop, "%s outside of a loop", p.curToken.Literal)
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return s
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	s := &ast.ExpressionStatement{Token: p.curToken}
 	s.Expression = p.parseExpression(LOWEST)
@@ -228,9 +508,14 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		return nil
 	}
 	leftExp := prefix()
+	if leftExp == nil {
+		return nil
+	}
 
 	// KEY !!!
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	// once an error was reported the rest of the statement is skipped by
+	// synchronize, so the half built leftExp is not extended any further
+	for !p.panicking && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
@@ -245,8 +530,16 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.illegalError(p.curToken)
+		return
+	}
+	d := p.errorAt(p.curToken, ErrExpectedExpr,
+		"expected an expression, got %s instead", describe(p.curToken))
+	switch t {
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		d.Hint = "unbalanced " + string(t)
+	}
 }
 
 // ---------------------------------------
@@ -261,9 +554,32 @@ func (p *Parser) parseIntegerIdentifier() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	v, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if b, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			return &ast.BigIntegerLiteral{Token: p.curToken, Value: b}
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as Integer \n", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		d := p.errorAt(p.curToken, ErrInvalidInteger,
+			"could not parse %q as Integer", p.curToken.Literal)
+		if strings.HasPrefix(p.curToken.Literal, "0") {
+			d.Hint = "a leading 0 makes an octal literal"
+		}
+		return nil
+	}
+
+	lit.Value = v
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	v, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorAt(p.curToken, ErrInvalidFloat,
+			"could not parse %q as Float", p.curToken.Literal)
 		return nil
 	}
 
@@ -277,6 +593,31 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 		Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	s := &ast.InterpolatedString{Token: p.curToken}
+	s.Parts = append(s.Parts, p.parseStringLiteral())
+
+	for !p.curTokenIs(token.STRING_TAIL) {
+		p.nextToken()
+		e := p.parseExpression(LOWEST)
+		if e == nil {
+			return nil
+		}
+		s.Parts = append(s.Parts, e)
+
+		if !p.peekTokenIs(token.STRING_MID) && !p.peekTokenIs(token.STRING_TAIL) {
+			d := p.errorAt(p.peekToken, ErrUnexpectedToken,
+				"expected } to close ${, got %s instead", describe(p.peekToken))
+			d.Expected = []token.TokenType{token.RBRACE}
+			return nil
+		}
+		p.nextToken()
+		s.Parts = append(s.Parts, p.parseStringLiteral())
+	}
+
+	return s
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	e := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -295,12 +636,44 @@ func (p *Parser) parseInfixExpression(l ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	if p.curTokenIs(token.POW) {
+		precedence-- // right associative: 2 ** 3 ** 2 == 2 ** (3 ** 2)
+	}
 	p.nextToken() // eat op
 	e.Right = p.parseExpression(precedence)
 
 	return e
 }
 
+// parseAssignExpression is right associative: a = b = 1 assigns 1 to both
+func (p *Parser) parseAssignExpression(l ast.Expression) ast.Expression {
+	e := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   l,
+	}
+
+	if p.panicking || l == nil {
+		return nil // l may be half built by an error already reported
+	}
+
+	switch l.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		d := p.errorAt(p.curToken, ErrInvalidTarget, "cannot assign to %s", l.String())
+		d.Hint = "only variables and index expressions can be assigned"
+		return nil
+	}
+
+	p.nextToken() // eat op
+	e.Value = p.parseExpression(ASSIGN - 1)
+	if e.Value == nil {
+		return nil
+	}
+
+	return e
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -334,6 +707,18 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 
+		// else if: the chained if is the only statement of the alternative
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			tok := p.curToken
+			alt := p.parseIfExpression()
+			if alt == nil {
+				return nil
+			}
+			e.Alternative = wrapInBlock(tok, alt, p.curToken)
+			return e
+		}
+
 		if !p.exceptPeek(token.LBRACE) {
 			return nil
 		}
@@ -344,6 +729,46 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return e
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	e := &ast.TryExpression{Token: p.curToken}
+	if !p.exceptPeek(token.LBRACE) {
+		return nil
+	}
+	e.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.exceptPeek(token.LPAREN) || !p.exceptPeek(token.IDENT) {
+			return nil
+		}
+		e.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.exceptPeek(token.RPAREN) || !p.exceptPeek(token.LBRACE) {
+			return nil
+		}
+		e.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.exceptPeek(token.LBRACE) {
+			return nil
+		}
+		e.Finally = p.parseBlockStatement()
+	}
+
+	if e.Catch == nil && e.Finally == nil {
+		d := p.errorAt(p.peekToken, ErrUnexpectedToken,
+			"expected catch or finally after try block, got %s instead",
+			describe(p.peekToken))
+		d.Expected = []token.TokenType{token.CATCH, token.FINALLY}
+		return nil
+	}
+
+	return e
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	b := &ast.BlockStatement{Token: p.curToken}
 	b.Statements = []ast.Statement{}
@@ -351,10 +776,12 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		s := p.parseStatement()
-		b.Statements = append(b.Statements, s)
+		if s := p.parseStatement(); s != nil {
+			b.Statements = append(b.Statements, s)
+		}
 		p.nextToken()
 	}
+	b.RBrace = p.curToken
 
 	return b
 }
@@ -364,7 +791,14 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.exceptPeek(token.LPAREN) {
 		return nil
 	}
-	l.Parameters = p.parseFunctionParameters()
+
+	// a loop around the literal reaches neither into the defaults of
+	// its parameters nor into its body
+	depth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = depth }()
+
+	l.Parameters, l.Rest = p.parseFunctionParameters()
 
 	if !p.exceptPeek(token.LBRACE) {
 		return nil
@@ -376,6 +810,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	arr := &ast.ArrayLiteral{Token: p.curToken}
 	arr.Elements = p.parseExpressionList(token.RBRACKET)
+	arr.RBracket = p.curToken
 	return arr
 }
 
@@ -386,11 +821,11 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 		return l
 	}
 	p.nextToken()
-	l = append(l, p.parseExpression(LOWEST))
+	l = append(l, p.parseListElement())
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		l = append(l, p.parseExpression(LOWEST))
+		l = append(l, p.parseListElement())
 	}
 	if !p.exceptPeek(end) {
 		return nil
@@ -398,6 +833,21 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	return l
 }
 
+// parseListElement parses an argument or array element,
+// which may be spread: f(...args)
+func (p *Parser) parseListElement() ast.Expression {
+	if !p.curTokenIs(token.ELLIPSIS) {
+		return p.parseExpression(LOWEST)
+	}
+
+	e := &ast.SpreadExpression{Token: p.curToken}
+	p.nextToken()
+	if e.Value = p.parseExpression(LOWEST); e.Value == nil {
+		return nil
+	}
+	return e
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	h := &ast.HashLiteral{Token: p.curToken}
 	h.Pairs = make(map[ast.Expression]ast.Expression)
@@ -420,38 +870,77 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.exceptPeek(token.RBRACE) {
 		return nil
 	}
+	h.RBrace = p.curToken
 
 	return h
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	i := []*ast.Identifier{}
+// parseFunctionParameters parses `(a, [b, c], d = 1, ...rest)`,
+// the rest parameter is returned apart
+func (p *Parser) parseFunctionParameters() ([]ast.Pattern, *ast.Identifier) {
+	i := []ast.Pattern{}
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return i
-	}
-	p.nextToken()
+		return i, nil
+	}
+
+	var rest *ast.Identifier
+	for {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.exceptPeek(token.IDENT) {
+				return nil, nil
+			}
+			rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.peekTokenIs(token.RPAREN) {
+				d := p.errorAt(p.peekToken, ErrUnexpectedToken,
+					"expected next token to be %s, got %s instead", token.RPAREN, describe(p.peekToken))
+				d.Expected = []token.TokenType{token.RPAREN}
+				d.Hint = "...rest must be the last parameter"
+				return nil, nil
+			}
+			break
+		}
 
-	ii := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	i = append(i, ii)
+		first := p.peekToken
+		ii := p.parseParameter()
+		if ii == nil {
+			return nil, nil
+		}
+		if p.peekTokenIs(token.ASSIGN) {
+			if ii = p.parseDefault(ii); ii == nil {
+				return nil, nil
+			}
+		} else if len(i) > 0 {
+			// the arguments fill the parameters in order, so one
+			// without a default after one with could never be left out
+			if _, ok := i[len(i)-1].(*ast.DefaultPattern); ok {
+				d := p.errorAt(first, ErrParameterOrder,
+					"parameter %s without a default follows one with a default", ii.String())
+				d.End = ii.End()
+				d.Hint = "give it a default too, or move it before the defaulted ones"
+				return nil, nil
+			}
+		}
+		i = append(i, ii)
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ii := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		i = append(i, ii)
 	}
 
 	if !p.exceptPeek(token.RPAREN) {
-		return nil
+		return nil, nil
 	}
 
-	return i
+	return i, rest
 }
 
 func (p *Parser) parseCallExpression(f ast.Expression) ast.Expression {
 	e := &ast.CallExpression{Token: p.curToken, Function: f}
 	e.Arguments = p.parseExpressionList(token.RPAREN)
+	e.RParen = p.curToken
 	return e
 }
 
@@ -463,5 +952,6 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.exceptPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.RBracket = p.curToken
 	return exp
 }
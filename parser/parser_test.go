@@ -2,10 +2,12 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/clg0803/circus/ast"
 	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/token"
 )
 
 // parser/parser_test.go
@@ -373,6 +375,54 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a == b && c != d || e",
+			"(((a == b) && (c != d)) || e)",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)))",
+		},
+		{
+			"a & b == c",
+			"((a & b) == c)",
+		},
+		{
+			"1 << 2 + 3",
+			"(1 << (2 + 3))",
+		},
+		{
+			"a >> b < c",
+			"((a >> b) < c)",
+		},
+		{
+			"a * b % c",
+			"((a * b) % c)",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"2 ** -1",
+			"(2 ** (-1))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -489,3 +539,699 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input    string
+		start    string
+		end      string
+		expected string // source text covered by the span
+	}{
+		{"let x = 5 + 10;", "1:1", "1:15", "let x = 5 + 10"},
+		{"  return add(1, 2);", "1:3", "1:19", "return add(1, 2)"},
+		{"fn(x, y) {\n  x + y;\n}", "1:1", "3:2", "fn(x, y) {\n  x + y;\n}"},
+		{"if (a) { b } else { c }", "1:1", "1:24", "if (a) { b } else { c }"},
+		{"arr[1 + 2]", "1:1", "1:11", "arr[1 + 2]"},
+		{"[1, 2, 3]", "1:1", "1:10", "[1, 2, 3]"},
+		{`{"a": 1}`, "1:1", "1:9", `{"a": 1}`},
+		{"-foo", "1:1", "1:5", "-foo"},
+		{`"hi"`, "1:1", "1:5", `"hi"`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile("script.mk", tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0]
+		pos, end := stmt.Pos(), stmt.End()
+		if got := pos.String(); got != "script.mk:"+tt.start {
+			t.Errorf("%q: wrong start. expected=%q, got=%q", tt.input, tt.start, got)
+		}
+		if got := end.String(); got != "script.mk:"+tt.end {
+			t.Errorf("%q: wrong end. expected=%q, got=%q", tt.input, tt.end, got)
+		}
+		if got := tt.input[pos.Offset:end.Offset]; got != tt.expected {
+			t.Errorf("%q: wrong span text. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestParserErrorPosition(t *testing.T) {
+	l := lexer.NewFile("script.mk", "let x = 5;\nlet = 7;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors")
+	}
+	if !strings.HasPrefix(errors[0].String(), "script.mk:2:5: ") {
+		t.Errorf("error has wrong position. got=%q", errors[0])
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `let a = 1;
+let = 2;
+let b = (3 + ;
+let c = 4;
+let f = fn(x, 1) { x };
+if (c { c }
+let g = fn(x) {
+  let y = x +;
+  y * 2
+};
+let h = [1, 2;
+return c;
+`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	expectedLines := []int{2, 3, 5, 6, 8, 11}
+	errors := p.Errors()
+	if len(errors) != len(expectedLines) {
+		for _, e := range errors {
+			t.Logf("parser error: %s", e)
+		}
+		t.Fatalf("wrong number of errors. expected=%d, got=%d",
+			len(expectedLines), len(errors))
+	}
+	for i, line := range expectedLines {
+		if errors[i].Pos.Line != line {
+			t.Errorf("errors[%d] on wrong line. expected=%d, got=%s",
+				i, line, errors[i])
+		}
+	}
+
+	expected := []string{
+		"let a = 1;",
+		"let c = 4;",
+		"let g = fn(x) (y * 2);",
+		"return c;",
+	}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("wrong number of statements. expected=%d, got=%d (%s)",
+			len(expected), len(program.Statements), program)
+	}
+	for i, s := range program.Statements {
+		if s == nil {
+			t.Fatalf("program.Statements[%d] is nil", i)
+		}
+		if s.String() != expected[i] {
+			t.Errorf("program.Statements[%d] wrong. expected=%q, got=%q",
+				i, expected[i], s.String())
+		}
+	}
+}
+
+// TestOneErrorPerMistake checks that recovery skips to the end of the
+// construct that broke, without follow-on errors
+func TestOneErrorPerMistake(t *testing.T) {
+	tests := []struct {
+		input string
+		after string // what parses after the mistake
+	}{
+		{`{"a" 1}`, ""},
+		{`let {1.5: a} = h`, ""},
+		{`let h = {"a" 1, "b": {"c": 2}}; let y = 3;`, "let y = 3;"},
+		{`let f = fn() { let h = {"a" 1}; 2 }; let y = 3;`, "let f = fn() 2;let y = 3;"},
+		{`match (x) { {"a" 1} => 1 }; let y = 3;`, "let y = 3;"},
+		{`while (x) { let {1.5: a} = h; }; let y = 3;`, "whilex let y = 3;"},
+		{`(a + ) = 1; let y = 3;`, "let y = 3;"},
+		{`(a[) = 1; let y = 3;`, "let y = 3;"},
+		{`x + (] = 2; let y = 3;`, "let y = 3;"},
+		{`let y = (a + ] = 1);`, ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		if errors := p.Errors(); len(errors) != 1 {
+			t.Errorf("%q: expected 1 error, got %d: %v", tt.input, len(errors), errors)
+		}
+		if program.String() != tt.after {
+			t.Errorf("%q: expected %q to parse, got %q", tt.input, tt.after, program.String())
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{".5", 0.5},
+		{"1e-9", 1e-9},
+		{"2.5E3", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "123456789012345678901234567890"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.BigIntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.BigIntegerLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value.String() != input {
+		t.Errorf("literal.Value not %s. got=%s", input, literal.Value)
+	}
+
+	// the largest int64 is still a plain IntegerLiteral
+	p = New(lexer.New("9223372036854775807"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	testIntegerLiteral(t, program.Statements[0].(*ast.ExpressionStatement).Expression,
+		9223372036854775807)
+}
+
+func TestInterpolatedStringExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		parts    int
+	}{
+		{`"Hello ${name}!"`, "Hello ${name}!", 3},
+		{`"${a + b * c}"`, "${(a + (b * c))}", 3},
+		{`"a ${x} b ${f(y, 1)} c"`, "a ${x} b ${f(y, 1)} c", 5},
+		{`"outer ${"inner ${x}"}"`, "outer ${inner ${x}}", 3},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+		if len(str.Parts) != tt.parts {
+			t.Errorf("wrong number of parts. expected=%d, got=%d", tt.parts, len(str.Parts))
+		}
+		if str.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, str.String())
+		}
+		if end := str.End(); end.Offset != len(tt.input) {
+			t.Errorf("wrong end offset. expected=%d, got=%d", len(tt.input), end.Offset)
+		}
+	}
+
+	p := New(lexer.New(`let s = "a ${x"; let t = 1;`))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 1 {
+		t.Fatalf("wrong number of errors. got=%v", p.Errors())
+	}
+	if len(program.Statements) != 0 {
+		t.Errorf("wrong number of statements. got=%d", len(program.Statements))
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input      string
+		expected   string
+		hasCatch   bool
+		hasFinally bool
+	}{
+		{"try { f() } catch (e) { e }", "try f() catch (e) e", true, false},
+		{"try { f() } finally { g() }", "try f() finally g()", false, true},
+		{"try { f() } catch (err) { 1 } finally { 2 }", "try f() catch (err) 1 finally 2", true, true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.TryExpression. got=%T", stmt.Expression)
+		}
+		if (exp.Catch != nil) != tt.hasCatch || (exp.Finally != nil) != tt.hasFinally {
+			t.Errorf("wrong clauses for %q. catch=%v, finally=%v",
+				tt.input, exp.Catch != nil, exp.Finally != nil)
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, exp.String())
+		}
+		if end := exp.End(); end.Offset != len(tt.input) {
+			t.Errorf("wrong end offset. expected=%d, got=%d", len(tt.input), end.Offset)
+		}
+	}
+
+	errTests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() }; let x = 1;", "1:12: error[E001]: expected catch or finally after try block, got ; instead"},
+		{"try { f() } catch { g() }", "1:19: error[E001]: expected next token to be (, got { instead"},
+		{"try { f() } catch (1) { g() }", "1:20: error[E001]: expected next token to be IDENT, got 1 instead"},
+	}
+
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 {
+			t.Fatalf("wrong number of errors for %q. got=%v", tt.input, p.Errors())
+		}
+		if got := p.Errors()[0].String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	p := New(lexer.New(`throw "bad"; throw x + 1`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{`throw bad;`, `throw (x + 1);`}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	for i, want := range expected {
+		stmt, ok := program.Statements[i].(*ast.ThrowStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.ThrowStatement. got=%T", program.Statements[i])
+		}
+		if stmt.String() != want {
+			t.Errorf("expected=%q, got=%q", want, stmt.String())
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "x = 5"},
+		{"x += 1 + 2", "x += (1 + 2)"},
+		{"x -= y * 2", "x -= (y * 2)"},
+		{"x *= 2", "x *= 2"},
+		{"x /= 2", "x /= 2"},
+		{"a = b = c", "a = b = c"},
+		{"x = y || z", "x = (y || z)"},
+		{"f(x = 1)", "f(x = 1)"},
+		{"a[0] = 1", "(a[0]) = 1"},
+		{"h[k] += v", "(h[k]) += v"},
+		{"a[i][j] = a[j][i]", "((a[i])[j]) = ((a[j])[i])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if stmt.Expression.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.Expression.String())
+		}
+	}
+
+	errTests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 = 2", "1:3: error[E008]: cannot assign to 1"},
+		{"f() += 1", "1:5: error[E008]: cannot assign to f()"},
+		{"a + b = c", "1:7: error[E008]: cannot assign to (a + b)"},
+	}
+
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 {
+			t.Fatalf("wrong number of errors for %q. got=%v", tt.input, p.Errors())
+		}
+		if got := p.Errors()[0].String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestConstStatement(t *testing.T) {
+	p := New(lexer.New("const answer = 42;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.LetStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Token.Type != token.CONST {
+		t.Errorf("stmt.Token.Type not CONST. got=%q", stmt.Token.Type)
+	}
+	if stmt.String() != "const answer = 42;" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x += 1 }", "while(x < 10) x += 1"},
+		{"for (x in xs) { puts(x) }", "for (x in xs) puts(x)"},
+		{"for (k, v in h) { puts(k, v) }", "for (k, v in h) puts(k, v)"},
+		{"for (i in range(0, 10)) { if (i > 5) { break; } }", "for (i in range(0, 10)) if(i > 5) break;"},
+		{"while (true) { continue }", "whiletrue continue;"},
+		{"while (a) { while (b) { break } break }", "whilea whileb break;break;"},
+		{"for (x in xs) { fn() { for (y in x) { continue } } }", "for (x in xs) fn() for (y in x) continue;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+		if end := program.Statements[0].End(); end.Offset != len(tt.input) {
+			t.Errorf("wrong end offset. expected=%d, got=%d", len(tt.input), end.Offset)
+		}
+	}
+
+	// a trailing semicolon ends the loop like it ends any statement
+	semiTests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x) { x -= 1 }; x", "whilex x -= 1x"},
+		{"for (x in xs) { puts(x) }; 1", "for (x in xs) puts(x)1"},
+		{"fn() { while (x) { break }; for (y in x) { continue }; }", "fn() whilex break;for (y in x) continue;"},
+	}
+
+	for _, tt := range semiTests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	errTests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: error[E009]: break outside of a loop"},
+		{"if (x) { continue }", "1:10: error[E009]: continue outside of a loop"},
+		{"while (x) { fn() { break } }", "1:20: error[E009]: break outside of a loop"},
+		{"while (x) { fn(a = if (x) { break }) { } }", "1:29: error[E009]: break outside of a loop"},
+		{"for (x of xs) { }", "1:8: error[E001]: expected next token to be IN, got of instead"},
+		{"for (1 in xs) { }", "1:6: error[E001]: expected next token to be IDENT, got 1 instead"},
+		{"while x { }", "1:7: error[E001]: expected next token to be (, got x instead"},
+	}
+
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 {
+			t.Fatalf("wrong number of errors for %q. got=%v", tt.input, p.Errors())
+		}
+		if got := p.Errors()[0].String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < 0) { "neg" } else if (x == 0) { "zero" } else if (x < 10) { "small" } else { "big" }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+
+	depth := 0
+	exp := stmt.Expression.(*ast.IfExpression)
+	for {
+		depth++
+		if exp.Alternative == nil || len(exp.Alternative.Statements) != 1 {
+			t.Fatalf("alternative of if #%d is not a single statement", depth)
+		}
+		inner, ok := exp.Alternative.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+		if !ok {
+			break
+		}
+		exp = inner
+	}
+	if depth != 3 {
+		t.Errorf("wrong chain length. expected=3, got=%d", depth)
+	}
+	if end := stmt.End(); end.Offset != len(input) {
+		t.Errorf("wrong end offset. expected=%d, got=%d", len(input), end.Offset)
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		arms     int
+	}{
+		{`match (x) { 1 => "one", _ => "other" }`, `match (x) { 1 => one, _ => other }`, 2},
+		{`match (x) { 1, 2, -3 => a, 2.5 => b, }`, `match (x) { 1, 2, (-3) => a, 2.5 => b }`, 2},
+		{`match (x) { [a, [b, _]] => a + b }`, `match (x) { [a, [b, _]] => (a + b) }`, 1},
+		{`match (p) { {"name": n, 1: true} => n }`, `match (p) { {name: n, 1: true} => n }`, 1},
+		{`match (x) { n if n > 5 => { let y = n; y }, n => n }`, `match (x) { n if (n > 5) => let y = n;y, n => n }`, 2},
+		{`match (x) { }`, `match (x) {  }`, 0},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.MatchExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.MatchExpression. got=%T", stmt.Expression)
+		}
+		if len(exp.Arms) != tt.arms {
+			t.Errorf("wrong number of arms. expected=%d, got=%d", tt.arms, len(exp.Arms))
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, exp.String())
+		}
+		if end := exp.End(); end.Offset != len(tt.input) {
+			t.Errorf("wrong end offset. expected=%d, got=%d", len(tt.input), end.Offset)
+		}
+	}
+
+	errTests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { a + 1 => 2 }", "1:15: error[E001]: expected next token to be =>, got + instead"},
+		{"match (x) { f() => 2 }", "1:14: error[E001]: expected next token to be =>, got ( instead"},
+		{"match (x) { * => 2 }", "1:13: error[E010]: expected a pattern, got * instead"},
+		{"match (x) { {[1]: v} => 2 }", "1:14: error[E010]: expected a hash key, got [ instead"},
+		{"match (x) { 1 => 2 3 => 4 }", "1:20: error[E001]: expected next token to be ,, got 3 instead"},
+	}
+
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("no error for %q", tt.input)
+		}
+		if got := p.Errors()[0].String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = arr;", "let [a, b] = arr;"},
+		{"let [a, b, ...rest] = arr;", "let [a, b, ...rest] = arr;"},
+		{"let [...all] = arr;", "let [...all] = arr;"},
+		{"let [a = 1, [b, c = 2]] = arr;", "let [a = 1, [b, c = 2]] = arr;"},
+		{"let {name, age} = person;", "let {name: name, age: age} = person;"},
+		{`let {name = "anon", "home": {city}} = person;`, "let {name: name = anon, home: {city: city}} = person;"},
+		{"let {pos: [x, y] = [0, 0]} = p;", "let {pos: [x, y] = [0, 0]} = p;"},
+		{"const [_, second] = arr;", "const [_, second] = arr;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.LetStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Pattern == nil || stmt.Name != nil {
+			t.Errorf("%q: expected a pattern and no name", tt.input)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.String())
+		}
+		if end := stmt.End(); end.Offset != len(tt.input)-1 {
+			t.Errorf("wrong end offset. expected=%d, got=%d", len(tt.input)-1, end.Offset)
+		}
+	}
+
+	errTests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [...rest, a] = arr;", "1:13: error[E010]: expected ] after the rest element, got , instead"},
+		{"let [a, ...] = arr;", "1:12: error[E001]: expected next token to be IDENT, got ] instead"},
+		{`let {"name"} = p;`, "1:12: error[E001]: expected next token to be :, got } instead"},
+		{"let [a + 1] = arr;", "1:8: error[E001]: expected next token to be ,, got + instead"},
+	}
+
+	for _, tt := range errTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("no error for %q", tt.input)
+		}
+		if got := p.Errors()[0].String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestFunctionParameterPatterns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(x, y) { x }", "fn(x, y) x"},
+		{"fn([a, b], {name}) { a }", "fn([a, b], {name: name}) a"},
+		{"fn({pos: [x, y]}, z) { x }", "fn({pos: [x, y]}, z) x"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if stmt.Expression.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.Expression.String())
+		}
+	}
+
+	p := New(lexer.New("fn(1) { 1 }"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Fatalf("no error for a literal parameter")
+	}
+	expected := "1:4: error[E001]: expected next token to be IDENT, got 1 instead"
+	if got := p.Errors()[0].String(); got != expected {
+		t.Errorf("expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestDefaultRestAndSpread(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(x, y = 10) { x }", "fn(x, y = 10) x"},
+		{"fn(first, ...others) { first }", "fn(first, ...others) first"},
+		{"fn(...all) { all }", "fn(...all) all"},
+		{"fn([a, b] = [1, 2], ...r) { a }", "fn([a, b] = [1, 2], ...r) a"},
+		{"f(...args)", "f(...args)"},
+		{"f(1, ...[2, 3], x)", "f(1, ...[2, 3], x)"},
+		{"[0, ...xs]", "[0, ...xs]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if stmt.Expression.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.Expression.String())
+		}
+	}
+
+	p := New(lexer.New("fn(...rest, x) { x }"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Fatalf("no error for a rest parameter that is not last")
+	}
+	expected := "1:11: error[E001]: expected next token to be ), got , instead"
+	if got := p.Errors()[0].String(); got != expected {
+		t.Errorf("expected=%q, got=%q", expected, got)
+	}
+
+	orderTests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(x = 1, y) {}", "1:11: error[E012]: parameter y without a default follows one with a default"},
+		{"fn(a, x = 1, [y, z]) {}", "1:14: error[E012]: parameter [y, z] without a default follows one with a default"},
+		{"let f = fn(x = 1, y, ...r) { x }; f(2)", "1:19: error[E012]: parameter y without a default follows one with a default"},
+	}
+	for _, tt := range orderTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if errors := p.Errors(); len(errors) != 1 {
+			t.Errorf("%q: expected 1 error, got %d: %v", tt.input, len(errors), errors)
+		} else if got := errors[0].String(); got != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
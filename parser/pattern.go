@@ -0,0 +1,251 @@
+package parser
+
+import (
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/token"
+)
+
+// parsePattern parses the pattern starting at curToken:
+// `_`, a name, a literal, `[p, ...]` or `{"key": p, name, ...}`.
+// Elements of array and hash patterns may take `= default`
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+		lit := p.prefixParseFns[p.curToken.Type]()
+		if lit == nil {
+			return nil
+		}
+		return &ast.LiteralPattern{Value: lit}
+	case token.MINUS:
+		if p.peekTokenIs(token.INT) || p.peekTokenIs(token.FLOAT) {
+			e := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+			p.nextToken()
+			if e.Right = p.prefixParseFns[p.curToken.Type](); e.Right == nil {
+				return nil
+			}
+			return &ast.LiteralPattern{Value: e}
+		}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	case token.ILLEGAL:
+		p.illegalError(p.curToken)
+		return nil
+	}
+
+	d := p.errorAt(p.curToken, ErrInvalidPattern,
+		"expected a pattern, got %s instead", describe(p.curToken))
+	d.Hint = "patterns are _, names, literals, [arrays] and {hashes}"
+	return nil
+}
+
+// parseParameter parses the function parameter after curToken,
+// a name or an array or hash pattern
+func (p *Parser) parseParameter() ast.Pattern {
+	switch p.peekToken.Type {
+	case token.IDENT, token.LBRACKET, token.LBRACE:
+		p.nextToken()
+		return p.parsePattern()
+	}
+	p.peekErrors(token.IDENT)
+	return nil
+}
+
+// parseElementPattern parses a pattern that may be followed by
+// `= default`, as array elements and hash values may
+func (p *Parser) parseElementPattern() ast.Pattern {
+	pat := p.parsePattern()
+	if pat == nil || !p.peekTokenIs(token.ASSIGN) {
+		return pat
+	}
+	return p.parseDefault(pat)
+}
+
+func (p *Parser) parseDefault(target ast.Pattern) ast.Pattern {
+	p.nextToken()
+	dp := &ast.DefaultPattern{Token: p.curToken, Target: target}
+	p.nextToken()
+	if dp.Default = p.parseExpression(ASSIGN); dp.Default == nil {
+		return nil
+	}
+	return dp
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	ap := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.exceptPeek(token.IDENT) {
+				return nil
+			}
+			ap.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.peekTokenIs(token.RBRACKET) {
+				d := p.errorAt(p.peekToken, ErrInvalidPattern,
+					"expected ] after the rest element, got %s instead", describe(p.peekToken))
+				d.Expected = []token.TokenType{token.RBRACKET}
+				d.Hint = "...rest must be the last element"
+				return nil
+			}
+			break
+		}
+
+		el := p.parseElementPattern()
+		if el == nil {
+			return nil
+		}
+		ap.Elements = append(ap.Elements, el)
+		if !p.peekTokenIs(token.RBRACKET) && !p.exceptPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	ap.RBracket = p.curToken
+
+	return ap
+}
+
+func (p *Parser) parseHashPattern() ast.Pattern {
+	hp := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		pair := &ast.HashPatternPair{}
+
+		switch p.curToken.Type {
+		case token.IDENT:
+			pair.Key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		case token.STRING, token.INT, token.TRUE, token.FALSE:
+			if pair.Key = p.prefixParseFns[p.curToken.Type](); pair.Key == nil {
+				return nil
+			}
+		default:
+			p.errorAt(p.curToken, ErrInvalidPattern,
+				"expected a hash key, got %s instead", describe(p.curToken))
+			return nil
+		}
+
+		switch {
+		case p.peekTokenIs(token.COLON):
+			p.nextToken()
+			p.nextToken()
+			pair.Value = p.parseElementPattern()
+		case p.curTokenIs(token.IDENT):
+			// {name} and {name = default}
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			pair.Value = name
+			if p.peekTokenIs(token.ASSIGN) {
+				pair.Value = p.parseDefault(name)
+			}
+		default:
+			p.peekErrors(token.COLON)
+		}
+		if pair.Value == nil {
+			return nil
+		}
+
+		hp.Pairs = append(hp.Pairs, pair)
+		if !p.peekTokenIs(token.RBRACE) && !p.exceptPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	hp.RBrace = p.curToken
+
+	return hp
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	e := &ast.MatchExpression{Token: p.curToken}
+	if !p.exceptPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	e.Subject = p.parseExpression(LOWEST)
+
+	if !p.exceptPeek(token.RPAREN) || !p.exceptPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		e.Arms = append(e.Arms, arm)
+		if !p.peekTokenIs(token.RBRACE) && !p.exceptPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	e.RBrace = p.curToken
+
+	return e
+}
+
+// parseMatchArm parses `p1, p2 if guard => body`, the body is a block
+// or a single expression, so a hash literal body needs parentheses
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{}
+
+	for {
+		pat := p.parsePattern()
+		if pat == nil {
+			return nil
+		}
+		arm.Patterns = append(arm.Patterns, pat)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+		p.nextToken()
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		if arm.Guard = p.parseExpression(LOWEST); arm.Guard == nil {
+			return nil
+		}
+	}
+
+	if !p.exceptPeek(token.ARROW) {
+		return nil
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACE) {
+		arm.Body = p.parseBlockStatement()
+		return arm
+	}
+
+	tok := p.curToken
+	exp := p.parseExpression(LOWEST)
+	if exp == nil {
+		return nil
+	}
+	arm.Body = wrapInBlock(tok, exp, p.curToken)
+
+	return arm
+}
+
+// wrapInBlock makes a block of the single expression exp, which runs
+// from first to last, last stands in for the missing '}'
+func wrapInBlock(first token.Token, exp ast.Expression, last token.Token) *ast.BlockStatement {
+	return &ast.BlockStatement{
+		Token:      first,
+		Statements: []ast.Statement{&ast.ExpressionStatement{Token: first, Expression: exp}},
+		RBrace:     last,
+	}
+}
@@ -5,18 +5,26 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/compiler"
 	"github.com/clg0803/circus/evaluator"
 	"github.com/clg0803/circus/lexer"
 	"github.com/clg0803/circus/object"
 	"github.com/clg0803/circus/parser"
+	"github.com/clg0803/circus/vm"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
+// Start reads and runs a line at a time with engine, a line sees
+// what the lines before it defined
+func Start(in io.Reader, out io.Writer, engine Engine) {
 	scanner := bufio.NewScanner(in)
 
-	env := object.NewEnvirnment()
+	exec := evalLine()
+	if engine == EngineVM {
+		exec = runLine()
+	}
 	for {
 		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
@@ -30,17 +38,55 @@ func Start(in io.Reader, out io.Writer) {
 
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
 			continue
 		}
 
-		eval := evaluator.Eval(program, env)
+		eval := exec(program)
+		if err, ok := eval.(*object.Error); ok {
+			io.WriteString(out, err.Traceback())
+			io.WriteString(out, "\n")
+			continue
+		}
 		if eval != nil {
 			io.WriteString(out, eval.Inspect())
 			io.WriteString(out, "\n")
 		}
 	}
 }
+
+// evalLine evaluates each line in one environment
+func evalLine() func(*ast.Program) object.Object {
+	env := object.NewEnvirnment()
+	return func(program *ast.Program) object.Object {
+		return evaluator.Eval(program, env)
+	}
+}
+
+// runLine compiles each line on from the ones before and runs it on
+// their globals
+func runLine() func(*ast.Program) object.Object {
+	symbolTable := compiler.NewSymbolTable()
+	var constants []object.Object
+	// room for as many globals as an operand can index, a cell never
+	// moves as long as a variable that shadows it may point at it
+	globals := make([]object.Cell, 0, 1<<16)
+	return func(program *ast.Program) object.Object {
+		c := compiler.NewWithState(symbolTable, constants)
+		err := c.Compile(program)
+		bytecode := c.Bytecode()
+		constants = bytecode.Constants
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		for len(globals) < len(bytecode.Globals) {
+			globals = append(globals, object.Cell{})
+		}
+		return vm.NewWithGlobals(bytecode, globals).Run()
+	}
+}
+
 const IKUN = `
 ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,:,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,
 ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~,,,,,,,,,,,,,,,,,,,,,,,,IMMMMMMMMM=,,,,,,,,,,,,,,,,,,,,,,,,,,,,,
@@ -114,11 +160,9 @@ const MONKEY_FACE = `            __,__
            '-----'
 `
 
-func printParserErrors(out io.Writer, errors []string) {
+func printParserErrors(out io.Writer, source string, errors []parser.Diagnostic) {
 	io.WriteString(out, IKUN)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
-	}
+	io.WriteString(out, parser.RenderAll(source, errors))
 }
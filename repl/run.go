@@ -0,0 +1,80 @@
+package repl
+
+import (
+	"io"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/compiler"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/optimizer"
+	"github.com/clg0803/circus/parser"
+	"github.com/clg0803/circus/vm"
+)
+
+// Engine is what runs a program: the evaluator walks its tree, the vm
+// runs the bytecode it is compiled to. Both give the same results
+type Engine int
+
+const (
+	EngineEval Engine = iota
+	EngineVM
+)
+
+// Run evaluates a whole script read from filename with engine,
+// it reports false when the script failed to parse or evaluate
+func Run(filename, input string, engine Engine, out io.Writer) bool {
+	l := lexer.NewFile(filename, input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		io.WriteString(out, parser.RenderAll(input, p.Errors()))
+		return false
+	}
+	// resolve first, the lets of a pruned branch still declare names
+	if diags := evaluator.Resolve(program); len(diags) != 0 {
+		io.WriteString(out, parser.RenderAll(input, diags))
+		return false
+	}
+	optimizer.Optimize(program)
+	return run(program, engine, out)
+}
+
+// Exec resolves, optimizes and evaluates a loaded program like Run
+// does, without the source its diagnostics show no excerpt
+func Exec(program *ast.Program, engine Engine, out io.Writer) bool {
+	if diags := evaluator.Resolve(program); len(diags) != 0 {
+		for _, d := range diags {
+			io.WriteString(out, d.String()+"\n")
+		}
+		return false
+	}
+	optimizer.Optimize(program)
+	return run(program, engine, out)
+}
+
+func run(program *ast.Program, engine Engine, out io.Writer) bool {
+	var res object.Object
+	if engine == EngineVM {
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			io.WriteString(out, err.Error()+"\n")
+			return false
+		}
+		res = vm.New(c.Bytecode()).Run()
+	} else {
+		res = evaluator.Eval(program, object.NewEnvirnment())
+	}
+
+	if err, ok := res.(*object.Error); ok {
+		io.WriteString(out, err.Traceback())
+		io.WriteString(out, "\n")
+		if err.GoStack != "" {
+			io.WriteString(out, err.GoStack)
+		}
+		return false
+	}
+	return true
+}
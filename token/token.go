@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
@@ -9,23 +11,50 @@ const (
 	// 标识符 + 字面量
 	IDENT  = "IDENT" // add foo x y ...
 	INT    = "INT"   // 1234
+	FLOAT  = "FLOAT" // 3.14 .5 1e-9
 	STRING = "STRING"
 
+	// "head ${ mid ${ tail"
+	STRING_HEAD = "STRING_HEAD"
+	STRING_MID  = "STRING_MID"
+	STRING_TAIL = "STRING_TAIL"
+
 	// binary operator
 	ASSIGN   = "="
+	ARROW    = "=>"
 	PLUS     = "+"
 	MINUS    = "-"
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
 
-	LT = "<"
-	GT = ">"
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
+	PERCENT = "%"
+	POW     = "**"
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	AND = "&&"
+	OR  = "||"
+
+	BIT_AND = "&"
+	BIT_OR  = "|"
+	BIT_XOR = "^"
+	SHL     = "<<"
+	SHR     = ">>"
+
 	// split
+	ELLIPSIS  = "..."
 	COMMA     = ","
 	SEMICOLON = ";"
 
@@ -37,31 +66,64 @@ const (
 	LBRACKET = "[" // support array
 	RBRACKET = "]"
 
-	COLON = ";" // support hash
+	COLON = ":" // support hash
 
 	// 关键字
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	MATCH    = "MATCH"
 )
 
 type Token struct {
 	Type    TokenType // 枚举类型
 	Literal string
+	Pos     Position // first char of the token
+	End     Position // just past the last char of the token
+
+	Comments []Comment // comments right before the token, for tooling
+}
+
+// Comment is a `// line` or `/* block */` comment, Text includes the markers
+type Comment struct {
+	Text string
+	Pos  Position
+	End  Position
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"const":    CONST,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"match":    MATCH,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -70,3 +132,31 @@ func LookupIdent(ident string) TokenType {
 	}
 	return IDENT
 }
+
+// Position locates a char in the source, Line and Column count from 1
+// Offset is the byte offset from the start of the input
+type Position struct {
+	Filename string `json:"file,omitempty"`
+	Offset   int    `json:"offset"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+// IsValid reports whether the position was set by the lexer
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String formats the position as `file:line:column`,
+// the file name is omitted when unknown
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+	s := fmt.Sprintf("%d:%d", p.Line, p.Column)
+	if p.Filename != "" {
+		s = p.Filename + ":" + s
+	}
+	return s
+}
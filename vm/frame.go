@@ -0,0 +1,100 @@
+package vm
+
+import (
+	"github.com/clg0803/circus/object"
+)
+
+// Frame is a running call of a closure, its stack starts at bp
+type Frame struct {
+	cl    *object.Closure
+	ip    int
+	bp    int
+	cells []*object.Cell
+
+	// args are kept while the prologue binds them
+	args     []object.Object
+	prologue bool
+
+	// trace is the call and the tail calls it turned into,
+	// outermost first, for the stacks of errors
+	trace []object.Frame
+
+	handlers []handler
+	patterns []patternRecord
+	loops    []loopRecord
+}
+
+func newFrame(cl *object.Closure, bp int) *Frame {
+	return &Frame{cl: cl, bp: bp, cells: newCells(cl.Fn.NumLocals)}
+}
+
+func newCells(n int) []*object.Cell {
+	cells := make([]*object.Cell, n)
+	backing := make([]object.Cell, n)
+	for i := range cells {
+		cells[i] = &backing[i]
+	}
+	return cells
+}
+
+// pushTrace adds the frame of a tail call, a call that comes back to a
+// frame already there drops the loop between them like the evaluator
+func pushTrace(trace []object.Frame, f object.Frame) []object.Frame {
+	for i, g := range trace {
+		if g == f {
+			return trace[:i+1]
+		}
+	}
+	return append(trace, f)
+}
+
+type stage int
+
+const (
+	inTry stage = iota
+	inCatch
+	inFinally
+)
+
+// handler is a try expression being run
+type handler struct {
+	catch, finally, end int
+	sp                  int
+	patterns, loops     int
+	stage               stage
+	pending             completion // what ran the finally clause
+}
+
+type completionKind int
+
+const (
+	normal completionKind = iota
+	throwing
+	returning
+	jumping
+)
+
+// completion is how a try block or catch clause ended,
+// the finally clause runs before it is carried out
+type completion struct {
+	kind   completionKind
+	value  object.Object
+	err    *object.Error
+	target int
+	depth  int // handlers left in place by a jump
+}
+
+// loopRecord is a loop being run, a break or continue goes back to
+// its stack from wherever it sits in the body
+type loopRecord struct {
+	sp       int
+	patterns int
+}
+
+// patternRecord is a pattern being matched, a mismatch jumps to fail
+// restoring sp, or is an error about the subject for a destructuring
+type patternRecord struct {
+	sp      int
+	fail    int
+	subject object.ObjectType
+}
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"unicode/utf8"
+
+	"github.com/clg0803/circus/object"
+)
+
+// iterator walks what a for-in loop goes over, in the order and
+// with the keys the evaluator gives. It only lives on the stack
+type iterator struct {
+	obj   object.Object
+	pos   int // element, byte offset or pair
+	index int64
+	pairs []object.HashPair
+	n     int64
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+func newIterator(obj object.Object) (*iterator, *object.Error) {
+	it := &iterator{obj: obj}
+	switch obj := obj.(type) {
+	case *object.Array, *object.String:
+	case *object.Hash:
+		// a snapshot so the body may add and delete keys
+		it.pairs = obj.SortedPairs()
+	case *object.Range:
+		it.n = obj.Len()
+	default:
+		return nil, newError("not iterable: %s", obj.Type())
+	}
+	return it, nil
+}
+
+func (it *iterator) next() (key, value object.Object, ok bool) {
+	switch obj := it.obj.(type) {
+	case *object.Array:
+		// the body may assign to elements but never changes the length
+		if it.pos >= len(obj.Elements) {
+			return nil, nil, false
+		}
+		key, value = &object.Integer{Value: int64(it.pos)}, obj.Elements[it.pos]
+		it.pos++
+	case *object.String:
+		if it.pos >= len(obj.Value) {
+			return nil, nil, false
+		}
+		r, size := utf8.DecodeRuneInString(obj.Value[it.pos:])
+		key, value = &object.Integer{Value: it.index}, &object.String{Value: string(r)}
+		it.pos += size
+		it.index++
+	case *object.Hash:
+		if it.pos >= len(it.pairs) {
+			return nil, nil, false
+		}
+		key, value = it.pairs[it.pos].Key, it.pairs[it.pos].Value
+		it.pos++
+	case *object.Range:
+		if it.index >= it.n {
+			return nil, nil, false
+		}
+		key, value = &object.Integer{Value: it.index}, &object.Integer{Value: obj.Start + it.index*obj.Step}
+		it.index++
+	}
+	return key, value, true
+}
+
+// isHash reports whether a one-name for-in binds keys rather than values
+func (it *iterator) isHash() bool {
+	_, ok := it.obj.(*object.Hash)
+	return ok
+}
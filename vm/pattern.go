@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/clg0803/circus/code"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/object"
+)
+
+// runPattern runs the instructions that match the top against a
+// pattern, with the mismatch messages of the evaluator
+func (vm *VM) runPattern(f *Frame, ins code.Instructions, op code.Opcode) *object.Error {
+	switch op {
+	case code.OpMatchLiteral:
+		lit := vm.pop()
+		val := vm.pop()
+		if evaluator.Infix("==", lit, val) != evaluator.TRUE {
+			return vm.mismatch(f, "expected %s, got %s", lit.Inspect(), val.Inspect())
+		}
+	case code.OpMatchArray:
+		n, rest := vm.operand(f, ins), vm.byteOperand(f, ins)
+		arr, ok := vm.top().(*object.Array)
+		if !ok {
+			return vm.mismatch(f, "expected an array, got %s", vm.top().Type())
+		}
+		if len(arr.Elements) > n && rest == 0 {
+			return vm.mismatch(f, "expected %d elements, got %d", n, len(arr.Elements))
+		}
+	case code.OpArrayElem:
+		i, given := vm.operand(f, ins), vm.operand(f, ins)
+		if arr := vm.top().(*object.Array); i < len(arr.Elements) {
+			vm.push(arr.Elements[i])
+			f.ip = given
+		}
+	case code.OpMissingElem:
+		required, rest := vm.operand(f, ins), vm.byteOperand(f, ins)
+		atLeast := ""
+		if rest == 1 {
+			atLeast = "at least "
+		}
+		n := len(vm.top().(*object.Array).Elements)
+		return vm.mismatch(f, "expected %s%d elements, got %d", atLeast, required, n)
+	case code.OpArrayRest:
+		n := vm.operand(f, ins)
+		arr := vm.top().(*object.Array)
+		rest := []object.Object{}
+		if len(arr.Elements) > n {
+			rest = make([]object.Object, len(arr.Elements)-n)
+			copy(rest, arr.Elements[n:])
+		}
+		vm.push(&object.Array{Elements: rest})
+	case code.OpMatchHash:
+		if _, ok := vm.top().(*object.Hash); !ok {
+			return vm.mismatch(f, "expected a hash, got %s", vm.top().Type())
+		}
+	case code.OpHashElem:
+		given := vm.operand(f, ins)
+		key := vm.pop().(object.Hashable).HashKey()
+		if p, ok := vm.top().(*object.Hash).Pairs[key]; ok {
+			vm.push(p.Value)
+			f.ip = given
+		}
+	case code.OpMissingKey:
+		key := vm.constants[vm.operand(f, ins)].(*object.String)
+		return vm.mismatch(f, "missing key %s", key.Value)
+	default:
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			return newError("%s", err)
+		}
+		return newError("unhandled opcode %s", def.Name)
+	}
+	return nil
+}
+
+// mismatch ends the innermost pattern, a destructuring fails with
+// an error while a match goes on with its next pattern
+func (vm *VM) mismatch(f *Frame, format string, a ...interface{}) *object.Error {
+	rec := f.patterns[len(f.patterns)-1]
+	if rec.fail == code.None {
+		return newError("cannot destructure %s: %s", rec.subject, fmt.Sprintf(format, a...))
+	}
+	f.patterns = f.patterns[:len(f.patterns)-1]
+	vm.sp = rec.sp
+	f.ip = rec.fail
+	return nil
+}
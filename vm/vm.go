@@ -0,0 +1,621 @@
+// Package vm runs the bytecode of the compiler, with the semantics of
+// the evaluator down to error messages, positions and stacks
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/clg0803/circus/code"
+	"github.com/clg0803/circus/compiler"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/token"
+)
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Cell
+	globalNames []string
+
+	stack []object.Object // grows as needed
+	sp    int             // the top is stack[sp-1]
+
+	frames []*Frame
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	main := &object.Closure{Fn: bytecode.Main}
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Cell, len(bytecode.Globals)),
+		globalNames: bytecode.Globals,
+		stack:       make([]object.Object, 2048),
+		frames:      []*Frame{newFrame(main, 0)},
+	}
+}
+
+// NewWithGlobals runs bytecode on the globals of the programs before
+// it, there has to be a cell in globals for each of bytecode.Globals
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Cell) *VM {
+	vm := New(bytecode)
+	vm.globals = globals
+	return vm
+}
+
+// Run runs the program to the end and gives its value like
+// evaluator.Eval does, an uncaught error included
+func (vm *VM) Run() (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = &object.Error{
+				Message: fmt.Sprintf("internal error: %v", r),
+				GoStack: string(debug.Stack()),
+			}
+		}
+	}()
+
+	for {
+		f := vm.frames[len(vm.frames)-1]
+		ins := f.cl.Fn.Instructions
+		start := f.ip
+		op := code.Opcode(ins[start])
+		f.ip++
+
+		var err *object.Error
+		switch op {
+		case code.OpConstant:
+			vm.push(vm.constants[vm.operand(f, ins)])
+		case code.OpTrue:
+			vm.push(evaluator.TRUE)
+		case code.OpFalse:
+			vm.push(evaluator.FALSE)
+		case code.OpNull:
+			vm.push(evaluator.NULL)
+		case code.OpPop:
+			vm.sp--
+		case code.OpDup:
+			vm.push(vm.top())
+
+		case code.OpInfix:
+			op := code.Operators[vm.byteOperand(f, ins)]
+			right := vm.pop()
+			err = vm.pushResult(evaluator.Infix(op, vm.pop(), right))
+		case code.OpPrefix:
+			op := code.Operators[vm.byteOperand(f, ins)]
+			err = vm.pushResult(evaluator.Prefix(op, vm.pop()))
+
+		case code.OpJump:
+			f.ip = vm.operand(f, ins)
+		case code.OpJumpNotTruthy:
+			target := vm.operand(f, ins)
+			if !evaluator.IsTruthy(vm.pop()) {
+				f.ip = target
+			}
+		case code.OpAndJump, code.OpOrJump:
+			target := vm.operand(f, ins)
+			if evaluator.IsTruthy(vm.top()) == (op == code.OpOrJump) {
+				f.ip = target
+			} else {
+				vm.sp--
+			}
+
+		case code.OpGetGlobal:
+			i := vm.operand(f, ins)
+			err = vm.load(&vm.globals[i], vm.globalNames[i])
+		case code.OpGetLocal:
+			i := vm.operand(f, ins)
+			err = vm.load(f.cells[i], f.cl.Fn.LocalNames[i])
+		case code.OpGetFree:
+			i := vm.operand(f, ins)
+			err = vm.load(f.cl.Free[i], f.cl.Fn.FreeNames[i])
+		case code.OpSetGlobal:
+			vm.globals[vm.operand(f, ins)].Value = vm.top()
+		case code.OpSetLocal:
+			f.cells[vm.operand(f, ins)].Bound().Value = vm.top()
+		case code.OpSetFree:
+			f.cl.Free[vm.operand(f, ins)].Bound().Value = vm.top()
+		case code.OpCheckGlobal:
+			i := vm.operand(f, ins)
+			err = checkAssign(&vm.globals[i], vm.globalNames[i])
+		case code.OpCheckLocal:
+			i := vm.operand(f, ins)
+			err = checkAssign(f.cells[i], f.cl.Fn.LocalNames[i])
+		case code.OpCheckFree:
+			i := vm.operand(f, ins)
+			err = checkAssign(f.cl.Free[i], f.cl.Fn.FreeNames[i])
+		case code.OpDefineGlobal:
+			i := vm.operand(f, ins)
+			err = vm.define(&vm.globals[i], vm.globalNames[i], vm.byteOperand(f, ins))
+		case code.OpDefineLocal:
+			i := vm.operand(f, ins)
+			err = vm.define(f.cells[i], f.cl.Fn.LocalNames[i], vm.byteOperand(f, ins))
+		case code.OpScope:
+			start, n := vm.operand(f, ins), vm.operand(f, ins)
+			copy(f.cells[start:start+n], newCells(n))
+		case code.OpShadowGlobal:
+			i, j := vm.operand(f, ins), vm.operand(f, ins)
+			f.cells[i].Outer = &vm.globals[j]
+		case code.OpShadowLocal:
+			i, j := vm.operand(f, ins), vm.operand(f, ins)
+			f.cells[i].Outer = f.cells[j]
+		case code.OpShadowFree:
+			i, j := vm.operand(f, ins), vm.operand(f, ins)
+			f.cells[i].Outer = f.cl.Free[j]
+
+		case code.OpArray:
+			n := vm.operand(f, ins)
+			elements := make([]object.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			vm.push(&object.Array{Elements: elements})
+		case code.OpHash:
+			err = vm.buildHash(vm.operand(f, ins))
+		case code.OpAppend:
+			v := vm.pop()
+			arr := vm.top().(*object.Array)
+			arr.Elements = append(arr.Elements, v)
+		case code.OpSpread:
+			err = vm.spread(vm.pop(), vm.top().(*object.Array))
+		case code.OpInterpolate:
+			n := vm.operand(f, ins)
+			var out bytes.Buffer
+			for _, part := range vm.stack[vm.sp-n : vm.sp] {
+				out.WriteString(part.Inspect())
+			}
+			vm.sp -= n
+			vm.push(&object.String{Value: out.String()})
+		case code.OpIndex:
+			index := vm.pop()
+			err = vm.pushResult(evaluator.Index(vm.pop(), index))
+		case code.OpIndexTarget:
+			err = vm.indexTarget(vm.byteOperand(f, ins) == 1)
+		case code.OpSetIndex:
+			vm.setIndex()
+
+		case code.OpCall, code.OpTailCall:
+			n := vm.byteOperand(f, ins)
+			args := make([]object.Object, n)
+			copy(args, vm.stack[vm.sp-n:vm.sp])
+			bp := vm.sp - n - 1
+			err = vm.call(vm.stack[bp], args, bp, f.cl.Fn.SourceMap.Lookup(start), op == code.OpTailCall)
+		case code.OpApply, code.OpTailApply:
+			args := vm.pop().(*object.Array).Elements
+			bp := vm.sp - 1
+			err = vm.call(vm.stack[bp], args, bp, f.cl.Fn.SourceMap.Lookup(start), op == code.OpTailApply)
+		case code.OpReturnValue:
+			v := vm.pop()
+			if vm.runFinally(f, completion{kind: returning, value: v}, 0) {
+				continue
+			}
+			if vm.returnValue(v) {
+				return v
+			}
+		case code.OpClosure:
+			fn := vm.constants[vm.operand(f, ins)].(*object.CompiledFunction)
+			vm.push(&object.Closure{Fn: fn})
+		case code.OpCaptureLocal:
+			cl := vm.top().(*object.Closure)
+			cl.Free = append(cl.Free, f.cells[vm.operand(f, ins)])
+		case code.OpCaptureFree:
+			cl := vm.top().(*object.Closure)
+			cl.Free = append(cl.Free, f.cl.Free[vm.operand(f, ins)])
+		case code.OpArg:
+			i, given := vm.byteOperand(f, ins), vm.operand(f, ins)
+			if i < len(f.args) {
+				vm.push(f.args[i])
+				f.ip = given
+			}
+		case code.OpRestArgs:
+			n := vm.byteOperand(f, ins)
+			rest := []object.Object{}
+			if len(f.args) > n {
+				rest = make([]object.Object, len(f.args)-n)
+				copy(rest, f.args[n:])
+			}
+			vm.push(&object.Array{Elements: rest})
+		case code.OpEndPrologue:
+			f.prologue = false
+			f.args = nil
+
+		case code.OpThrow:
+			v := vm.pop()
+			err = &object.Error{Message: evaluator.ThrownMessage(v), Thrown: v}
+		case code.OpTry:
+			h := handler{sp: vm.sp, patterns: len(f.patterns), loops: len(f.loops)}
+			h.catch, h.finally, h.end = vm.operand(f, ins), vm.operand(f, ins), vm.operand(f, ins)
+			f.handlers = append(f.handlers, h)
+		case code.OpLeave:
+			v := vm.pop()
+			h := &f.handlers[len(f.handlers)-1]
+			if h.finally != code.None {
+				vm.enterFinally(f, h, completion{kind: normal, value: v})
+				continue
+			}
+			f.handlers = f.handlers[:len(f.handlers)-1]
+			vm.sp = h.sp
+			vm.push(v)
+			f.ip = h.end
+		case code.OpEndFinally:
+			vm.sp--
+			h := f.handlers[len(f.handlers)-1]
+			f.handlers = f.handlers[:len(f.handlers)-1]
+			c := h.pending
+			switch c.kind {
+			case normal:
+				vm.push(c.value)
+			case throwing:
+				err = c.err
+			case returning:
+				if vm.runFinally(f, c, 0) {
+					continue
+				}
+				if vm.returnValue(c.value) {
+					return c.value
+				}
+			case jumping:
+				if !vm.runFinally(f, c, c.depth) {
+					vm.jumpOut(f, c.target)
+				}
+			}
+		case code.OpLoop:
+			f.loops = append(f.loops, loopRecord{sp: vm.sp, patterns: len(f.patterns)})
+		case code.OpEndLoop:
+			f.loops = f.loops[:len(f.loops)-1]
+		case code.OpBreak:
+			target, depth := vm.operand(f, ins), vm.operand(f, ins)
+			if !vm.runFinally(f, completion{kind: jumping, target: target, depth: depth}, depth) {
+				vm.jumpOut(f, target)
+			}
+
+		case code.OpIter:
+			var it *iterator
+			if it, err = newIterator(vm.pop()); err == nil {
+				vm.push(it)
+			}
+		case code.OpIterNext, code.OpIterNextKey:
+			done := vm.operand(f, ins)
+			it := vm.top().(*iterator)
+			key, value, ok := it.next()
+			switch {
+			case !ok:
+				f.ip = done
+			case op == code.OpIterNext:
+				vm.push(value)
+				vm.push(key)
+			case it.isHash():
+				vm.push(key)
+			default:
+				vm.push(value)
+			}
+
+		case code.OpPatternBegin:
+			fail := vm.operand(f, ins)
+			f.patterns = append(f.patterns, patternRecord{sp: vm.sp - 1, fail: fail, subject: vm.top().Type()})
+		case code.OpPatternEnd:
+			f.patterns = f.patterns[:len(f.patterns)-1]
+		default:
+			err = vm.runPattern(f, ins, op)
+		}
+
+		if err != nil && vm.throw(err, f, start) {
+			return err
+		}
+	}
+}
+
+// operand reads the next two byte operand of the instruction
+func (vm *VM) operand(f *Frame, ins code.Instructions) int {
+	o := int(code.ReadUint16(ins[f.ip:]))
+	f.ip += 2
+	return o
+}
+
+func (vm *VM) byteOperand(f *Frame, ins code.Instructions) int {
+	o := int(code.ReadUint8(ins[f.ip:]))
+	f.ip++
+	return o
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, o)
+	} else {
+		vm.stack[vm.sp] = o
+	}
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) top() object.Object { return vm.stack[vm.sp-1] }
+
+// pushResult pushes the result of an operation, an error is raised
+func (vm *VM) pushResult(res object.Object) *object.Error {
+	if err, ok := res.(*object.Error); ok {
+		return err
+	}
+	vm.push(res)
+	return nil
+}
+
+// load pushes the value of a variable, an unbound one may
+// still name a builtin as it does for the evaluator
+func (vm *VM) load(cell *object.Cell, name string) *object.Error {
+	if cell = cell.Bound(); cell.Value != nil {
+		vm.push(cell.Value)
+		return nil
+	}
+	if b, ok := evaluator.LookupBuiltin(name); ok {
+		vm.push(b)
+		return nil
+	}
+	return newError("identifier not found: " + name)
+}
+
+func (vm *VM) define(cell *object.Cell, name string, constant int) *object.Error {
+	if cell.Const {
+		return newError("cannot redeclare constant: %s", name)
+	}
+	cell.Value = vm.pop()
+	cell.Const = constant == 1
+	return nil
+}
+
+func checkAssign(cell *object.Cell, name string) *object.Error {
+	switch cell = cell.Bound(); {
+	case cell.Value == nil:
+		if _, ok := evaluator.LookupBuiltin(name); ok {
+			return newError("cannot assign to builtin: %s", name)
+		}
+		return newError("identifier not found: " + name)
+	case cell.Const:
+		return newError("cannot assign to constant: %s", name)
+	}
+	return nil
+}
+
+func (vm *VM) buildHash(n int) *object.Error {
+	pairs := make(map[object.HashKey]object.HashPair, n)
+	for i := vm.sp - 2*n; i < vm.sp; i += 2 {
+		key, value := vm.stack[i], vm.stack[i+1]
+		hk, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+		pairs[hk.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	vm.sp -= 2 * n
+	vm.push(&object.Hash{Pairs: pairs})
+	return nil
+}
+
+// spread appends the elements of an array, string or range to arr
+func (vm *VM) spread(val object.Object, arr *object.Array) *object.Error {
+	if val.Type() != object.HASH_OBJ {
+		if it, err := newIterator(val); err == nil {
+			for _, v, ok := it.next(); ok; _, v, ok = it.next() {
+				arr.Elements = append(arr.Elements, v)
+			}
+			return nil
+		}
+	}
+	return newError("cannot spread %s", val.Type())
+}
+
+// indexTarget checks that left[index] below the top may be assigned
+// to, with the messages of the evaluator. A compound assignment gets
+// the current value pushed
+func (vm *VM) indexTarget(compound bool) *object.Error {
+	left, index := vm.stack[vm.sp-2], vm.stack[vm.sp-1]
+
+	var cur object.Object
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			if index.Type() == object.BIG_INTEGER_OBJ {
+				return newError("index out of range: %s (length %d)",
+					index.Inspect(), len(left.Elements))
+			}
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d (length %d)",
+				idx.Value, len(left.Elements))
+		}
+		cur = left.Elements[idx.Value]
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		cur = evaluator.NULL
+		if p, ok := left.Pairs[key.HashKey()]; ok {
+			cur = p.Value
+		}
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	if compound {
+		vm.push(cur)
+	}
+	return nil
+}
+
+// setIndex stores the top into the target indexTarget checked
+func (vm *VM) setIndex() {
+	val := vm.pop()
+	index := vm.pop()
+	switch left := vm.pop().(type) {
+	case *object.Array:
+		left.Elements[index.(*object.Integer).Value] = val
+	case *object.Hash:
+		left.Pairs[index.(object.Hashable).HashKey()] = object.HashPair{Key: index, Value: val}
+	}
+	vm.push(val)
+}
+
+// call calls fn, which sat at bp on the stack below its arguments.
+// A closure in tail position takes over the frame of the caller, any
+// other one is an error past evaluator.MaxCalls frames
+func (vm *VM) call(fn object.Object, args []object.Object, bp int,
+	callPos token.Position, tail bool) *object.Error {
+	switch fn := fn.(type) {
+	case *object.Closure:
+		if err := checkArity(fn.Fn, len(args)); err != nil {
+			return err
+		}
+		call := object.Frame{Function: functionName(fn.Fn), CallPos: callPos}
+
+		f := vm.frames[len(vm.frames)-1]
+		if tail {
+			f.cl, f.ip, f.cells = fn, 0, newCells(fn.Fn.NumLocals)
+			f.trace = pushTrace(f.trace, call)
+		} else {
+			if len(vm.frames) > evaluator.MaxCalls { // the main frame is no call
+				return newError("maximum recursion depth exceeded")
+			}
+			f = newFrame(fn, bp)
+			f.trace = []object.Frame{call}
+			vm.frames = append(vm.frames, f)
+		}
+		f.args, f.prologue = args, true
+		vm.sp = f.bp
+	case *object.Builtin:
+		res := fn.Fn(args...)
+		if err, ok := res.(*object.Error); ok {
+			return err
+		}
+		vm.sp = bp
+		vm.push(res)
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
+	return nil
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
+// checkArity wants an argument for every parameter up to the last one
+// without a default, and no extra arguments unless there is a rest one
+func checkArity(fn *object.CompiledFunction, n int) *object.Error {
+	min, max := fn.MinArgs, fn.NumParams
+	switch {
+	case fn.Rest && n < min:
+		return newError("wrong number of arguments: want at least %d, got %d", min, n)
+	case fn.Rest:
+		return nil
+	case (n < min || n > max) && min == max:
+		return newError("wrong number of arguments: want %d, got %d", min, n)
+	case n < min || n > max:
+		return newError("wrong number of arguments: want %d to %d, got %d", min, max, n)
+	}
+	return nil
+}
+
+// returnValue leaves the current frame with v,
+// it reports whether that was the top level
+func (vm *VM) returnValue(v object.Object) bool {
+	if len(vm.frames) == 1 {
+		return true
+	}
+	f := vm.frames[len(vm.frames)-1]
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	vm.sp = f.bp
+	vm.push(v)
+	return false
+}
+
+// runFinally leaves the try expressions of f above depth, the first
+// one with a finally clause yet to run runs it and c waits for it
+func (vm *VM) runFinally(f *Frame, c completion, depth int) bool {
+	for len(f.handlers) > depth {
+		h := &f.handlers[len(f.handlers)-1]
+		if h.finally != code.None && h.stage != inFinally {
+			vm.enterFinally(f, h, c)
+			return true
+		}
+		f.handlers = f.handlers[:len(f.handlers)-1]
+		vm.sp = h.sp
+	}
+	return false
+}
+
+func (vm *VM) enterFinally(f *Frame, h *handler, c completion) {
+	h.stage = inFinally
+	h.pending = c
+	vm.sp = h.sp
+	f.patterns = f.patterns[:h.patterns]
+	f.loops = f.loops[:h.loops]
+	f.ip = h.finally
+}
+
+// jumpOut breaks or continues the innermost loop of f
+func (vm *VM) jumpOut(f *Frame, target int) {
+	l := f.loops[len(f.loops)-1]
+	vm.sp = l.sp
+	f.patterns = f.patterns[:l.patterns]
+	f.ip = target
+}
+
+// throw raises err from the instruction at start of f. The frames are
+// left until a try expression takes it, their calls go on its stack.
+// It reports whether err left the program
+func (vm *VM) throw(err *object.Error, f *Frame, start int) bool {
+	if !err.Pos.IsValid() {
+		pos := f.cl.Fn.SourceMap.Lookup(start)
+		if !pos.IsValid() && f.prologue {
+			// binding the arguments failed, the call is to blame
+			pos = f.trace[len(f.trace)-1].CallPos
+		}
+		err.Pos = pos
+	}
+
+	for {
+		f := vm.frames[len(vm.frames)-1]
+		for len(f.handlers) > 0 {
+			h := &f.handlers[len(f.handlers)-1]
+			if h.stage == inTry && h.catch != code.None {
+				h.stage = inCatch
+				vm.sp = h.sp
+				f.patterns = f.patterns[:h.patterns]
+				f.loops = f.loops[:h.loops]
+				vm.push(err.Caught())
+				f.ip = h.catch
+				return false
+			}
+			if h.stage != inFinally && h.finally != code.None {
+				vm.enterFinally(f, h, completion{kind: throwing, err: err})
+				return false
+			}
+			f.handlers = f.handlers[:len(f.handlers)-1]
+		}
+
+		if len(vm.frames) == 1 {
+			return true
+		}
+		trace := f.trace
+		if f.prologue {
+			trace = trace[:len(trace)-1]
+		}
+		for i := len(trace) - 1; i >= 0; i-- {
+			err.Stack = append(err.Stack, trace[i])
+		}
+		vm.frames = vm.frames[:len(vm.frames)-1]
+	}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
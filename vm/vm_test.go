@@ -0,0 +1,283 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/clg0803/circus/ast"
+	"github.com/clg0803/circus/compiler"
+	"github.com/clg0803/circus/evaluator"
+	"github.com/clg0803/circus/lexer"
+	"github.com/clg0803/circus/object"
+	"github.com/clg0803/circus/parser"
+)
+
+// TestAgainstEvaluator runs every program on the evaluator and on
+// the vm, which must agree on the value or on the whole traceback
+func TestAgainstEvaluator(t *testing.T) {
+	tests := []string{
+		// literals and operators
+		"5", "-7", "!true", "!!5", "1 + 2 * 3 - 4 / 2", "(5 + 10 * 2 + 15 / 3) * 2 + -10",
+		"7 % 3", "-7 % 3", "2 ** 10", "2 ** 100", "9223372036854775807 + 1",
+		"-9223372036854775808 - 1", "(2 ** 64) / (2 ** 32)", "2 ** 64 == 2 ** 64",
+		"1.5 + 2", "10 / 4.0", "0.1 + 0.2", "1e3 * 2", "2 ** 0.5", "-0.0",
+		"6 & 3", "6 | 3", "6 ^ 3", "1 << 70", "256 >> 4",
+		"1 < 2", "2 <= 1", "1.5 > 1", "2 >= 2", "1 == 1.0", "true != false", `"a" == "a"`,
+		`"a" < "b"`, "[1, 2] == [1, 2]", "1 / 0", "1 % 0", "1.0 / 0", "1 + true", "-true",
+		`"abc" - "a"`, "true && false", "false || 1", "0 && 1 / 0", "1 || 1 / 0",
+		"null", "if (null) { 1 } else { 2 }",
+
+		// strings
+		`"Hello" + " " + "World!"`, `"héllo"[1]`, `len("héllo")`, `"abc"[5]`,
+		`let name = "Ann"; "Hello ${name}"`, `"${1} + ${2.5} = ${1 + 2.5}"`,
+		`"${true} ${[1, "a"]} ${if (false) { 1 }}"`, `let f = fn(x) { "<${x}>" }; "${f(f("y"))}"`,
+		`"cost: \${price}"`, `"a ${missing} b"`,
+
+		// conditionals
+		"if (true) { 10 }", "if (false) { 10 }", "if (1 < 2) { 10 } else { 20 }",
+		"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", "if (false) { 1 } else if (false) { 2 }",
+		"if (true) { }", "let x = if (true) { }; x",
+
+		// bindings and assignment
+		"let a = 5; a", "let a = 5; let b = a; let c = a + b + 5; c", "let a = 1",
+		"foobar", "let a = 1; a = 2; a", "let a = 1; a += 2; a *= 3; a", "a = 1",
+		"len = 1", "let x = 1; x += true", "const c = 1; c", "const c = 1; c = 2",
+		"const c = 1; c += 1", "const c = 1; let f = fn() { c = 2 }; f()",
+		"const c = 1; let c = 2", "const c = 1; let f = fn() { let c = 2; c = 3 }; f()",
+		"let x = 1; let x = 2; x", "let x = 1; if (true) { let x = 2 }; x",
+		"let f = fn() { x }; let x = 3; f()", "let f = fn() { let y = 1; let g = fn() { y }; y = 2; g() }; f()",
+
+		// a use before the let that shadows it gets the outer variable
+		"let x = 1; let f = fn() { let y = x; let x = 2; y }; f()",
+		"let x = 1; let f = fn() { let y = x; let x = 2; [y, x] }; f()",
+		"let f = fn() { let y = x; let x = 2; y }; let x = 3; f()",
+		"let x = 1; let f = fn() { x = 5; let x = 2; x }; [f(), x]",
+		"let x = 1; let f = fn() { let g = fn() { x }; let a = g(); let x = 2; [a, g()] }; f()",
+		"let x = 1; let f = fn() { let g = fn() { x }; if (false) { let x = 2 }; g() }; f()",
+		"let x = 1; let f = fn(a = x) { let x = 2; a }; f()",
+		"let x = 1; let f = fn() { let g = fn() { let y = x; let x = 3; y }; let x = 2; g() }; f()",
+		"let x = 1; for (i in [1, 2]) { let y = x; let x = y + 10 } x",
+		"let s = []; let x = 0; for (i in [1, 2]) { s = push(s, x); let x = i } s",
+		"let x = 1; match (2) { n => { let y = x; let x = n; y + x } }",
+		"let x = 1; try { 1 / 0 } catch (e) { let y = x; let x = 2; y + x }",
+		`let f = fn() { let n = len("ab"); let len = fn(s) { 0 }; n + len("abc") }; f()`,
+		"let f = fn() { let y = x; let x = 2; y }; f()",
+		"const c = 1; let f = fn() { c = 2; let c = 3 }; f()",
+
+		// arrays and hashes
+		"[1, 2 * 2, 3 + 3]", "[1, 2, 3][1]", "[1, 2, 3][3]", "[1, 2, 3][-1]", `[1]["a"]`,
+		"let a = [1, 2, 3]; a[0] + a[1] + a[2]", `{"one": 10 - 9, "two": 1 + 1, true: 3, 4: 4}`,
+		`{"foo": 5}["foo"]`, `{"foo": 5}["bar"]`, `{}[fn(x) { x }]`, `{[1]: 2}`,
+		"let a = [1, 2, 3]; a[0] = 10; a[0]", "let a = [1, 2, 3]; a[2] += 5; a",
+		"let a = [[1, 2], [3, 4]]; a[1][0] = 9; a", "let a = [1, 2, 3]; a[3] = 0",
+		"let a = [1]; a[2 ** 64] = 0", `let a = [1]; a["x"] = 0`, `let s = "abc"; s[0] = "x"`,
+		`let h = {"a": 1}; h["a"] = 2; h`, `let h = {"n": 1}; h["n"] *= 10; h["n"]`,
+		`let h = {}; h["m"] += 1`, `let h = {}; h[[1]] = 1`, "5[0] = 1",
+		`{"a": 1, "a": 2}["a"]`, `let s = []; let k = fn(x) { s = push(s, x); "k" }; {k(1): 1, k(2): 2, "z": k(3)}; s`,
+		`let n = 0; let h = {"a": n += 1, "a": n *= 10}; [h["a"], n]`,
+
+		// builtins
+		`len("")`, `len("four")`, "len([1, 2])", "len(1)", `len("one", "two")`,
+		"first([1, 2])", "first([])", "last([1, 2])", "rest([1, 2, 3])", "rest([])",
+		"push([1], 2)", "push(1, 1)", `delete({"a": 1, "b": 2}, "a")`, `delete({"a": 1}, "z")`,
+		"delete([1], 0)", "delete({})", "range(5)", "range(10, 0, -3)", "len(range(2, 5))",
+		"range(1, 2, 0)", `puts()`, "let l = len; l([1])", "len", "first",
+
+		// functions and closures
+		"let identity = fn(x) { x; }; identity(5);", "let double = fn(x) { x * 2; }; double(5);",
+		"fn(x) { x; }(5)", "fn(x) { x + 2; }", "let add = fn(a, b) { a + b }; add",
+		"let f = fn() { return 1; 2 }; f()", "return 10; 9;", "if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		"let newAdder = fn(x) { fn(y) { x + y }; }; let addTwo = newAdder(2); addTwo(2);",
+		`let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c(); c()`,
+		`let f = fn() { let a = 1; let g = fn() { let h = fn() { a += 1 }; h(); a }; g() }; f()`,
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(20)",
+		`let map = fn(arr, f) { let iter = fn(arr, acc) { if (len(arr) == 0) { acc } else { iter(rest(arr), push(acc, f(first(arr)))) } }; iter(arr, []) }; map([1, 2, 3], fn(x) { x * x })`,
+		`let reduce = fn(arr, initial, f) { let iter = fn(arr, result) { if (len(arr) == 0) { result } else { iter(rest(arr), f(result, first(arr))) } }; iter(arr, initial) }; reduce([1, 2, 3, 4, 5], 0, fn(a, b) { a + b })`,
+		"let f = fn(x, y) { x }; f(1)", "let f = fn() { 5(1) }; f()", "5()", `"a"(1)`,
+		"let f = fn(x) { x }; f(1 / 0)", "let f = fn(n) { if (n == 0) { 1 / 0 } else { f(n - 1) + 1 } }; f(3)",
+		"let f = fn(x, y = 10) { x + y }; f(1)", "let f = fn(x, y = x * 2) { y }; f(4)",
+		"let y = 1; let f = fn(x = y) { x }; let y = 2; f()", "let f = fn(x = 1 / 0) { x }; f()",
+		"let f = fn(first, ...others) { others }; f(1, 2, 3)", "let f = fn(a, b = 2, ...r) { a + b + len(r) }; f(1, 5, 0, 0)",
+		"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(1, ...[2, 3])", "let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(...range(1, 4))",
+		`let f = fn(...cs) { cs }; f(..."abc")`, "[0, ...[1, 2], 3]", `[...{"a": 1}]`, "let f = fn(x) { x }; f(...5)",
+		"let f = fn(x, y = 1) { x }; f()", "let f = fn(x, ...r) { x }; f()", "let f = fn(x, y) { x }; f(...[1, 2, 3])",
+		"let f = fn(...xs) { xs[0] = 9 }; let a = [1]; f(...a); a",
+		"let f = fn(x) { let x = x + 1; x }; f(1)",
+
+		// tail calls
+		"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(1000000, 0)",
+		"let count = fn(n, acc) { if (n == 0) { return acc; } return count(n - 1, acc + 1); }; count(1000000, 0)",
+		`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+even(100001)`,
+		"let f = fn(n) { if (n == 0) { len(1) } else { f(n - 1) } }; f(3)",
+		"let f = fn(n) { if (n == 0) { f() } else { f(n - 1) } }; f(3)",
+		"let f = fn(...xs) { if (len(xs) > 3) { xs } else { f(...xs, len(xs)) } }; f()",
+		"let f = fn(n) { 1 + f(n) }; f(1)",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10000)",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(20000)",
+
+		// loops
+		"let i = 0; while (i < 10) { i += 1 } i", "while (false) { 1 }",
+		"let s = 0; for (i, x in [10, 20, 30]) { s += i * x } s", `let s = ""; for (c in "héllo") { s = c + s } s`,
+		`let s = ""; for (k in {"b": 2, "a": 1, "c": 3}) { s += k } s`, `let s = 0; for (k, v in {"b": 2, "a": 1}) { s += v } s`,
+		"let s = 0; for (i in range(10, 0, -3)) { s += i } s", "for (x in 5) { x }", "for (x in [1]) { x }",
+		"let i = 0; while (true) { i += 1; if (i == 5) { break } } i",
+		"let s = 0; for (i in range(10)) { if (i % 2 == 0) { continue } s += i } s",
+		"let n = 0; for (i in range(3)) { for (j in range(3)) { if (j == 1) { break } n += 1 } } n",
+		"let f = fn() { for (i in range(10)) { if (i == 4) { return i } } } f()",
+		"for (i in range(3)) { if (i == 1) { 1 / 0 } }",
+		"let i = 0; while (i < 3) { try { i += 1; continue } finally { i += 10 } } i",
+		"let i = 0; while (true) { try { break } finally { i = 1 } } i",
+		"let fs = []; for (i in range(3)) { fs = push(fs, fn() { i }) } fs[0]() + fs[2]()",
+		"let x = 1; for (x in [5]) { x } x", "let a = [1, 2, 3]; for (i, x in a) { a[i] = x * 2 } a",
+		"let n = 0; for (i in range(100000)) { n += 1 } n",
+		"let n = 0; for (i in [1, 2]) { for (x in [1, 2]) { n += 1; let a = [1, if (true) { break }] } } n",
+		"let n = 0; while (n < 5) { n += 1; len([if (n < 5) { continue }]) } n",
+		"let n = 0; for (i in [1, 2, 3]) { n += [1, match (i) { 2 => { continue }, _ => i }][1] } n",
+		"let n = 0; for (i in [1, 2]) { [1, try { break } finally { n = 7 }] } n",
+		"let n = 0; for (c in [true, false]) { let v = if (c) { continue }; n += 1 } n",
+		"let n = 0; for (i in [1, 2]) { n = n + if (true) { break } } n",
+		"let f = fn() { let v = if (true) { return 3 }; 4 }; f()",
+
+		// try, catch and finally
+		"try { 1 } catch (e) { 2 }", `try { 1 / 0 } catch (e) { e }`, `try { throw "bad input" } catch (e) { e }`,
+		`try { throw {"code": 7} } catch (e) { e["code"] }`, "let f = fn() { 1 / 0 }; try { f() } catch (e) { e }",
+		"try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e }",
+		"try { throw 1 } catch (e) { let x = e; }; x", "let x = 0; try { x } finally { 5 }",
+		`try { 1 } catch (e) { 2 } finally { throw "from finally" }`,
+		"let f = fn() { try { return 1 } finally { return 2 } }; f()",
+		"let f = fn() { try { throw 1 } catch (e) { return 10 } finally { 3 }; 20 }; f()",
+		"let f = fn() { try { 1 } finally { 2 }; 3 }; f()", `try { throw "uncaught" } finally { 1 }`,
+		"let log = []; let f = fn() { try { try { return 1 } finally { log = push(log, 1) } } finally { log = push(log, 2) } }; [f(), log]",
+		"let n = 0; for (i in range(3)) { try { try { if (i == 1) { break } } finally { n += 1 } } finally { n += 10 } } n",
+		"let f = fn(n) { try { g(n) } catch (e) { e } }; let g = fn(n) { throw n }; f(7)",
+		"let f = fn(x) { x }; try { f() } catch (e) { e }",
+		`let outer = fn() { inner() }; let inner = fn() { throw "x" }; try { outer() } catch (e) { e["stack"] }`,
+		"try { let [a] = 1 } catch (e) { e }", "let f = fn() { try { 1 / 0 } catch (e) { e }; }; f()",
+
+		// match
+		`match (1) { 1 => "one", _ => "other" }`, `match (2) { 1, 2, 3 => "few", _ => "many" }`,
+		`match (-3) { -3 => "minus three" }`, `match (2.0) { 2 => "two" }`, `match (5) { 1 => 1 }`,
+		`match ([1, 2, 3]) { [a, b] => 0, [a, b, c] => a + b + c }`, `match ([2, 9]) { [1, x] => x, [x, 9] => x * 10 }`,
+		`match ({"kind": "circle", "r": 2}) { {"kind": "square", "side": s} => s * s, {"kind": "circle", "r": r} => 3 * r * r }`,
+		`match ({"a": 1}) { {"b": x} => x, _ => "no b" }`, `match ("str") { [a] => a, {"k": v} => v, s => s }`,
+		`match (12) { n if n < 10 => "small", n if n < 100 => "medium", _ => "large" }`,
+		`match ([3, 4]) { [a, b] if a > b => "desc", [a, b] => "asc" }`, `match (5) { n => { let doubled = n * 2; doubled } }`,
+		`let n = 1; match (5) { n => n }; n`, `let x = 0; match ([1, 2]) { [x, 3] => x, _ => x }`,
+		`match (1 / 0) { _ => 1 }`, `match (1) { n if n / 0 => 1 }`,
+		`let f = fn(x) { match (x) { 0 => { return "zero" }, _ => 1 }; "after" }; f(0)`,
+		`let f = fn(xs) { match (xs) { [] => 0, [x, ...r] => x + f(r) } }; f([1, 2, 3])`,
+		`match ([1, [2, 3]]) { [1, [x, _]] => fn() { x } }()`,
+
+		// destructuring
+		"let [a, b, ...rest] = [1, 2, 3, 4]; [a, b, rest]", "let [a, [b, c]] = [1, [2, 3]]; a + b + c",
+		"let [a, b = a * 2] = [3]; b", `let {name, age} = {"name": "ann", "age": 30}; name`,
+		`let {name = "anon"} = {}; name`, `let {home: {city}} = {"home": {"city": "oslo"}}; city`,
+		`let {pos: [x, y] = [3, 4]} = {}; x * y`, `let {1: one, true: yes} = {1: "a", true: "b"}; one + yes`,
+		"let [a, b] = [1, 2, 3]", "let [a, b, c = 1, ...r] = [1]", "let [a, b] = 5", "let [a, [b]] = [1, 2]",
+		`let {name, age} = {"name": "ann"}`, `let [1, x] = [2, 3]`, "let [a = 1 / 0] = []",
+		"const [a, b] = [1, 2]; a = 3", "const c = 1; let [c] = [2]",
+		`let f = fn({x = 0, y = 0}) { x + y }; f({"y": 2})`, "let f = fn([a, b]) { a + b }; f([1])",
+		`let f = fn({name}) { name }; f(1)`,
+	}
+
+	for _, input := range tests {
+		want := evaluator.Eval(parse(t, input), object.NewEnvirnment())
+		got := run(t, input)
+		if want == nil {
+			want = evaluator.NULL
+		}
+
+		if wantErr, ok := want.(*object.Error); ok {
+			gotErr, ok := got.(*object.Error)
+			if !ok {
+				t.Errorf("%q: expected an error. got=%T (%s)", input, got, got.Inspect())
+				continue
+			}
+			if gotErr.Traceback() != wantErr.Traceback() {
+				t.Errorf("%q: wrong traceback. expected=\n%s\ngot=\n%s",
+					input, wantErr.Traceback(), gotErr.Traceback())
+			}
+			continue
+		}
+		if got.Type() != want.Type() || inspect(got) != inspect(want) {
+			t.Errorf("%q: expected=%s (%s), got=%s (%s)",
+				input, inspect(want), want.Type(), inspect(got), got.Type())
+		}
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let loop = fn(n) {
+  if (n == 0) { 1 / 0 } else { loop(n - 1) }
+};
+let start = fn() { loop(1000) };
+start();`
+
+	err, ok := run(t, input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+
+	traceback := `Traceback (most recent call last):
+  script.mk:5:1, in <main>
+  script.mk:4:20, in start
+  script.mk:2:32, in loop
+  script.mk:2:17, in loop
+ERROR: division by zero`
+	if err.Traceback() != traceback {
+		t.Errorf("wrong traceback. expected=\n%s\ngot=\n%s", traceback, err.Traceback())
+	}
+}
+
+// a tree from elsewhere than the parser may break outside of a loop,
+// the compiler refuses it
+func TestBreakOutsideLoop(t *testing.T) {
+	program := parse(t, "while (true) { fn() { 1 } }")
+	fn := program.Statements[0].(*ast.WhileStatement).Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	fn.Body.Statements[0] = &ast.BreakStatement{Token: fn.Body.Statements[0].(*ast.ExpressionStatement).Token}
+
+	err := compiler.New().Compile(program)
+	if err == nil || err.Error() != "script.mk:1:23: break outside of a loop" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+// inspect is Inspect with the pairs of hashes in order
+func inspect(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.Array:
+		elements := make([]string, len(obj.Elements))
+		for i, el := range obj.Elements {
+			elements[i] = inspect(el)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *object.Hash:
+		pairs := []string{}
+		for _, pair := range obj.SortedPairs() {
+			pairs = append(pairs, inspect(pair.Key)+": "+inspect(pair.Value))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+	return obj.Inspect()
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.NewFile("script.mk", input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Errorf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+	c := compiler.New()
+	if err := c.Compile(parse(t, input)); err != nil {
+		t.Fatalf("%q: compiler error: %s", input, err)
+	}
+	return New(c.Bytecode()).Run()
+}